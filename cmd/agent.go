@@ -297,8 +297,13 @@ func (a *Agent) sourceToSink(src source.Source, sk sink.Sink) (err error) {
 
 	go func() {
 		checkpoints := sk.Apply(changes)
+		durable, _ := src.(source.DurableCommitter)
 		for cp := range checkpoints {
+			// the checkpoints of the sinks are committed after they are persisted
 			src.Commit(cp)
+			if durable != nil {
+				durable.CommitDurable(cp)
+			}
 		}
 	}()
 	go func() {
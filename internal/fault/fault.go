@@ -0,0 +1,76 @@
+// Package fault injects deterministic failures into the sources and sinks for testing their resilience paths.
+package fault
+
+import (
+	"errors"
+	"sync"
+)
+
+type Op string
+
+const (
+	Receive Op = "receive"
+	Decode  Op = "decode"
+	Apply   Op = "apply"
+)
+
+var ErrInjected = errors.New("injected fault")
+
+type rule struct {
+	op    Op
+	lsn   uint64
+	count int
+	err   error
+}
+
+// Injector fails the operations matching its rules, and each rule fires only once.
+// A nil Injector never fails, so it can be left unset in production.
+type Injector struct {
+	mu     sync.Mutex
+	rules  []rule
+	counts map[Op]int
+}
+
+func NewInjector() *Injector {
+	return &Injector{counts: make(map[Op]int)}
+}
+
+// FailAtLSN fails the op when it is performed at the lsn, the ErrInjected is returned if err is nil
+func (f *Injector) FailAtLSN(op Op, lsn uint64, err error) *Injector {
+	return f.add(rule{op: op, lsn: lsn, err: err})
+}
+
+// FailAtCount fails the nth call of the op, starting from 1, the ErrInjected is returned if err is nil
+func (f *Injector) FailAtCount(op Op, n int, err error) *Injector {
+	return f.add(rule{op: op, count: n, err: err})
+}
+
+func (f *Injector) add(r rule) *Injector {
+	if r.err == nil {
+		r.err = ErrInjected
+	}
+	f.mu.Lock()
+	f.rules = append(f.rules, r)
+	f.mu.Unlock()
+	return f
+}
+
+// Check counts the call of the op and returns the error of the first matched rule
+func (f *Injector) Check(op Op, lsn uint64) error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[op]++
+	for i, r := range f.rules {
+		if r.op != op {
+			continue
+		}
+		if (r.count != 0 && r.count == f.counts[op]) || (r.count == 0 && r.lsn == lsn) {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return r.err
+		}
+	}
+	return nil
+}
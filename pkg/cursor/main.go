@@ -9,10 +9,17 @@ import (
 	"github.com/jackc/pglogrepl"
 )
 
+const GlobalSeqProperty = "gseq"
+
+// CheckpointProperty carries the checkpoint of the message whose key is not the checkpoint, like the tombstone
+const CheckpointProperty = "cp"
+
 type Checkpoint struct {
 	LSN  uint64
 	Seq  uint32
 	Data []byte
+	// GlobalSeq is a monotonic sequence across the whole stream assigned by the source
+	GlobalSeq uint64
 }
 
 func (cp *Checkpoint) Equal(cp2 Checkpoint) bool {
@@ -46,9 +53,18 @@ func (cp *Checkpoint) FromKey(str string) error {
 }
 
 func ToCheckpoint(msg pulsar.Message) (cp Checkpoint, err error) {
-	if err = cp.FromKey(msg.Key()); err != nil {
+	key, ok := msg.Properties()[CheckpointProperty]
+	if !ok {
+		key = msg.Key()
+	}
+	if err = cp.FromKey(key); err != nil {
 		return
 	}
+	if gseq, ok := msg.Properties()[GlobalSeqProperty]; ok {
+		if cp.GlobalSeq, err = strconv.ParseUint(gseq, 10, 64); err != nil {
+			return
+		}
+	}
 	cp.Data = msg.ID().Serialize()
 	return
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 
 	"github.com/jackc/pgconn"
@@ -86,6 +87,59 @@ type PGXSourceDumper struct {
 	mu   sync.Mutex
 
 	SkipLSNCheck bool
+	// Strategy dumps the requested pages after the LSN is checked in the same transaction, defaults to the PageSnapshotStrategy
+	Strategy SnapshotStrategy
+
+	// SnapshotName is the snapshot exported by the replication slot for the bootstrap dump, which is required for the
+	// dump to be consistent with the slot position. The next LoadDump imports it into the bootstrap transaction, which
+	// is at least repeatable read, and clears it, since the exported snapshot expires once its exporter continues.
+	// The later dumps reuse the bootstrap transaction until the EndBootstrap, and the LoadDump fails with the
+	// ErrSnapshotImport if the snapshot can not be imported. Otherwise, like the on-demand backfills after the bootstrap,
+	// the dump runs at the IsoLevel, defaults to the read committed of the server, and each page range is only
+	// consistent with itself.
+	SnapshotName string
+	IsoLevel     pgx.TxIsoLevel
+
+	bootstrap pgx.Tx
+}
+
+// ErrSnapshotImport means the SnapshotName can not be imported, like it is expired or not exported
+var ErrSnapshotImport = errors.New("failed to import the exported snapshot")
+
+// EndBootstrap ends the bootstrap transaction of the SnapshotName, and the later dumps run at the IsoLevel
+func (p *PGXSourceDumper) EndBootstrap() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.bootstrap == nil {
+		return nil
+	}
+	err := p.bootstrap.Rollback(context.Background())
+	p.bootstrap = nil
+	return err
+}
+
+func (p *PGXSourceDumper) txOptions() pgx.TxOptions {
+	opts := pgx.TxOptions{IsoLevel: p.IsoLevel}
+	if p.SnapshotName != "" && opts.IsoLevel != pgx.Serializable {
+		opts.IsoLevel = pgx.RepeatableRead
+	}
+	return opts
+}
+
+// SnapshotStrategy dumps the rows of the requested pages with the given transaction
+type SnapshotStrategy interface {
+	Dump(ctx context.Context, tx pgx.Tx, info *pb.DumpInfoResponse) ([]*pb.Change, error)
+}
+
+// PageSnapshotStrategy selects all the rows in the requested pages by their ctids with the DumpQuery
+type PageSnapshotStrategy struct{}
+
+func (PageSnapshotStrategy) Dump(ctx context.Context, tx pgx.Tx, info *pb.DumpInfoResponse) ([]*pb.Change, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf(DumpQuery, info.Schema, info.Table), info.PageBegin, info.PageEnd)
+	if err != nil {
+		return nil, err
+	}
+	return ScanChanges(rows, info)
 }
 
 func (p *PGXSourceDumper) LoadDump(minLSN uint64, info *pb.DumpInfoResponse) ([]*pb.Change, error) {
@@ -105,6 +159,7 @@ func (p *PGXSourceDumper) LoadDump(minLSN uint64, info *pb.DumpInfoResponse) ([]
 
 func (p *PGXSourceDumper) Stop() {
 	p.mu.Lock()
+	p.bootstrap = nil
 	p.conn.Close(context.Background())
 	p.mu.Unlock()
 }
@@ -117,10 +172,35 @@ func (p *PGXSourceDumper) Stop() {
 //	ref: https://github.com/postgres/postgres/blob/c3b011d9918100c6ec2d72297fb51635bce70e80/src/include/access/htup_details.h#L573-L575
 const DumpQuery = `select * from "%s"."%s" where ctid = any(array(select format('(%%s,%%s)', i, j)::tid from generate_series($1::int,$2::int) as gs(i), generate_series(1,(current_setting('block_size')::int-24)/28) as gs2(j)))`
 
+// begin starts the transaction of the dump, which is a savepoint of the bootstrap transaction if there is one,
+// so that the failed dump does not abort the bootstrap transaction
+func (p *PGXSourceDumper) begin(ctx context.Context) (pgx.Tx, error) {
+	if p.SnapshotName != "" {
+		if p.bootstrap != nil {
+			p.bootstrap.Rollback(ctx)
+			p.bootstrap = nil
+		}
+		tx, err := p.conn.BeginTx(ctx, p.txOptions())
+		if err != nil {
+			return nil, err
+		}
+		if _, err = tx.Exec(ctx, "SET TRANSACTION SNAPSHOT "+quoteLiteral(p.SnapshotName)); err != nil {
+			tx.Rollback(ctx)
+			return nil, fmt.Errorf("%w %s: %w", ErrSnapshotImport, p.SnapshotName, err)
+		}
+		p.bootstrap = tx
+		p.SnapshotName = ""
+	}
+	if p.bootstrap != nil {
+		return p.bootstrap.Begin(ctx)
+	}
+	return p.conn.BeginTx(ctx, p.txOptions())
+}
+
 func (p *PGXSourceDumper) load(minLSN uint64, info *pb.DumpInfoResponse) ([]*pb.Change, error) {
 	ctx := context.Background()
 
-	tx, err := p.conn.BeginTx(ctx, pgx.TxOptions{})
+	tx, err := p.begin(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +212,11 @@ func (p *PGXSourceDumper) load(minLSN uint64, info *pb.DumpInfoResponse) ([]*pb.
 		}
 	}
 
-	rows, err := tx.Query(ctx, fmt.Sprintf(DumpQuery, info.Schema, info.Table), info.PageBegin, info.PageEnd)
+	strategy := p.Strategy
+	if strategy == nil {
+		strategy = PageSnapshotStrategy{}
+	}
+	changes, err := strategy.Dump(ctx, tx, info)
 	if err != nil {
 		var pge *pgconn.PgError
 		if errors.As(err, &pge) && pge.Code == "42P01" {
@@ -140,6 +224,12 @@ func (p *PGXSourceDumper) load(minLSN uint64, info *pb.DumpInfoResponse) ([]*pb.
 		}
 		return nil, err
 	}
+	return changes, nil
+}
+
+// ScanChanges converts the rows into changes of the table in the info, and closes the rows
+func ScanChanges(rows pgx.Rows, info *pb.DumpInfoResponse) ([]*pb.Change, error) {
+	defer rows.Close()
 
 	var changes []*pb.Change
 	for rows.Next() {
@@ -158,7 +248,11 @@ func (p *PGXSourceDumper) load(minLSN uint64, info *pb.DumpInfoResponse) ([]*pb.
 		}
 		changes = append(changes, change)
 	}
-	return changes, nil
+	return changes, rows.Err()
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
 }
 
 func checkLSN(ctx context.Context, tx pgx.Tx, minLSN uint64) (err error) {
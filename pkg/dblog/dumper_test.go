@@ -3,6 +3,7 @@ package dblog
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/jackc/pglogrepl"
@@ -99,3 +100,186 @@ func TestPGXSourceDumper(t *testing.T) {
 		}
 	}
 }
+
+type evenSnapshotStrategy struct{}
+
+func (evenSnapshotStrategy) Dump(ctx context.Context, tx pgx.Tx, info *pb.DumpInfoResponse) ([]*pb.Change, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf(`select * from "%s"."%s" where id %% 2 = 0`, info.Schema, info.Table))
+	if err != nil {
+		return nil, err
+	}
+	return ScanChanges(rows, info)
+}
+
+func TestPGXSourceDumper_SnapshotStrategy(t *testing.T) {
+	ctx := context.Background()
+	postgresURL := test.GetPostgresURL()
+	conn, err := pgx.Connect(ctx, postgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "CREATE TABLE t2 AS SELECT * FROM generate_series(1,10) AS id")
+
+	dumper, err := NewPGXSourceDumper(ctx, postgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dumper.Stop()
+	dumper.SkipLSNCheck = true
+	dumper.Strategy = evenSnapshotStrategy{}
+
+	changes, err := dumper.LoadDump(0, &pb.DumpInfoResponse{Schema: "public", Table: "t2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 5 {
+		t.Fatalf("unexpected %v", changes)
+	}
+	for i, change := range changes {
+		var id pgtype.Int4
+		if err := id.DecodeBinary(conn.ConnInfo(), change.New[0].GetBinary()); err != nil {
+			t.Fatal(err)
+		}
+		if change.Table != "t2" || id.Int != int32(i+1)*2 {
+			t.Fatalf("unexpected %v", change)
+		}
+	}
+
+	if _, err := dumper.LoadDump(0, &pb.DumpInfoResponse{Schema: "public", Table: "any"}); !errors.Is(err, ErrMissingTable) {
+		t.Fatal(err)
+	}
+}
+
+// isolationSnapshotStrategy dumps the isolation level of the transaction and the ids of the rows
+type isolationSnapshotStrategy struct {
+	isolation *string
+}
+
+func (s isolationSnapshotStrategy) Dump(ctx context.Context, tx pgx.Tx, info *pb.DumpInfoResponse) ([]*pb.Change, error) {
+	if err := tx.QueryRow(ctx, "select current_setting('transaction_isolation')").Scan(s.isolation); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, fmt.Sprintf(`select * from "%s"."%s"`, info.Schema, info.Table))
+	if err != nil {
+		return nil, err
+	}
+	return ScanChanges(rows, info)
+}
+
+func TestPGXSourceDumper_TxOptions(t *testing.T) {
+	for _, tc := range []struct {
+		snapshot string
+		level    pgx.TxIsoLevel
+		expected pgx.TxIsoLevel
+	}{
+		{expected: ""},
+		{level: pgx.RepeatableRead, expected: pgx.RepeatableRead},
+		{snapshot: "00000003-00000002-1", expected: pgx.RepeatableRead},
+		{snapshot: "00000003-00000002-1", level: pgx.ReadCommitted, expected: pgx.RepeatableRead},
+		{snapshot: "00000003-00000002-1", level: pgx.Serializable, expected: pgx.Serializable},
+	} {
+		dumper := &PGXSourceDumper{SnapshotName: tc.snapshot, IsoLevel: tc.level}
+		if opts := dumper.txOptions(); opts.IsoLevel != tc.expected {
+			t.Fatalf("unexpected %v of %v", opts.IsoLevel, tc)
+		}
+	}
+}
+
+func TestPGXSourceDumper_SnapshotName(t *testing.T) {
+	ctx := context.Background()
+	postgresURL := test.GetPostgresURL()
+	conn, err := pgx.Connect(ctx, postgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "CREATE TABLE t3 AS SELECT * FROM generate_series(1,10) AS id")
+
+	// the exported snapshot is valid until the exporting transaction ends
+	exporter, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Rollback(ctx)
+	var snapshot string
+	if err = exporter.QueryRow(ctx, "select pg_export_snapshot()").Scan(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := pgx.Connect(ctx, postgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close(ctx)
+	if _, err = other.Exec(ctx, "INSERT INTO t3 SELECT * FROM generate_series(11,20)"); err != nil {
+		t.Fatal(err)
+	}
+
+	dumper, err := NewPGXSourceDumper(ctx, postgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dumper.Stop()
+	dumper.SkipLSNCheck = true
+
+	var isolation string
+	dumper.Strategy = isolationSnapshotStrategy{isolation: &isolation}
+
+	// the dump at the exported snapshot does not see the rows inserted after it
+	dumper.SnapshotName = snapshot
+	changes, err := dumper.LoadDump(0, &pb.DumpInfoResponse{Schema: "public", Table: "t3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 10 || isolation != "repeatable read" || dumper.SnapshotName != "" {
+		t.Fatalf("unexpected %v %v %v", len(changes), isolation, dumper.SnapshotName)
+	}
+
+	// the snapshot is imported once, and the later dumps of the bootstrap stay at it after the snapshot expires
+	if err = exporter.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dumper.LoadDump(0, &pb.DumpInfoResponse{Schema: "public", Table: "missing"}); !errors.Is(err, ErrMissingTable) {
+		t.Fatalf("unexpected %v", err)
+	}
+	changes, err = dumper.LoadDump(0, &pb.DumpInfoResponse{Schema: "public", Table: "t3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 10 || isolation != "repeatable read" {
+		t.Fatalf("unexpected %v %v", len(changes), isolation)
+	}
+
+	// the expired snapshot can not be imported again
+	dumper.SnapshotName = snapshot
+	if _, err = dumper.LoadDump(0, &pb.DumpInfoResponse{Schema: "public", Table: "t3"}); !errors.Is(err, ErrSnapshotImport) {
+		t.Fatalf("unexpected %v", err)
+	}
+	dumper.SnapshotName = ""
+
+	// the dump after the bootstrap runs at the chosen isolation and sees the latest rows
+	if err = dumper.EndBootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		level    pgx.TxIsoLevel
+		expected string
+	}{
+		{level: "", expected: "read committed"},
+		{level: pgx.RepeatableRead, expected: "repeatable read"},
+	} {
+		dumper.IsoLevel = tc.level
+		changes, err = dumper.LoadDump(0, &pb.DumpInfoResponse{Schema: "public", Table: "t3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 20 || isolation != tc.expected {
+			t.Fatalf("unexpected %v %v", len(changes), isolation)
+		}
+	}
+}
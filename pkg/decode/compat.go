@@ -0,0 +1,112 @@
+package decode
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type MismatchKind int
+
+const (
+	// MismatchTableMissing means the table of the source does not exist in the target
+	MismatchTableMissing MismatchKind = iota
+	// MismatchColumnMissing means the column of the source does not exist in the target
+	MismatchColumnMissing
+	// MismatchType means the column has different types in the source and the target
+	MismatchType
+	// MismatchNullability means the column is nullable in the source but NOT NULL in the target
+	MismatchNullability
+	// MismatchColumnRequired means the NOT NULL column of the target without a default does not exist in the source,
+	// so that the inserts fail on the target
+	MismatchColumnRequired
+)
+
+func (k MismatchKind) String() string {
+	switch k {
+	case MismatchTableMissing:
+		return "table missing"
+	case MismatchColumnMissing:
+		return "column missing"
+	case MismatchType:
+		return "type mismatch"
+	case MismatchNullability:
+		return "nullability mismatch"
+	case MismatchColumnRequired:
+		return "required column missing"
+	}
+	return "unknown mismatch"
+}
+
+// Mismatch is an incompatibility of the target table with the source one, and the Column is empty if it is of the table
+type Mismatch struct {
+	Table  string
+	Column string
+	Kind   MismatchKind
+	Source string
+	Target string
+}
+
+func (m Mismatch) String() string {
+	name := m.Table
+	if m.Column != "" {
+		name += "." + m.Column
+	}
+	return fmt.Sprintf("%s: %s, source %q, target %q", name, m.Kind, m.Source, m.Target)
+}
+
+// CheckSchemaCompatibility compares the columns of the tables keyed by "schema.table" in the target with the source,
+// before applying the changes of the source to the target, and returns all the mismatches found. The types are compared
+// by their names instead of the oids, which are different across the databases for the types not built in.
+// The column metadata of both loaders are refreshed by it.
+func CheckSchemaCompatibility(ctx context.Context, source, target *PGXSchemaLoader, tables []string) ([]Mismatch, error) {
+	if err := source.refreshColumnMeta(ctx); err != nil {
+		return nil, err
+	}
+	if err := target.refreshColumnMeta(ctx); err != nil {
+		return nil, err
+	}
+	var mismatches []Mismatch
+	for _, table := range tables {
+		namespace, name, _ := strings.Cut(table, ".")
+		src, err := source.GetColumnMeta(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		tgt, err := target.GetColumnMeta(namespace, name)
+		if err != nil {
+			mismatches = append(mismatches, Mismatch{Table: table, Kind: MismatchTableMissing})
+			continue
+		}
+		mismatches = append(mismatches, compareColumns(table, src, tgt)...)
+	}
+	return mismatches, nil
+}
+
+func compareColumns(table string, source, target []ColumnMeta) (mismatches []Mismatch) {
+	targets := make(map[string]ColumnMeta, len(target))
+	for _, c := range target {
+		targets[c.Name] = c
+	}
+	sources := make(map[string]struct{}, len(source))
+	for _, s := range source {
+		sources[s.Name] = struct{}{}
+		t, ok := targets[s.Name]
+		if !ok {
+			mismatches = append(mismatches, Mismatch{Table: table, Column: s.Name, Kind: MismatchColumnMissing, Source: s.Type})
+			continue
+		}
+		if s.Type != t.Type {
+			mismatches = append(mismatches, Mismatch{Table: table, Column: s.Name, Kind: MismatchType, Source: s.Type, Target: t.Type})
+		}
+		if !s.NotNull && t.NotNull {
+			mismatches = append(mismatches, Mismatch{Table: table, Column: s.Name, Kind: MismatchNullability, Source: "NULL", Target: "NOT NULL"})
+		}
+	}
+	for _, t := range target {
+		if _, ok := sources[t.Name]; !ok && t.NotNull && !t.Filled {
+			mismatches = append(mismatches, Mismatch{Table: table, Column: t.Name, Kind: MismatchColumnRequired, Target: t.Type})
+		}
+	}
+	return mismatches
+}
@@ -0,0 +1,99 @@
+package decode
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/replicase/pgcapture/internal/test"
+)
+
+func TestCompareColumns(t *testing.T) {
+	source := []ColumnMeta{
+		{Name: "id", Type: "integer", NotNull: true},
+		{Name: "amount", Type: "numeric(10,2)"},
+		{Name: "note", Type: "text"},
+		{Name: "gone", Type: "text"},
+	}
+	target := []ColumnMeta{
+		{Name: "id", Type: "bigint", NotNull: true},
+		{Name: "amount", Type: "numeric(10,2)", NotNull: true},
+		{Name: "note", Type: "text"},
+		{Name: "created", Type: "timestamp with time zone", NotNull: true, Filled: true},
+		{Name: "required", Type: "text", NotNull: true},
+	}
+	mismatches := compareColumns("public.t", source, target)
+	expect := []Mismatch{
+		{Table: "public.t", Column: "id", Kind: MismatchType, Source: "integer", Target: "bigint"},
+		{Table: "public.t", Column: "amount", Kind: MismatchNullability, Source: "NULL", Target: "NOT NULL"},
+		{Table: "public.t", Column: "gone", Kind: MismatchColumnMissing, Source: "text"},
+		{Table: "public.t", Column: "required", Kind: MismatchColumnRequired, Target: "text"},
+	}
+	if !reflect.DeepEqual(mismatches, expect) {
+		t.Fatalf("unexpected %v", mismatches)
+	}
+	if mismatches = compareColumns("public.t", source, source); len(mismatches) != 0 {
+		t.Fatalf("unexpected %v", mismatches)
+	}
+}
+
+func TestCheckSchemaCompatibility(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err = conn.Exec(ctx, "create database compat_target"); err != nil {
+		var pge *pgconn.PgError
+		if !errors.As(err, &pge) || pge.Code != "42P04" {
+			t.Fatal(err)
+		}
+	}
+	u, err := url.Parse(test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Path = "/compat_target"
+	targetConn, err := pgx.Connect(ctx, u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer targetConn.Close(ctx)
+
+	for c, q := range map[*pgx.Conn]string{
+		conn:       "DROP SCHEMA public CASCADE; CREATE SCHEMA public; create table t_compat (id int primary key, amount numeric(10,2), note text)",
+		targetConn: "DROP SCHEMA public CASCADE; CREATE SCHEMA public; create table t_compat (id int primary key, amount text, note text)",
+	} {
+		if _, err = c.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mismatches, err := CheckSchemaCompatibility(ctx, NewPGXSchemaLoader(conn), NewPGXSchemaLoader(targetConn), []string{"public.t_compat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []Mismatch{{Table: "public.t_compat", Column: "amount", Kind: MismatchType, Source: "numeric(10,2)", Target: "text"}}
+	if !reflect.DeepEqual(mismatches, expect) {
+		t.Fatalf("unexpected %v", mismatches)
+	}
+
+	if _, err = targetConn.Exec(ctx, "drop table t_compat"); err != nil {
+		t.Fatal(err)
+	}
+	if mismatches, err = CheckSchemaCompatibility(ctx, NewPGXSchemaLoader(conn), NewPGXSchemaLoader(targetConn), []string{"public.t_compat"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Kind != MismatchTableMissing {
+		t.Fatalf("unexpected %v", mismatches)
+	}
+	if _, err = CheckSchemaCompatibility(ctx, NewPGXSchemaLoader(conn), NewPGXSchemaLoader(targetConn), []string{"public.other"}); !errors.Is(err, ErrSchemaTableMissing) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
@@ -1,11 +1,23 @@
 package decode
 
-import "github.com/replicase/pgcapture/pkg/pb"
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
 
 const (
 	ExtensionSchema  = "pgcapture"
 	ExtensionDDLLogs = "ddl_logs"
 	ExtensionSources = "sources"
+	ExtensionDigests = "digests"
 )
 
 const (
@@ -24,6 +36,66 @@ type Relation struct {
 	NspName string
 	RelName string
 	Fields  []string
+	// TypeOIDs and TypeMods are the atttypid and the atttypmod of the Fields sent by the relation message,
+	// which are nil if the plugin does not send them
+	TypeOIDs []uint32
+	TypeMods []int32
+
+	// keep marks the projected fields, nil means all the fields are kept
+	keep []bool
+	// audited marks the fields whose old values are kept, nil means all the old values are kept
+	audited []bool
+	// systemColumns decodes the system columns in the tuples, which are not in the schema
+	systemColumns bool
+}
+
+func (r Relation) projected(i int) bool {
+	return r.keep == nil || (i < len(r.keep) && r.keep[i])
+}
+
+func (r Relation) auditedOld(i int) bool {
+	return r.audited == nil || (i < len(r.audited) && r.audited[i])
+}
+
+// skipped reports whether the row change of the relation is skipped without decoding its tuples in the ddlOnly mode,
+// and the unknown relation is not skipped so that it still fails the decoding
+func skipped(ddlOnly bool, relations map[uint32]Relation, in []byte) bool {
+	if !ddlOnly || len(in) < 4 {
+		return false
+	}
+	rel, ok := relations[binary.BigEndian.Uint32(in)]
+	return ok && !(rel.NspName == ExtensionSchema && rel.RelName == ExtensionDDLLogs)
+}
+
+// projectRelation marks the fields of the relation to be kept by the columns keyed by "schema.table",
+// and the relation not in the columns keeps all its fields
+func projectRelation(columns map[string][]string, rel Relation) Relation {
+	rel.keep = markFields(columns, rel)
+	return rel
+}
+
+// auditRelation marks the fields of the relation whose old values are kept by the columns keyed by "schema.table",
+// and the relation not in the columns keeps all its old values
+func auditRelation(columns map[string][]string, rel Relation) Relation {
+	rel.audited = markFields(columns, rel)
+	return rel
+}
+
+func markFields(columns map[string][]string, rel Relation) []bool {
+	wanted, ok := columns[rel.NspName+"."+rel.RelName]
+	if !ok {
+		return nil
+	}
+	marks := make([]bool, len(rel.Fields))
+	for i, f := range rel.Fields {
+		for _, w := range wanted {
+			if f == w {
+				marks[i] = true
+				break
+			}
+		}
+	}
+	return marks
 }
 
 type RowChange struct {
@@ -38,15 +110,297 @@ type Field struct {
 	Datum  []byte
 }
 
+const (
+	RegConfigOID = 3734
+	RefCursorOID = 1790
+	// QCharOID is the single-byte internal "char" type, which is not the bpchar of the char(n)
+	QCharOID = 18
+	// Macaddr8OID is the 8-byte EUI-64 MAC address, which has no codec in the pgtype
+	Macaddr8OID = 774
+	// PseudoTypeOID is the unknown type, used to flag the values of pseudo-type columns which are passed through as text
+	PseudoTypeOID = 705
+)
+
+var errEmptyMessage = errors.New("empty message")
+
+// emptyChange is the change of the relation without any column, which should still be delivered
+// since its tuples are always empty, while the empty changes of other relations are dropped
+func emptyChange(rel Relation, c *pb.Change) *pb.Message {
+	if len(rel.Fields) == 0 {
+		return &pb.Message{Type: &pb.Message_Change{Change: c}}
+	}
+	return nil
+}
+
 type Decoder interface {
 	Decode(in []byte) (*pb.Message, error)
 	GetPluginArgs() []string
 }
 
+// StatefulDecoder tells the messages changing the state of the decoder, like the relation messages,
+// which should not be decoded concurrently with the other messages
+type StatefulDecoder interface {
+	Stateful(in []byte) bool
+}
+
 func IsDDL(m *pb.Change) bool {
 	return m.Schema == ExtensionSchema && m.Table == ExtensionDDLLogs
 }
 
+// IsDigest tells the consistency digest emitted by the source, which is not a row change of any table
+func IsDigest(m *pb.Change) bool {
+	return m.Schema == ExtensionSchema && m.Table == ExtensionDigests
+}
+
 func Ignore(m *pb.Change) bool {
 	return m.Schema == ExtensionSchema && m.Table == ExtensionSources
 }
+
+// makePBTuple converts the fields of the tuple, and the old tuple skips the null fields and the fields not audited
+func makePBTuple(schema *PGXSchemaLoader, rel Relation, src []Field, old bool) (fields []*pb.Field) {
+	if src == nil {
+		return nil
+	}
+	fields = make([]*pb.Field, 0, len(src))
+	for i, s := range src {
+		if (old && (s.Datum == nil || !rel.auditedOld(i))) || !rel.projected(i) {
+			continue
+		}
+		if f := makePBField(schema, rel, i, s); f != nil {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// makePBField returns nil if the field should be excluded
+func makePBField(schema *PGXSchemaLoader, rel Relation, i int, s Field) *pb.Field {
+	if i >= len(rel.Fields) {
+		// the tuple has more fields than the relation, which are not identifiable
+		return nil
+	}
+	oid, err := schema.GetTypeOID(rel.NspName, rel.RelName, rel.Fields[i])
+	if err != nil {
+		if rel.systemColumns {
+			return systemColumnField(rel.Fields[i], s)
+		}
+		// TODO: add optional logging, because it will generate a lot of logs when refreshing materialized view
+		return nil
+	}
+	if s.Format == JSONBPatchFormat {
+		return &pb.Field{Name: rel.Fields[i], Oid: JSONBPatchOID, Value: &pb.Field_Text{Text: string(s.Datum)}}
+	}
+	if s.Format != 'n' && s.Format != 'u' && schema.IsPseudoType(oid) {
+		return &pb.Field{Name: rel.Fields[i], Oid: PseudoTypeOID, Value: &pb.Field_Text{Text: pseudoTypeText(s)}}
+	}
+	if base, ok := schema.GetArrayBase(oid); ok {
+		// the arrays of enums and domains are converted into the arrays of their base types in text,
+		// which can be decoded without knowing the enums and domains, and are still accepted by the columns
+		if s.Format == 't' {
+			return &pb.Field{Name: rel.Fields[i], Oid: base.Array, Value: &pb.Field_Text{Text: string(s.Datum)}}
+		}
+		if s.Format == 'b' {
+			if text, ok := arrayBaseText(base, s.Datum); ok {
+				return &pb.Field{Name: rel.Fields[i], Oid: base.Array, Value: &pb.Field_Text{Text: text}}
+			}
+		}
+	}
+	if base, ok := schema.GetRangeBase(oid); ok && (s.Format == 'b' || s.Format == 't') {
+		// the ranges of domains are the same as the built-in ranges of their base types, and the other ranges
+		// not built in are rendered in text by the codec of their subtypes
+		if base.Range != 0 {
+			return makeDatumField(rel.Fields[i], base.Range, s)
+		}
+		if s.Format == 'b' {
+			if text, ok := rangeBaseText(oid, base, s.Datum); ok {
+				return &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Text{Text: text}}
+			}
+		}
+	}
+	switch s.Format {
+	case 'b':
+		if oid == RefCursorOID {
+			// refcursor is sent as its name in text
+			return &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Text{Text: string(s.Datum)}}
+		}
+		if oid == RegConfigOID {
+			return &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Text{Text: regConfigText(schema, s.Datum)}}
+		}
+		if oid == pgtype.TIDOID && len(s.Datum) == 6 {
+			// in the canonical (block,offset) like the tidout does
+			return &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Text{Text: tidText(s.Datum)}}
+		}
+		if oid == Macaddr8OID && len(s.Datum) == 8 {
+			// in the canonical xx:xx:xx:xx:xx:xx:xx:xx like the macaddr8_out does
+			return &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Text{Text: net.HardwareAddr(s.Datum).String()}}
+		}
+		// the binary composite carries the type and the length of each attribute, so it is passed through
+		// without a cached layout of the type, which goes stale after the ALTER TYPE
+		return &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Binary{Binary: s.Datum}}
+	case 'n':
+		return &pb.Field{Name: rel.Fields[i], Oid: oid, Value: nil}
+	case 't':
+		if oid == QCharOID {
+			// "char" is normalized into its single byte, since its text form is escaped in octal for non-ASCII bytes
+			return &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Binary{Binary: []byte{qcharByte(s.Datum)}}}
+		}
+		return &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Text{Text: string(s.Datum)}}
+	}
+	return nil // unchanged toast field should be excluded
+}
+
+func makeDatumField(name string, oid uint32, s Field) *pb.Field {
+	if s.Format == 'b' {
+		return &pb.Field{Name: name, Oid: oid, Value: &pb.Field_Binary{Binary: s.Datum}}
+	}
+	return &pb.Field{Name: name, Oid: oid, Value: &pb.Field_Text{Text: string(s.Datum)}}
+}
+
+// regConfigText converts the binary regconfig into its textual name like the regconfigout does,
+// and falls back to the numeric form if the configuration is not found, which is also accepted by the regconfigin.
+func regConfigText(schema *PGXSchemaLoader, datum []byte) string {
+	if len(datum) != 4 {
+		return string(datum)
+	}
+	oid := binary.BigEndian.Uint32(datum)
+	if name, ok := schema.GetTSConfigName(oid); ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(oid), 10)
+}
+
+// typeMaps are pooled since the pgtype.Map is not safe for concurrent use
+var typeMaps = sync.Pool{New: func() any { return pgtype.NewMap() }}
+
+// arrayBaseText renders the binary array of enums or domains in the text of its base array type,
+// and returns false if the base type is not supported by the pgtype
+func arrayBaseText(base ArrayBase, datum []byte) (string, bool) {
+	if len(datum) < 12 {
+		return "", false
+	}
+	// replace the element type in the header, which is the enum or the domain
+	src := make([]byte, len(datum))
+	copy(src, datum)
+	binary.BigEndian.PutUint32(src[8:12], base.Elem)
+
+	m := typeMaps.Get().(*pgtype.Map)
+	defer typeMaps.Put(m)
+	if _, ok := m.TypeForOID(base.Array); !ok {
+		return "", false
+	}
+	var arr pgtype.Array[any]
+	if err := m.Scan(base.Array, pgtype.BinaryFormatCode, src, &arr); err != nil {
+		return "", false
+	}
+	text, err := m.Encode(base.Array, pgtype.TextFormatCode, arr, nil)
+	if err != nil {
+		return "", false
+	}
+	return string(text), true
+}
+
+// rangeBaseText renders the binary range in text like the range_out does, whose bounds are decoded by the codec of
+// its base subtype resolved from the pgtype, and returns false if the base subtype is not supported by the pgtype
+func rangeBaseText(oid uint32, base RangeBase, datum []byte) (string, bool) {
+	m := typeMaps.Get().(*pgtype.Map)
+	defer typeMaps.Put(m)
+	elem, ok := m.TypeForOID(base.Elem)
+	if !ok {
+		return "", false
+	}
+	var r pgtype.Range[any]
+	if err := (&pgtype.RangeCodec{ElementType: elem}).PlanScan(m, oid, pgtype.BinaryFormatCode, &r).Scan(datum, &r); err != nil {
+		return "", false
+	}
+	if r.LowerType == pgtype.Empty {
+		return "empty", true
+	}
+	var sb strings.Builder
+	for i, bound := range []struct {
+		typ   pgtype.BoundType
+		value any
+		open  byte
+		close byte
+	}{
+		{typ: r.LowerType, value: r.Lower, open: '(', close: '['},
+		{typ: r.UpperType, value: r.Upper, open: ')', close: ']'},
+	} {
+		if i == 1 {
+			sb.WriteByte(',')
+		}
+		if i == 0 {
+			sb.WriteByte(bracket(bound.typ, bound.open, bound.close))
+		}
+		if bound.typ != pgtype.Unbounded {
+			text, err := m.Encode(base.Elem, pgtype.TextFormatCode, bound.value, nil)
+			if err != nil {
+				return "", false
+			}
+			sb.WriteString(quoteRangeBound(string(text)))
+		}
+		if i == 1 {
+			sb.WriteByte(bracket(bound.typ, bound.open, bound.close))
+		}
+	}
+	return sb.String(), true
+}
+
+func bracket(typ pgtype.BoundType, exclusive, inclusive byte) byte {
+	if typ == pgtype.Inclusive {
+		return inclusive
+	}
+	return exclusive
+}
+
+// quoteRangeBound quotes the bound with the special characters of the range like the range_bound_escape does
+func quoteRangeBound(s string) string {
+	if s != "" && !strings.ContainsAny(s, "\"\\()[], \t\n\r\v\f") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func tidText(datum []byte) string {
+	return "(" + strconv.FormatUint(uint64(binary.BigEndian.Uint32(datum)), 10) + "," + strconv.FormatUint(uint64(binary.BigEndian.Uint16(datum[4:])), 10) + ")"
+}
+
+// ParseTID parses the block number and the offset of the tid field, which is either in the text or the binary format
+func ParseTID(f *pb.Field) (block uint32, offset uint16, err error) {
+	m := typeMaps.Get().(*pgtype.Map)
+	defer typeMaps.Put(m)
+	var tid pgtype.TID
+	switch v := f.Value.(type) {
+	case *pb.Field_Text:
+		err = m.Scan(pgtype.TIDOID, pgtype.TextFormatCode, []byte(v.Text), &tid)
+	case *pb.Field_Binary:
+		err = m.Scan(pgtype.TIDOID, pgtype.BinaryFormatCode, v.Binary, &tid)
+	default:
+		return 0, 0, errors.New("tid is null")
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return tid.BlockNumber, tid.OffsetNumber, nil
+}
+
+// qcharByte parses the text form of the "char" like the charin does, which is either a single character,
+// a \ooo octal escape, or empty for the zero byte
+func qcharByte(datum []byte) byte {
+	if len(datum) == 4 && datum[0] == '\\' {
+		if n, err := strconv.ParseUint(string(datum[1:]), 8, 8); err == nil {
+			return byte(n)
+		}
+	}
+	if len(datum) == 0 {
+		return 0
+	}
+	return datum[0]
+}
+
+// pseudoTypeText keeps the text datum, or renders the binary datum in the hex form of the bytea
+func pseudoTypeText(s Field) string {
+	if s.Format == 't' {
+		return string(s.Datum)
+	}
+	return `\x` + hex.EncodeToString(s.Datum)
+}
@@ -1,9 +1,12 @@
 package decode
 
 import (
+	"encoding/binary"
 	"testing"
 
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/replicase/pgcapture/pkg/pb"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestIsDDL(t *testing.T) {
@@ -29,3 +32,265 @@ func TestIgnore(t *testing.T) {
 		t.Error("unexpected")
 	}
 }
+
+// binaryArray encodes the text array of the base type in binary with the element type replaced
+func binaryArray(t *testing.T, base ArrayBase, elem uint32, text string) []byte {
+	m := pgtype.NewMap()
+	var arr pgtype.Array[any]
+	if err := m.Scan(base.Array, pgtype.TextFormatCode, []byte(text), &arr); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := m.Encode(base.Array, pgtype.BinaryFormatCode, arr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint32(bs[8:12], elem)
+	return bs
+}
+
+// binaryComposite encodes the int4 or text attributes like the record_send does
+func binaryComposite(attrs ...any) []byte {
+	bs := binary.BigEndian.AppendUint32(nil, uint32(len(attrs)))
+	for _, a := range attrs {
+		switch v := a.(type) {
+		case int32:
+			bs = binary.BigEndian.AppendUint32(bs, 23)
+			bs = binary.BigEndian.AppendUint32(bs, 4)
+			bs = binary.BigEndian.AppendUint32(bs, uint32(v))
+		case string:
+			bs = binary.BigEndian.AppendUint32(bs, 25)
+			bs = binary.BigEndian.AppendUint32(bs, uint32(len(v)))
+			bs = append(bs, v...)
+		}
+	}
+	return bs
+}
+
+func TestMakePBTuple(t *testing.T) {
+	const (
+		moodOID          = 90001
+		moodArrayOID     = 90002
+		posintOID        = 90003
+		posintArrayOID   = 90004
+		pairOID          = 90005
+		priceRangeOID    = 90006
+		floatRangeOID    = 90007
+		intervalRangeOID = 90008
+	)
+	enum := ArrayBase{Elem: 25, Array: 1009}
+	domain := ArrayBase{Elem: 23, Array: 1007}
+
+	m := pgtype.NewMap()
+	encode := func(oid uint32, v any) []byte {
+		bs, err := m.Encode(oid, pgtype.BinaryFormatCode, v, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bs
+	}
+	numeric := func(s string) pgtype.Numeric {
+		var n pgtype.Numeric
+		if err := n.Scan(s); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	exact := encode(pgtype.NumrangeOID, pgtype.Range[pgtype.Numeric]{
+		Lower: numeric("12345678901234567890.123456789"), Upper: numeric("12345678901234567890.123456790"),
+		LowerType: pgtype.Inclusive, UpperType: pgtype.Exclusive, Valid: true,
+	})
+	rangeHeader := func(lower, upper []byte) []byte {
+		// the binary range with both bounds, the lower is inclusive
+		bs := []byte{0x02}
+		bs = append(binary.BigEndian.AppendUint32(bs, uint32(len(lower))), lower...)
+		return append(binary.BigEndian.AppendUint32(bs, uint32(len(upper))), upper...)
+	}
+
+	for _, c := range []struct {
+		name   string
+		schema *PGXSchemaLoader
+		tuple  []Field
+		expect []*pb.Field
+	}{
+		{
+			name: "regconfig",
+			schema: &PGXSchemaLoader{
+				types:     TypeCache{"public": {"t": {"known": RegConfigOID, "dropped": RegConfigOID}}},
+				tsConfigs: NameCache{13000: "english"},
+			},
+			tuple: []Field{
+				{Format: 'b', Datum: []byte{0, 0, 0x32, 0xc8}},
+				{Format: 'b', Datum: []byte{0, 0, 0x32, 0xc9}},
+			},
+			expect: []*pb.Field{
+				{Name: "known", Oid: RegConfigOID, Value: &pb.Field_Text{Text: "english"}},
+				{Name: "dropped", Oid: RegConfigOID, Value: &pb.Field_Text{Text: "13001"}},
+			},
+		},
+		{
+			name: "qchar",
+			schema: &PGXSchemaLoader{
+				types: TypeCache{"public": {"t": {"b": QCharOID, "t": QCharOID, "escaped": QCharOID, "zero": QCharOID, "bpchar": 1042}}},
+			},
+			tuple: []Field{
+				{Format: 'b', Datum: []byte{'a'}},
+				{Format: 't', Datum: []byte("a")},
+				{Format: 't', Datum: []byte(`\302`)},
+				{Format: 't', Datum: []byte{}},
+				{Format: 't', Datum: []byte(`\302`)},
+			},
+			expect: []*pb.Field{
+				{Name: "b", Oid: QCharOID, Value: &pb.Field_Binary{Binary: []byte{'a'}}},
+				{Name: "t", Oid: QCharOID, Value: &pb.Field_Binary{Binary: []byte{'a'}}},
+				{Name: "escaped", Oid: QCharOID, Value: &pb.Field_Binary{Binary: []byte{0xc2}}},
+				{Name: "zero", Oid: QCharOID, Value: &pb.Field_Binary{Binary: []byte{0}}},
+				{Name: "bpchar", Oid: 1042, Value: &pb.Field_Text{Text: `\302`}},
+			},
+		},
+		{
+			name:   "macaddr8",
+			schema: &PGXSchemaLoader{types: TypeCache{"public": {"t": {"b": Macaddr8OID, "t": Macaddr8OID}}}},
+			tuple: []Field{
+				{Format: 'b', Datum: []byte{0x08, 0x00, 0x2b, 0x01, 0x02, 0x03, 0x04, 0xff}},
+				{Format: 't', Datum: []byte("08:00:2b:01:02:03:04:ff")},
+			},
+			expect: []*pb.Field{
+				{Name: "b", Oid: Macaddr8OID, Value: &pb.Field_Text{Text: "08:00:2b:01:02:03:04:ff"}},
+				{Name: "t", Oid: Macaddr8OID, Value: &pb.Field_Text{Text: "08:00:2b:01:02:03:04:ff"}},
+			},
+		},
+		{
+			name: "enum and domain arrays",
+			schema: &PGXSchemaLoader{
+				types:      TypeCache{"public": {"t": {"moods": moodArrayOID, "text_moods": moodArrayOID, "ints": posintArrayOID, "empty": posintArrayOID, "null": moodArrayOID}}},
+				arrayBases: ArrayBaseCache{moodArrayOID: enum, posintArrayOID: domain},
+			},
+			tuple: []Field{
+				// the "angry" is added to the enum after the schema is loaded, and is still decoded from its label
+				{Format: 'b', Datum: binaryArray(t, enum, moodOID, `{happy,NULL,"sad, really",angry}`)},
+				{Format: 't', Datum: []byte(`{sad}`)},
+				{Format: 'b', Datum: binaryArray(t, domain, posintOID, `[0:1]={1,2}`)},
+				{Format: 'b', Datum: binaryArray(t, domain, posintOID, `{}`)},
+				{Format: 'n'},
+			},
+			expect: []*pb.Field{
+				{Name: "moods", Oid: 1009, Value: &pb.Field_Text{Text: `{happy,NULL,"sad, really",angry}`}},
+				{Name: "text_moods", Oid: 1009, Value: &pb.Field_Text{Text: `{sad}`}},
+				{Name: "ints", Oid: 1007, Value: &pb.Field_Text{Text: `[0:1]={1,2}`}},
+				{Name: "empty", Oid: 1007, Value: &pb.Field_Text{Text: `{}`}},
+				{Name: "null", Oid: moodArrayOID},
+			},
+		},
+		{
+			name: "pseudo types",
+			schema: &PGXSchemaLoader{
+				types:       TypeCache{"public": {"t": {"id": 23, "void": 2278, "cstring": 2275, "cursor": RefCursorOID, "null": 2278}}},
+				pseudoTypes: NameCache{2278: "void", 2275: "cstring"},
+			},
+			tuple: []Field{
+				{Format: 'b', Datum: []byte{0, 0, 0, 1}},
+				{Format: 'b', Datum: []byte{0xde, 0xad}},
+				{Format: 't', Datum: []byte("abc")},
+				{Format: 'b', Datum: []byte("<unnamed portal 1>")},
+				{Format: 'n'},
+			},
+			expect: []*pb.Field{
+				{Name: "id", Oid: 23, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1}}},
+				{Name: "void", Oid: PseudoTypeOID, Value: &pb.Field_Text{Text: `\xdead`}},
+				{Name: "cstring", Oid: PseudoTypeOID, Value: &pb.Field_Text{Text: "abc"}},
+				{Name: "cursor", Oid: RefCursorOID, Value: &pb.Field_Text{Text: "<unnamed portal 1>"}},
+				{Name: "null", Oid: 2278},
+			},
+		},
+		{
+			// the composite values before and after the ALTER TYPE carry their own attributes,
+			// so that they are passed through without any layout of the type cached
+			name:   "composite evolution",
+			schema: &PGXSchemaLoader{types: TypeCache{"public": {"t": {"before": pairOID, "added": pairOID, "changed": pairOID}}}},
+			tuple: []Field{
+				{Format: 'b', Datum: binaryComposite(int32(1), "x")},
+				{Format: 'b', Datum: binaryComposite(int32(2), "y", int32(3))},
+				{Format: 'b', Datum: binaryComposite(int32(3), int32(4))},
+			},
+			expect: []*pb.Field{
+				{Name: "before", Oid: pairOID, Value: &pb.Field_Binary{Binary: binaryComposite(int32(1), "x")}},
+				{Name: "added", Oid: pairOID, Value: &pb.Field_Binary{Binary: binaryComposite(int32(2), "y", int32(3))}},
+				{Name: "changed", Oid: pairOID, Value: &pb.Field_Binary{Binary: binaryComposite(int32(3), int32(4))}},
+			},
+		},
+		{
+			name: "range bases",
+			schema: &PGXSchemaLoader{
+				types: TypeCache{"public": {"t": {"exact": pgtype.NumrangeOID, "price": priceRangeOID, "text_price": priceRangeOID, "float": floatRangeOID, "interval": intervalRangeOID}}},
+				rangeBases: RangeBaseCache{
+					// the range of a domain over numeric
+					priceRangeOID:    {Elem: pgtype.NumericOID, Range: pgtype.NumrangeOID},
+					floatRangeOID:    {Elem: pgtype.Float8OID},
+					intervalRangeOID: {Elem: pgtype.IntervalOID},
+				},
+			},
+			tuple: []Field{
+				{Format: 'b', Datum: exact},
+				{Format: 'b', Datum: exact},
+				{Format: 't', Datum: []byte("[1.0001,2)")},
+				{Format: 'b', Datum: rangeHeader(encode(pgtype.Float8OID, 1.5), encode(pgtype.Float8OID, 2.5))},
+				{Format: 'b', Datum: rangeHeader(encode(pgtype.IntervalOID, pgtype.Interval{Days: 1, Valid: true}), encode(pgtype.IntervalOID, pgtype.Interval{Days: 2, Valid: true}))},
+			},
+			expect: []*pb.Field{
+				// the numeric bounds are kept in binary without the loss of precision
+				{Name: "exact", Oid: pgtype.NumrangeOID, Value: &pb.Field_Binary{Binary: exact}},
+				{Name: "price", Oid: pgtype.NumrangeOID, Value: &pb.Field_Binary{Binary: exact}},
+				{Name: "text_price", Oid: pgtype.NumrangeOID, Value: &pb.Field_Text{Text: "[1.0001,2)"}},
+				{Name: "float", Oid: floatRangeOID, Value: &pb.Field_Text{Text: "[1.5,2.5)"}},
+				{Name: "interval", Oid: intervalRangeOID, Value: &pb.Field_Text{Text: `["1 day 00:00:00.000000","2 day 00:00:00.000000")`}},
+			},
+		},
+		{
+			name:   "tid",
+			schema: &PGXSchemaLoader{types: TypeCache{"public": {"t": {"b": pgtype.TIDOID, "t": pgtype.TIDOID}}}},
+			tuple: []Field{
+				{Format: 'b', Datum: []byte{0, 1, 0xe2, 0x40, 0, 7}},
+				{Format: 't', Datum: []byte("(4294967295,65535)")},
+			},
+			expect: []*pb.Field{
+				{Name: "b", Oid: pgtype.TIDOID, Value: &pb.Field_Text{Text: "(123456,7)"}},
+				{Name: "t", Oid: pgtype.TIDOID, Value: &pb.Field_Text{Text: "(4294967295,65535)"}},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			rel := Relation{NspName: "public", RelName: "t"}
+			for _, f := range c.expect {
+				rel.Fields = append(rel.Fields, f.Name)
+			}
+			fields := makePBTuple(c.schema, rel, c.tuple, false)
+			if len(fields) != len(c.expect) {
+				t.Fatalf("unexpected %v", fields)
+			}
+			for i := range c.expect {
+				if !proto.Equal(fields[i], c.expect[i]) {
+					t.Fatalf("unexpected %v", fields[i].String())
+				}
+			}
+		})
+	}
+}
+
+func TestParseTID(t *testing.T) {
+	for _, c := range []struct {
+		field  *pb.Field
+		block  uint32
+		offset uint16
+	}{
+		{field: &pb.Field{Oid: pgtype.TIDOID, Value: &pb.Field_Text{Text: "(123456,7)"}}, block: 123456, offset: 7},
+		{field: &pb.Field{Oid: pgtype.TIDOID, Value: &pb.Field_Text{Text: "(4294967295,65535)"}}, block: 4294967295, offset: 65535},
+		{field: &pb.Field{Oid: pgtype.TIDOID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 2, 0, 3}}}, block: 2, offset: 3},
+	} {
+		if block, offset, err := ParseTID(c.field); err != nil || block != c.block || offset != c.offset {
+			t.Fatalf("unexpected %v %v %v", block, offset, err)
+		}
+	}
+	if _, _, err := ParseTID(&pb.Field{Oid: pgtype.TIDOID}); err == nil {
+		t.Fatal("null tid should fail")
+	}
+}
@@ -0,0 +1,47 @@
+package decode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+// SchemaFingerprinter is implemented by the decoders tracking the layouts of the relations by their relation messages
+type SchemaFingerprinter interface {
+	// SchemaFingerprint returns the fingerprint of the latest layout of the table, which is the hash of the names, the type
+	// oids and the type modifiers of its columns in order, so that it changes on any column added, dropped, retyped or reordered
+	SchemaFingerprint(namespace, table string) (string, bool)
+}
+
+// fingerprintRelation records the fingerprint of the relation into the fingerprints, which is created if nil.
+// The column types and modifiers are taken from the relation message, so that a retype is detected before the schema
+// is refreshed. The pglogical sends no types, whose fingerprint hashes the type oids of the schema instead, which are
+// 0 if they are not loaded yet.
+func fingerprintRelation(fingerprints map[string]string, schema *PGXSchemaLoader, rel Relation) map[string]string {
+	if fingerprints == nil {
+		fingerprints = make(map[string]string)
+	}
+	h := fnv.New64a()
+	for i, f := range rel.Fields {
+		var oid, typmod uint32
+		if i < len(rel.TypeOIDs) {
+			oid, typmod = rel.TypeOIDs[i], uint32(rel.TypeMods[i])
+		} else {
+			oid, _ = schema.GetTypeOID(rel.NspName, rel.RelName, f)
+		}
+		h.Write([]byte(f))
+		h.Write(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32([]byte{0}, oid), typmod))
+	}
+	fingerprints[rel.NspName+"."+rel.RelName] = fmt.Sprintf("%016x", h.Sum64())
+	return fingerprints
+}
+
+func (p *PGOutputDecoder) SchemaFingerprint(namespace, table string) (fingerprint string, ok bool) {
+	fingerprint, ok = p.fingerprints[namespace+"."+table]
+	return
+}
+
+func (p *PGLogicalDecoder) SchemaFingerprint(namespace, table string) (fingerprint string, ok bool) {
+	fingerprint, ok = p.fingerprints[namespace+"."+table]
+	return
+}
@@ -0,0 +1,136 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+// JSONBPatchKey is the only key of the jsonb value sent by the logical decoding extensions emitting the sub-document changes,
+// instead of the whole new document, like {"$patch": [{"path": ["a", "b"], "value": 1}]}.
+// The patches are applied in order, and the other jsonb values are whole documents.
+const JSONBPatchKey = "$patch"
+
+// JSONBPatchFormat is the tuple data format of the field sent as the patches instead of the whole jsonb document by the
+// extensions, whose datum is the text like the 't'. The stock pgoutput and pglogical never send it, so their jsonb
+// fields are always the whole documents.
+const JSONBPatchFormat = 'p'
+
+// JSONBPatchOID marks the text field decoded from the JSONBPatchFormat, which is not an oid of any type,
+// so that the user documents having the JSONBPatchKey are never taken as the patches
+const JSONBPatchOID = 1<<32 - 1
+
+// ErrJSONBPatch means the field sent in the JSONBPatchFormat is not the patches
+var ErrJSONBPatch = errors.New("malformed jsonb patches")
+
+// JSONBPatch sets the value at the path of the jsonb document like the jsonb_set does
+type JSONBPatch struct {
+	Path  []string        `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// PathText is the path in the text format of the text[], to be passed to the jsonb_set
+func (p JSONBPatch) PathText() string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, key := range p.Path {
+		if i != 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(`"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(key) + `"`)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// canonicalJSONB rewrites the jsonb fields with the object keys sorted and without the whitespaces, so that the equal
+// documents are always identical, while the jsonb orders the keys by their lengths first and keeps the whitespaces in text
+func canonicalJSONB(tuples ...[]*pb.Field) error {
+	for _, fields := range tuples {
+		for _, f := range fields {
+			if f.Oid != pgtype.JSONBOID {
+				continue
+			}
+			switch v := f.Value.(type) {
+			case *pb.Field_Text:
+				doc, err := canonicalJSON([]byte(v.Text))
+				if err != nil {
+					return err
+				}
+				v.Text = string(doc)
+			case *pb.Field_Binary:
+				// the binary jsonb is the version 1 followed by the text
+				if len(v.Binary) == 0 || v.Binary[0] != 1 {
+					continue
+				}
+				doc, err := canonicalJSON(v.Binary[1:])
+				if err != nil {
+					return err
+				}
+				v.Binary = append([]byte{1}, doc...)
+			}
+		}
+	}
+	return nil
+}
+
+func canonicalJSON(doc []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	// keep the numbers as is, which may exceed the float64
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+// ParseJSONBPatches returns the patches of the field marked by the JSONBPatchOID,
+// or false if the field is not marked or malformed
+func ParseJSONBPatches(f *pb.Field) ([]JSONBPatch, bool) {
+	v, ok := f.Value.(*pb.Field_Text)
+	if f.Oid != JSONBPatchOID || !ok {
+		return nil, false
+	}
+	return parseJSONBPatches([]byte(v.Text))
+}
+
+func parseJSONBPatches(datum []byte) ([]JSONBPatch, bool) {
+	var patch map[string][]JSONBPatch
+	if err := json.Unmarshal(datum, &patch); err != nil || len(patch) != 1 {
+		return nil, false
+	}
+	patches, ok := patch[JSONBPatchKey]
+	if !ok || len(patches) == 0 {
+		return nil, false
+	}
+	for _, p := range patches {
+		if len(p.Path) == 0 || len(p.Value) == 0 {
+			return nil, false
+		}
+	}
+	return patches, true
+}
+
+// readJSONBPatches reads the datum of the field in the JSONBPatchFormat, which should be the patches
+func readJSONBPatches(reader *BytesReader) ([]byte, error) {
+	datum, err := reader.Bytes32()
+	if err != nil {
+		return nil, err
+	}
+	datum = bytes.TrimSuffix(datum, StringEnd)
+	if _, ok := parseJSONBPatches(datum); !ok {
+		return nil, ErrJSONBPatch
+	}
+	return datum, nil
+}
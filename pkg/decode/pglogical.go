@@ -34,13 +34,36 @@ func NewPGLogicalDecoder(schema *PGXSchemaLoader) (Decoder, error) {
 }
 
 type PGLogicalDecoder struct {
-	schema     *PGXSchemaLoader
-	relations  map[uint32]Relation
-	pluginArgs []string
-	log        *logrus.Entry
+	// ProjectColumns limits the decoded columns of the relations keyed by "schema.table",
+	// and the relations not in it are fully decoded
+	ProjectColumns map[string][]string
+
+	// DDLOnly skips the row changes of the relations other than the DDL logs without decoding their tuples
+	DDLOnly bool
+
+	// CanonicalJSONB sorts the object keys of the decoded jsonb values and removes their whitespaces,
+	// so that the equal documents are always decoded into identical bytes
+	CanonicalJSONB bool
+
+	// AuditedColumns limits the old values of the relations keyed by "schema.table" to the columns,
+	// and the relations not in it keep all the old values sent by their replica identity
+	AuditedColumns map[string][]string
+
+	// SystemColumns decodes the xmin, xmax, cmin and cmax in the tuples if the plugin sends them, which are hidden
+	// in the tuples normally, into the fields of their uint32 in binary
+	SystemColumns bool
+
+	schema       *PGXSchemaLoader
+	relations    map[uint32]Relation
+	fingerprints map[string]string
+	pluginArgs   []string
+	log          *logrus.Entry
 }
 
 func (p *PGLogicalDecoder) Decode(in []byte) (m *pb.Message, err error) {
+	if len(in) == 0 {
+		return nil, errEmptyMessage
+	}
 	switch in[0] {
 	case 'B':
 		return p.ReadBegin(in)
@@ -49,8 +72,15 @@ func (p *PGLogicalDecoder) Decode(in []byte) (m *pb.Message, err error) {
 	case 'R':
 		r := Relation{}
 		err = p.ReadRelation(in, &r)
-		p.relations[r.Rel] = r
+		r.systemColumns = p.SystemColumns
+		p.relations[r.Rel] = auditRelation(p.AuditedColumns, projectRelation(p.ProjectColumns, r))
+		if err == nil {
+			p.fingerprints = fingerprintRelation(p.fingerprints, p.schema, r)
+		}
 	case 'I', 'U', 'D':
+		if skipped(p.DDLOnly, p.relations, in[2:]) {
+			return nil, nil
+		}
 		r := RowChange{}
 		if err = p.ReadRowChange(in, &r); err != nil {
 			return nil, err
@@ -62,48 +92,30 @@ func (p *PGLogicalDecoder) Decode(in []byte) (m *pb.Message, err error) {
 		}
 
 		c := &pb.Change{Schema: rel.NspName, Table: rel.RelName, Op: OpMap[in[0]]}
-		c.Old = p.makePBTuple(rel, r.Old, true)
-		c.New = p.makePBTuple(rel, r.New, false)
+		c.Old = makePBTuple(p.schema, rel, r.Old, true)
+		c.New = makePBTuple(p.schema, rel, r.New, false)
+		if p.CanonicalJSONB {
+			if err = canonicalJSONB(c.Old, c.New); err != nil {
+				return nil, err
+			}
+		}
 
 		if len(c.Old) != 0 || len(c.New) != 0 {
 			return &pb.Message{Type: &pb.Message_Change{Change: c}}, nil
 		}
+		return emptyChange(rel, c), nil
 	default:
 		// TODO log unmatched message
 	}
 	return nil, err
 }
 
-func (p *PGLogicalDecoder) GetPluginArgs() []string {
-	return p.pluginArgs
+func (p *PGLogicalDecoder) Stateful(in []byte) bool {
+	return len(in) != 0 && in[0] == 'R'
 }
 
-func (p *PGLogicalDecoder) makePBTuple(rel Relation, src []Field, noNull bool) (fields []*pb.Field) {
-	if src == nil {
-		return nil
-	}
-	fields = make([]*pb.Field, 0, len(src))
-	for i, s := range src {
-		if noNull && s.Datum == nil {
-			continue
-		}
-		oid, err := p.schema.GetTypeOID(rel.NspName, rel.RelName, rel.Fields[i])
-		if err != nil {
-			// TODO: add optional logging, because it will generate a lot of logs when refreshing materialized view
-			continue
-		}
-		switch s.Format {
-		case 'b':
-			fields = append(fields, &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Binary{Binary: s.Datum}})
-		case 'n':
-			fields = append(fields, &pb.Field{Name: rel.Fields[i], Oid: oid, Value: nil})
-		case 't':
-			fields = append(fields, &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Text{Text: string(s.Datum)}})
-		case 'u':
-			continue // unchanged toast field should be excluded
-		}
-	}
-	return fields
+func (p *PGLogicalDecoder) GetPluginArgs() []string {
+	return p.pluginArgs
 }
 
 func (p *PGLogicalDecoder) ReadBegin(in []byte) (*pb.Message, error) {
@@ -195,6 +207,10 @@ func (p *PGLogicalDecoder) readTuple(reader *BytesReader) (fields []Field, err e
 		case 't':
 			fields[i].Datum, err = reader.Bytes32()
 			fields[i].Datum = bytes.TrimSuffix(fields[i].Datum, StringEnd)
+		case JSONBPatchFormat:
+			if fields[i].Datum, err = readJSONBPatches(reader); err != nil {
+				return nil, err
+			}
 		default:
 			return nil, errors.New("unsupported data format: " + string(fields[i].Format))
 		}
@@ -24,13 +24,36 @@ func NewPGOutputDecoder(schema *PGXSchemaLoader, slotName string) *PGOutputDecod
 }
 
 type PGOutputDecoder struct {
-	schema     *PGXSchemaLoader
-	relations  map[uint32]Relation
-	pluginArgs []string
-	log        *logrus.Entry
+	// ProjectColumns limits the decoded columns of the relations keyed by "schema.table",
+	// and the relations not in it are fully decoded
+	ProjectColumns map[string][]string
+
+	// DDLOnly skips the row changes of the relations other than the DDL logs without decoding their tuples
+	DDLOnly bool
+
+	// CanonicalJSONB sorts the object keys of the decoded jsonb values and removes their whitespaces,
+	// so that the equal documents are always decoded into identical bytes
+	CanonicalJSONB bool
+
+	// AuditedColumns limits the old values of the relations keyed by "schema.table" to the columns,
+	// and the relations not in it keep all the old values sent by their replica identity
+	AuditedColumns map[string][]string
+
+	// SystemColumns decodes the xmin, xmax, cmin and cmax in the tuples if the plugin sends them, which are hidden
+	// in the tuples normally, into the fields of their uint32 in binary
+	SystemColumns bool
+
+	schema       *PGXSchemaLoader
+	relations    map[uint32]Relation
+	fingerprints map[string]string
+	pluginArgs   []string
+	log          *logrus.Entry
 }
 
 func (p *PGOutputDecoder) Decode(in []byte) (m *pb.Message, err error) {
+	if len(in) == 0 {
+		return nil, errEmptyMessage
+	}
 	switch in[0] {
 	case 'B':
 		return p.ReadBegin(in)
@@ -39,61 +62,103 @@ func (p *PGOutputDecoder) Decode(in []byte) (m *pb.Message, err error) {
 	case 'R':
 		r := Relation{}
 		err = p.ReadRelation(in, &r)
-		p.relations[r.Rel] = r
-	case 'I', 'U', 'D':
-		r := RowChange{}
-		if err = p.ReadRowChange(in, &r); err != nil {
-			return nil, err
+		r.systemColumns = p.SystemColumns
+		p.relations[r.Rel] = auditRelation(p.AuditedColumns, projectRelation(p.ProjectColumns, r))
+		if err == nil {
+			p.fingerprints = fingerprintRelation(p.fingerprints, p.schema, r)
 		}
-
-		rel, ok := p.relations[r.Rel]
-		if !ok {
-			return nil, errors.New("relation not found")
+	case 'I':
+		if skipped(p.DDLOnly, p.relations, in[1:]) {
+			return nil, nil
 		}
-
-		c := &pb.Change{Schema: rel.NspName, Table: rel.RelName, Op: OpMap[in[0]]}
-		c.Old = p.makePBTuple(rel, r.Old, true)
-		c.New = p.makePBTuple(rel, r.New, false)
-
-		if len(c.Old) != 0 || len(c.New) != 0 {
-			return &pb.Message{Type: &pb.Message_Change{Change: c}}, nil
+		return p.decodeInsert(in)
+	case 'U', 'D':
+		if skipped(p.DDLOnly, p.relations, in[1:]) {
+			return nil, nil
 		}
+		return p.decodeRowChange(in)
 	default:
 		// TODO log unmatched message
 	}
 	return nil, err
 }
 
-func (p *PGOutputDecoder) GetPluginArgs() []string {
-	return p.pluginArgs
+func (p *PGOutputDecoder) decodeRowChange(in []byte) (*pb.Message, error) {
+	r := RowChange{}
+	if err := p.ReadRowChange(in, &r); err != nil {
+		return nil, err
+	}
+
+	rel, ok := p.relations[r.Rel]
+	if !ok {
+		return nil, errors.New("relation not found")
+	}
+
+	c := &pb.Change{Schema: rel.NspName, Table: rel.RelName, Op: OpMap[in[0]]}
+	c.Old = makePBTuple(p.schema, rel, r.Old, true)
+	c.New = makePBTuple(p.schema, rel, r.New, false)
+	if p.CanonicalJSONB {
+		if err := canonicalJSONB(c.Old, c.New); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.Old) != 0 || len(c.New) != 0 {
+		return &pb.Message{Type: &pb.Message_Change{Change: c}}, nil
+	}
+	return emptyChange(rel, c), nil
 }
 
-func (p *PGOutputDecoder) makePBTuple(rel Relation, src []Field, noNull bool) (fields []*pb.Field) {
-	if src == nil {
-		return nil
+// decodeInsert is the fast path of the decodeRowChange for INSERT, which has only the new tuple,
+// and converts the fields into pb.Field directly without the intermediate RowChange.
+func (p *PGOutputDecoder) decodeInsert(in []byte) (*pb.Message, error) {
+	reader := NewBytesReader(in)
+	reader.Skip(1) // skip op
+	relID, err := reader.Uint32()
+	if err != nil {
+		return nil, err
 	}
-	fields = make([]*pb.Field, 0, len(src))
-	for i, s := range src {
-		if noNull && s.Datum == nil {
-			continue
+	if kind, err := reader.Byte(); err != nil || kind != 'N' {
+		return nil, errors.New("insert expected new tuple")
+	}
+	rel, ok := p.relations[relID]
+	if !ok {
+		return nil, errors.New("relation not found")
+	}
+	n, err := reader.Int16()
+	if err != nil {
+		return nil, err
+	}
+	c := &pb.Change{Schema: rel.NspName, Table: rel.RelName, Op: pb.Change_INSERT, New: make([]*pb.Field, 0, n)}
+	for i := 0; i < n; i++ {
+		var field Field
+		if err = p.readField(reader, &field); err != nil {
+			return nil, err
 		}
-		oid, err := p.schema.GetTypeOID(rel.NspName, rel.RelName, rel.Fields[i])
-		if err != nil {
-			// TODO: add optional logging, because it will generate a lot of logs when refreshing materialized view
+		if !rel.projected(i) {
 			continue
 		}
-		switch s.Format {
-		case 'b':
-			fields = append(fields, &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Binary{Binary: s.Datum}})
-		case 'n':
-			fields = append(fields, &pb.Field{Name: rel.Fields[i], Oid: oid, Value: nil})
-		case 't':
-			fields = append(fields, &pb.Field{Name: rel.Fields[i], Oid: oid, Value: &pb.Field_Text{Text: string(s.Datum)}})
-		case 'u':
-			continue // unchanged toast field should be excluded
+		if f := makePBField(p.schema, rel, i, field); f != nil {
+			c.New = append(c.New, f)
 		}
 	}
-	return fields
+	if p.CanonicalJSONB {
+		if err = canonicalJSONB(c.New); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.New) != 0 {
+		return &pb.Message{Type: &pb.Message_Change{Change: c}}, nil
+	}
+	return emptyChange(rel, c), nil
+}
+
+func (p *PGOutputDecoder) Stateful(in []byte) bool {
+	return len(in) != 0 && in[0] == 'R'
+}
+
+func (p *PGOutputDecoder) GetPluginArgs() []string {
+	return p.pluginArgs
 }
 
 func (p *PGOutputDecoder) ReadBegin(in []byte) (*pb.Message, error) {
@@ -133,13 +198,22 @@ func (p *PGOutputDecoder) ReadRelation(in []byte, m *Relation) (err error) {
 
 	n, err := reader.Int16()
 	m.Fields = make([]string, n)
+	m.TypeOIDs = make([]uint32, n)
+	m.TypeMods = make([]int32, n)
 	for i := 0; i < n; i++ {
 		reader.Skip(1) // skip flag
 		m.Fields[i], err = reader.StringEnd()
 		if err != nil {
 			return err
 		}
-		reader.Skip(8) // skip data type and type modifier
+		if m.TypeOIDs[i], err = reader.Uint32(); err != nil {
+			return err
+		}
+		typmod, err := reader.Uint32()
+		if err != nil {
+			return err
+		}
+		m.TypeMods[i] = int32(typmod)
 	}
 	return err
 }
@@ -168,20 +242,28 @@ func (p *PGOutputDecoder) readTuple(reader *BytesReader) (fields []Field, err er
 	}
 
 	for i := range fields {
-		if fields[i].Format, err = reader.Byte(); err != nil {
+		if err = p.readField(reader, &fields[i]); err != nil {
 			return nil, err
 		}
-		switch fields[i].Format {
-		case 'b':
-			fields[i].Datum, err = reader.Bytes32()
-		case 'n', 'u':
-			continue
-		case 't':
-			fields[i].Datum, err = reader.Bytes32()
-			fields[i].Datum = bytes.TrimSuffix(fields[i].Datum, StringEnd)
-		default:
-			return nil, errors.New("unsupported data format: " + string(fields[i].Format))
-		}
 	}
 	return
 }
+
+func (p *PGOutputDecoder) readField(reader *BytesReader, field *Field) (err error) {
+	if field.Format, err = reader.Byte(); err != nil {
+		return err
+	}
+	switch field.Format {
+	case 'b':
+		field.Datum, err = reader.Bytes32()
+	case 'n', 'u':
+	case 't':
+		field.Datum, err = reader.Bytes32()
+		field.Datum = bytes.TrimSuffix(field.Datum, StringEnd)
+	case JSONBPatchFormat:
+		field.Datum, err = readJSONBPatches(reader)
+	default:
+		return errors.New("unsupported data format: " + string(field.Format))
+	}
+	return err
+}
@@ -1,9 +1,12 @@
 package decode
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -173,3 +176,483 @@ recv:
 		}
 	}
 }
+
+func newInsertFixture() (*PGOutputDecoder, []byte) {
+	schema := &PGXSchemaLoader{
+		types:       TypeCache{"public": {"t": {"id": 23, "txt": 25, "void": 2278, "cursor": RefCursorOID, "null": 25, "toast": 25}}},
+		pseudoTypes: NameCache{2278: "void"},
+	}
+	decoder := NewPGOutputDecoder(schema, "")
+	decoder.relations[1] = Relation{Rel: 1, NspName: "public", RelName: "t", Fields: []string{"id", "txt", "void", "cursor", "null", "toast"}}
+
+	in := []byte{'I', 0, 0, 0, 1, 'N', 0, 6}
+	field := func(format byte, datum []byte) {
+		in = append(in, format, byte(len(datum)>>24), byte(len(datum)>>16), byte(len(datum)>>8), byte(len(datum)))
+		in = append(in, datum...)
+	}
+	field('b', []byte{0, 0, 0, 1})
+	field('t', []byte("text\x00"))
+	field('b', []byte{0xde, 0xad})
+	field('b', []byte("<unnamed portal 1>"))
+	in = append(in, 'n', 'u')
+	return decoder, in
+}
+
+func TestPGOutputDecoder_InsertFastPath(t *testing.T) {
+	decoder, in := newInsertFixture()
+
+	fast, err := decoder.decodeInsert(in)
+	if err != nil {
+		t.Fatalf("unexpected %v", err)
+	}
+	general, err := decoder.decodeRowChange(in)
+	if err != nil {
+		t.Fatalf("unexpected %v", err)
+	}
+	if !proto.Equal(fast, general) {
+		t.Fatalf("unexpected %v %v", fast.String(), general.String())
+	}
+	if c := fast.GetChange(); c == nil || c.Op != pb.Change_INSERT || c.Old != nil || len(c.New) != 5 {
+		t.Fatalf("unexpected %v", fast.String())
+	}
+
+	if _, err = decoder.Decode([]byte{'I', 0, 0, 0, 2, 'N', 0, 0}); err == nil || err.Error() != "relation not found" {
+		t.Fatalf("unexpected %v", err)
+	}
+	if _, err = decoder.Decode([]byte{'I', 0, 0, 0, 1, 'N', 0, 1, 'x'}); err == nil {
+		t.Fatal("unsupported data format should fail")
+	}
+	if _, err = decoder.Decode(in[:len(in)-3]); err == nil {
+		t.Fatal("incomplete insert should fail")
+	}
+}
+
+func BenchmarkPGOutputDecoder_Insert(b *testing.B) {
+	decoder, in := newInsertFixture()
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decoder.decodeInsert(in); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("general", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decoder.decodeRowChange(in); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func newWideFixture(n int, project map[string][]string) (*PGOutputDecoder, []byte) {
+	columns := make(map[string]uint32, n)
+	relation := []byte{'R', 0, 0, 0, 1}
+	relation = append(relation, "public\x00wide\x00d"...)
+	relation = append(relation, byte(n>>8), byte(n))
+	insert := []byte{'I', 0, 0, 0, 1, 'N', byte(n >> 8), byte(n)}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("c%d", i)
+		columns[name] = 23
+		relation = append(relation, 0)
+		relation = append(relation, name+"\x00"...)
+		relation = append(relation, 0, 0, 0, 23, 0xff, 0xff, 0xff, 0xff)
+		insert = append(insert, 'b', 0, 0, 0, 4, 0, 0, 0, byte(i))
+	}
+	decoder := NewPGOutputDecoder(&PGXSchemaLoader{types: TypeCache{"public": {"wide": columns}}}, "")
+	decoder.ProjectColumns = project
+	if _, err := decoder.Decode(relation); err != nil {
+		panic(err)
+	}
+	return decoder, insert
+}
+
+func TestPGOutputDecoder_ProjectColumns(t *testing.T) {
+	full, insert := newWideFixture(50, nil)
+	projected, _ := newWideFixture(50, map[string][]string{"public.wide": {"c0", "c42", "missing"}, "public.other": {"c1"}})
+
+	expect, err := full.Decode(insert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range [][]byte{insert, append([]byte{'U', 0, 0, 0, 1}, insert[5:]...)} {
+		m, err := projected.Decode(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := m.GetChange().New
+		if len(fields) != 2 || !proto.Equal(fields[0], expect.GetChange().New[0]) || !proto.Equal(fields[1], expect.GetChange().New[42]) {
+			t.Fatalf("unexpected %v", m.String())
+		}
+	}
+	if len(expect.GetChange().New) != 50 {
+		t.Fatalf("unexpected %v", expect.String())
+	}
+}
+
+func BenchmarkPGOutputDecoder_ProjectColumns(b *testing.B) {
+	for _, bc := range []struct {
+		name    string
+		project map[string][]string
+	}{
+		{name: "full"},
+		{name: "projected", project: map[string][]string{"public.wide": {"c0", "c42"}}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			decoder, insert := newWideFixture(100, bc.project)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := decoder.Decode(insert); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPGOutputDecoder_ZeroColumnRelation(t *testing.T) {
+	decoder := NewPGOutputDecoder(&PGXSchemaLoader{types: TypeCache{"public": {"t": {"id": 23}}}}, "")
+	for _, in := range [][]byte{
+		append(append([]byte{'R', 0, 0, 0, 1}, "public\x00empty\x00d"...), 0, 0),
+		append(append([]byte{'R', 0, 0, 0, 2}, "public\x00t\x00d"...), 0, 1, 1, 'i', 'd', 0, 0, 0, 0, 23, 0xff, 0xff, 0xff, 0xff),
+	} {
+		if _, err := decoder.Decode(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		in []byte
+		op pb.Change_Operation
+	}{
+		{in: []byte{'I', 0, 0, 0, 1, 'N', 0, 0}, op: pb.Change_INSERT},
+		{in: []byte{'U', 0, 0, 0, 1, 'N', 0, 0}, op: pb.Change_UPDATE},
+		{in: []byte{'D', 0, 0, 0, 1, 'O', 0, 0}, op: pb.Change_DELETE},
+	} {
+		m, err := decoder.Decode(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := m.GetChange(); c == nil || c.Schema != "public" || c.Table != "empty" || c.Op != tc.op || len(c.Old) != 0 || len(c.New) != 0 {
+			t.Fatalf("unexpected %v", m.String())
+		}
+	}
+
+	// the fields more than the relation are dropped
+	for _, in := range [][]byte{
+		{'I', 0, 0, 0, 1, 'N', 0, 1, 'n'},
+		{'I', 0, 0, 0, 2, 'N', 0, 2, 'b', 0, 0, 0, 4, 0, 0, 0, 1, 'n'},
+		{'U', 0, 0, 0, 2, 'N', 0, 2, 'b', 0, 0, 0, 4, 0, 0, 0, 1, 'n'},
+	} {
+		m, err := decoder.Decode(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := m.GetChange(); c == nil || len(c.New) > 1 {
+			t.Fatalf("unexpected %v", m.String())
+		}
+	}
+
+	if _, err := decoder.Decode(nil); err == nil {
+		t.Fatal("empty message should fail")
+	}
+}
+
+func TestPGOutputDecoder_DDLOnly(t *testing.T) {
+	decoder := NewPGOutputDecoder(&PGXSchemaLoader{types: TypeCache{"public": {"t": {"id": 23}}}}, "")
+	decoder.DDLOnly = true
+	for _, in := range [][]byte{
+		append(append([]byte{'R', 0, 0, 0, 1}, "public\x00t\x00d"...), 0, 1, 1, 'i', 'd', 0, 0, 0, 0, 23, 0xff, 0xff, 0xff, 0xff),
+		append(append([]byte{'R', 0, 0, 0, 2}, ExtensionSchema+"\x00"+ExtensionDDLLogs+"\x00d"...), 0, 0),
+	} {
+		if _, err := decoder.Decode(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the tuples of the row changes are not decoded, even if they are malformed
+	for _, in := range [][]byte{
+		{'I', 0, 0, 0, 1, 'N', 0, 1, 'b', 0xff},
+		{'U', 0, 0, 0, 1, 'N', 0, 1, 'b', 0xff},
+		{'D', 0, 0, 0, 1, 'O', 0, 1, 'b', 0xff},
+	} {
+		if m, err := decoder.Decode(in); m != nil || err != nil {
+			t.Fatalf("unexpected %v %v", m, err)
+		}
+	}
+	m, err := decoder.Decode([]byte{'I', 0, 0, 0, 2, 'N', 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := m.GetChange(); c == nil || !IsDDL(c) {
+		t.Fatalf("unexpected %v", m.String())
+	}
+	if _, err = decoder.Decode([]byte{'I', 0, 0, 0, 3, 'N', 0, 0}); err == nil {
+		t.Fatal("unknown relation should fail")
+	}
+}
+
+func TestPGOutputDecoder_UnchangedToastBytea(t *testing.T) {
+	schema := &PGXSchemaLoader{types: TypeCache{"public": {"t": {"id": 23, "data": 17, "empty": 17}}}}
+	decoder := NewPGOutputDecoder(schema, "")
+	decoder.relations[1] = Relation{Rel: 1, NspName: "public", RelName: "t", Fields: []string{"id", "data", "empty"}}
+
+	// the unchanged external bytea is sent as 'u', unlike the changed empty bytea
+	in := []byte{'U', 0, 0, 0, 1, 'N', 0, 3, 'b', 0, 0, 0, 4, 0, 0, 0, 1, 'u', 'b', 0, 0, 0, 0}
+	m, err := decoder.Decode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := m.GetChange()
+	if c == nil || c.Op != pb.Change_UPDATE || len(c.New) != 2 || c.New[0].Name != "id" || c.New[1].Name != "empty" {
+		t.Fatalf("unexpected %v", m.String())
+	}
+	if v, ok := c.New[1].Value.(*pb.Field_Binary); !ok || len(v.Binary) != 0 {
+		t.Fatalf("the changed empty bytea should be kept %v", c.New[1].String())
+	}
+}
+
+func TestPGOutputDecoder_JSONBPatch(t *testing.T) {
+	schema := &PGXSchemaLoader{types: TypeCache{"public": {"t": {"id": 23, "js": 3802}}}}
+	decoder := NewPGOutputDecoder(schema, "")
+	decoder.relations[1] = Relation{Rel: 1, NspName: "public", RelName: "t", Fields: []string{"id", "js"}}
+	update := func(format byte, js string) []byte {
+		in := append([]byte{'U', 0, 0, 0, 1, 'N', 0, 2, 'b', 0, 0, 0, 4, 0, 0, 0, 1, format}, binary.BigEndian.AppendUint32(nil, uint32(len(js)))...)
+		return append(in, js...)
+	}
+
+	// the synthetic payload of an extension sending the sub-document changes instead of the whole document
+	patch := `{"$patch": [{"path": ["a", "b\"c"], "value": {"d": 1}}, {"path": ["e", "0"], "value": null}]}`
+	m, err := decoder.Decode(update(JSONBPatchFormat, patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := m.GetChange()
+	if c == nil || len(c.New) != 2 || c.New[1].Oid != JSONBPatchOID || c.New[1].GetText() != patch {
+		t.Fatalf("unexpected %v", m.String())
+	}
+	patches, ok := ParseJSONBPatches(c.New[1])
+	if !ok || len(patches) != 2 {
+		t.Fatalf("unexpected %v", patches)
+	}
+	if p := patches[0]; p.PathText() != `{"a","b\"c"}` || string(p.Value) != `{"d": 1}` {
+		t.Fatalf("unexpected %v %s", p.PathText(), p.Value)
+	}
+	if p := patches[1]; p.PathText() != `{"e","0"}` || string(p.Value) != `null` {
+		t.Fatalf("unexpected %v %s", p.PathText(), p.Value)
+	}
+
+	// the whole documents sent in text are never the patches, even if they look like ones
+	for _, js := range []string{patch, `{"$patch": 1, "a": 2}`} {
+		m, err := decoder.Decode(update('t', js))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := m.GetChange()
+		if c == nil || len(c.New) != 2 || c.New[1].Oid != 3802 || c.New[1].GetText() != js {
+			t.Fatalf("unexpected %v", m.String())
+		}
+		if _, ok := ParseJSONBPatches(c.New[1]); ok {
+			t.Fatalf("the jsonb field not sent as the patches should not be a patch %v", c.New[1].String())
+		}
+	}
+
+	// the malformed patches fail the decoding instead of being taken as the whole document
+	for _, js := range []string{`{"$patch": 1, "a": 2}`, `{"$patch": []}`, `{"$patch": [{"path": [], "value": 1}]}`, `not json`} {
+		if _, err := decoder.Decode(update(JSONBPatchFormat, js)); !errors.Is(err, ErrJSONBPatch) {
+			t.Fatalf("unexpected %v of %s", err, js)
+		}
+	}
+}
+
+func TestPGOutputDecoder_AuditedColumns(t *testing.T) {
+	schema := &PGXSchemaLoader{types: TypeCache{"public": {
+		"t":     {"id": 23, "status": 25, "balance": 23, "note": 25},
+		"other": {"id": 23, "note": 25},
+	}}}
+	decoder := NewPGOutputDecoder(schema, "")
+	decoder.AuditedColumns = map[string][]string{"public.t": {"id", "status", "balance"}}
+	column := func(name string, oid byte) []byte {
+		return append(append([]byte{1}, name+"\x00"...), 0, 0, 0, oid, 0xff, 0xff, 0xff, 0xff)
+	}
+	field := func(v string) []byte {
+		return append([]byte{'t', 0, 0, 0, byte(len(v))}, v...)
+	}
+	// the relations with the REPLICA IDENTITY FULL, which send the whole old tuples
+	for _, in := range [][]byte{
+		bytes.Join([][]byte{{'R', 0, 0, 0, 1}, []byte("public\x00t\x00f"), {0, 4}, column("id", 23), column("status", 25), column("balance", 23), column("note", 25)}, nil),
+		bytes.Join([][]byte{{'R', 0, 0, 0, 2}, []byte("public\x00other\x00f"), {0, 2}, column("id", 23), column("note", 25)}, nil),
+	} {
+		if _, err := decoder.Decode(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		in  []byte
+		old []string
+	}{
+		{
+			in:  bytes.Join([][]byte{{'U', 0, 0, 0, 1, 'O', 0, 4}, field("1"), field("open"), field("10"), field("a"), {'N', 0, 4}, field("1"), field("closed"), field("0"), field("b")}, nil),
+			old: []string{"id=1", "status=open", "balance=10"},
+		},
+		{
+			in:  bytes.Join([][]byte{{'D', 0, 0, 0, 1, 'O', 0, 4}, field("1"), field("closed"), field("0"), field("b")}, nil),
+			old: []string{"id=1", "status=closed", "balance=0"},
+		},
+		{
+			in:  bytes.Join([][]byte{{'U', 0, 0, 0, 2, 'O', 0, 2}, field("1"), field("a"), {'N', 0, 2}, field("1"), field("b")}, nil),
+			old: []string{"id=1", "note=a"},
+		},
+	} {
+		m, err := decoder.Decode(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		change := m.GetChange()
+		var old []string
+		for _, f := range change.Old {
+			old = append(old, f.Name+"="+f.GetText())
+		}
+		if fmt.Sprint(old) != fmt.Sprint(c.old) {
+			t.Fatalf("unexpected old values %v", old)
+		}
+		if change.Op == pb.Change_UPDATE && len(change.New) != len(decoder.relations[1].Fields) && change.Table == "t" {
+			t.Fatalf("the new values should be kept %v", change.New)
+		}
+	}
+}
+
+func TestPGOutputDecoder_CanonicalJSONB(t *testing.T) {
+	schema := &PGXSchemaLoader{types: TypeCache{"public": {"t": {"id": 23, "js": 3802}}}}
+	decoder := NewPGOutputDecoder(schema, "")
+	decoder.CanonicalJSONB = true
+	decoder.relations[1] = Relation{Rel: 1, NspName: "public", RelName: "t", Fields: []string{"id", "js"}}
+
+	tuple := func(op byte, format byte, js []byte) []byte {
+		in := []byte{op, 0, 0, 0, 1, 'N', 0, 2, 'b', 0, 0, 0, 4, 0, 0, 0, 1, format}
+		return append(binary.BigEndian.AppendUint32(in, uint32(len(js))), js...)
+	}
+	// the text of the jsonb orders the keys by their lengths first, unlike the canonical form
+	canonical := `{"a":[1,{"c":"<&>","dd":12345678901234567890.5}],"bb":null}`
+	for _, in := range [][]byte{
+		tuple('I', 't', []byte(`{"a": [1, {"c": "<&>", "dd": 12345678901234567890.5}], "bb": null}`)),
+		tuple('I', 't', []byte(`{"bb":null, "a":[1,{"dd":12345678901234567890.5,"c":"<&>"}]}`)),
+		tuple('U', 't', []byte("{\n\t\"bb\": null,\n\t\"a\": [1, {\"dd\": 12345678901234567890.5, \"c\": \"<&>\"}]\n}")),
+		tuple('I', 'b', append([]byte{1}, `{"bb": null, "a": [1, {"dd": 12345678901234567890.5, "c": "<&>"}]}`...)),
+	} {
+		m, err := decoder.Decode(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := m.GetChange().New[1]
+		doc := []byte(f.GetText())
+		if bs := f.GetBinary(); bs != nil {
+			if bs[0] != 1 {
+				t.Fatalf("unexpected version %v", bs[0])
+			}
+			doc = bs[1:]
+		}
+		if string(doc) != canonical {
+			t.Fatalf("unexpected %s", doc)
+		}
+	}
+}
+
+func TestPGOutputDecoder_SchemaFingerprint(t *testing.T) {
+	// the schema is not refreshed, and the types are taken from the relation messages
+	schema := &PGXSchemaLoader{types: TypeCache{"public": {"t": {"id": 23, "note": 25}}}}
+	decoder := NewPGOutputDecoder(schema, "")
+	column := func(name string, oid uint32, typmod int32) []byte {
+		bs := binary.BigEndian.AppendUint32(append([]byte{0}, name+"\x00"...), oid)
+		return binary.BigEndian.AppendUint32(bs, uint32(typmod))
+	}
+	relation := func(columns ...[]byte) []byte {
+		return bytes.Join(append([][]byte{{'R', 0, 0, 0, 1}, []byte("public\x00t\x00d"), {0, byte(len(columns))}}, columns...), nil)
+	}
+	fingerprint := func(in []byte) string {
+		if _, err := decoder.Decode(in); err != nil {
+			t.Fatal(err)
+		}
+		fp, ok := decoder.SchemaFingerprint("public", "t")
+		if !ok || fp == "" {
+			t.Fatalf("unexpected fingerprint %q", fp)
+		}
+		return fp
+	}
+
+	if _, ok := decoder.SchemaFingerprint("public", "t"); ok {
+		t.Fatal("unexpected fingerprint before the relation message")
+	}
+	origin := fingerprint(relation(column("id", 23, -1), column("note", 25, -1)))
+	if fp := fingerprint(relation(column("id", 23, -1), column("note", 25, -1))); fp != origin {
+		t.Fatalf("unexpected fingerprint %q of the identical schema, expected %q", fp, origin)
+	}
+
+	// ALTER TABLE t ADD COLUMN amount int
+	added := fingerprint(relation(column("id", 23, -1), column("note", 25, -1), column("amount", 23, -1)))
+	if added == origin {
+		t.Fatal("unexpected the same fingerprint after the ADD COLUMN")
+	}
+	if fp := fingerprint(relation(column("id", 23, -1), column("note", 25, -1), column("amount", 23, -1))); fp != added {
+		t.Fatalf("unexpected fingerprint %q of the identical schema, expected %q", fp, added)
+	}
+	// the reordered, the retyped and the re-modified columns
+	seen := map[string]bool{origin: true, added: true}
+	for _, in := range [][]byte{
+		relation(column("id", 23, -1), column("amount", 23, -1), column("note", 25, -1)),
+		relation(column("id", 23, -1), column("amount", 20, -1), column("note", 25, -1)),
+		relation(column("id", 23, -1), column("amount", 1700, 655366), column("note", 25, -1)),
+		relation(column("id", 23, -1), column("amount", 1700, 1310726), column("note", 25, -1)),
+	} {
+		fp := fingerprint(in)
+		if seen[fp] {
+			t.Fatalf("unexpected fingerprint %q seen before", fp)
+		}
+		seen[fp] = true
+	}
+}
+
+func TestPGOutputDecoder_SystemColumns(t *testing.T) {
+	schema := &PGXSchemaLoader{types: TypeCache{"public": {"t": {"id": 23}}}}
+	column := func(name string, oid byte) []byte {
+		return append(append([]byte{0}, name+"\x00"...), 0, 0, 0, oid, 0xff, 0xff, 0xff, 0xff)
+	}
+	// the synthetic plugin surfacing the system columns in the tuples
+	relation := bytes.Join([][]byte{{'R', 0, 0, 0, 1}, []byte("public\x00t\x00d"), {0, 5},
+		column("id", 23), column("xmin", 28), column("xmax", 28), column("cmin", 29), column("cmax", 29)}, nil)
+	insert := bytes.Join([][]byte{{'I', 0, 0, 0, 1, 'N', 0, 5},
+		{'t', 0, 0, 0, 1, '7'},
+		{'b', 0, 0, 0, 4, 0, 0, 0x30, 0x39},
+		{'t', 0, 0, 0, 1, '0'},
+		{'b', 0, 0, 0, 4, 0, 0, 0, 2},
+		{'t', 0, 0, 0, 1, '2'},
+	}, nil)
+
+	for _, enabled := range []bool{false, true} {
+		decoder := NewPGOutputDecoder(schema, "")
+		decoder.SystemColumns = enabled
+		if _, err := decoder.Decode(relation); err != nil {
+			t.Fatal(err)
+		}
+		m, err := decoder.Decode(insert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields, values := ExtractSystemColumns(m.GetChange().New)
+		if len(fields) != 1 || fields[0].Name != "id" || fields[0].GetText() != "7" {
+			t.Fatalf("unexpected %v", fields)
+		}
+		if !enabled {
+			if values != nil {
+				t.Fatalf("unexpected %v", values)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(values, map[string]uint32{"xmin": 12345, "xmax": 0, "cmin": 2, "cmax": 2}) {
+			t.Fatalf("unexpected %v", values)
+		}
+	}
+}
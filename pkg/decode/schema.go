@@ -49,6 +49,12 @@ type ColumnInfo struct {
 	keys                   fieldSet
 	identityGenerationList fieldSet
 	generatedList          fieldSet
+	sequenceList           fieldSet
+}
+
+// IsSequence reports whether the column owns a sequence, which is the serial or the identity column
+func (i ColumnInfo) IsSequence(f string) bool {
+	return i.sequenceList.Contains(f)
 }
 
 func (i ColumnInfo) IsGenerated(f string) bool {
@@ -72,7 +78,7 @@ func (i ColumnInfo) KeyLength() int {
 }
 
 func (i ColumnInfo) isEmpty() bool {
-	return i.keys.Len() == 0 && i.generatedList.Len() == 0 && i.identityGenerationList.Len() == 0
+	return i.keys.Len() == 0 && i.generatedList.Len() == 0 && i.identityGenerationList.Len() == 0 && i.sequenceList.Len() == 0
 }
 
 type fieldSelector func(i ColumnInfo, field string) bool
@@ -97,20 +103,61 @@ func (i ColumnInfo) Filter(fields []*pb.Field, fieldSelector fieldSelector) (fie
 	return set, fFields
 }
 
+// ColumnMeta is the formatted type, the nullability and the default expression of a column, for the sinks mirroring
+// the tables and the CheckSchemaCompatibility
+type ColumnMeta struct {
+	Name    string
+	Type    string
+	NotNull bool
+	// Default is the source text of the default expression, and is empty if the column has no default
+	Default string
+	// Filled means the server fills the column if omitted, by a default, an identity or a generation expression
+	Filled bool
+}
+
 type TypeCache map[string]map[string]map[string]uint32
 type KeysCache map[string]map[string]ColumnInfo
+type NameCache map[uint32]string
+
+// ArrayBase is the base element type and its array type of an array of enums or domains
+type ArrayBase struct {
+	Elem  uint32
+	Array uint32
+}
+
+type ArrayBaseCache map[uint32]ArrayBase
+
+// RangeBase is the base type of the subtype of a range not built in, and the built-in range of it, which is 0 if none
+type RangeBase struct {
+	Elem  uint32
+	Range uint32
+}
+
+type RangeBaseCache map[uint32]RangeBase
+
+type ColumnMetaCache map[string]map[string][]ColumnMeta
 
 func NewPGXSchemaLoader(conn *pgx.Conn) *PGXSchemaLoader {
-	return &PGXSchemaLoader{conn: conn, types: make(TypeCache), iKeys: make(KeysCache)}
+	return &PGXSchemaLoader{conn: conn, types: make(TypeCache), iKeys: make(KeysCache), tsConfigs: make(NameCache), pseudoTypes: make(NameCache), arrayBases: make(ArrayBaseCache), rangeBases: make(RangeBaseCache)}
 }
 
 type PGXSchemaLoader struct {
-	conn  *pgx.Conn
-	types TypeCache
-	iKeys KeysCache
+	conn        *pgx.Conn
+	types       TypeCache
+	iKeys       KeysCache
+	tsConfigs   NameCache
+	pseudoTypes NameCache
+	arrayBases  ArrayBaseCache
+	rangeBases  RangeBaseCache
+	columnMetas ColumnMetaCache
+	bounded     *tableCache
 }
 
 func (p *PGXSchemaLoader) RefreshType() error {
+	if p.bounded != nil {
+		p.bounded.reset()
+		return p.refreshTypeBases()
+	}
 	rows, err := p.conn.Query(context.Background(), sql.QueryAttrTypeOID)
 	if err != nil {
 		return err
@@ -135,9 +182,93 @@ func (p *PGXSchemaLoader) RefreshType() error {
 		}
 		cols[attname] = atttypid
 	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return p.refreshTypeBases()
+}
+
+func (p *PGXSchemaLoader) refreshTypeBases() (err error) {
+	if p.tsConfigs, err = p.queryNames(sql.QueryTSConfig); err != nil {
+		return err
+	}
+	if p.pseudoTypes, err = p.queryNames(sql.QueryPseudoTypes); err != nil {
+		return err
+	}
+	if err = p.refreshArrayBases(); err != nil {
+		return err
+	}
+	return p.refreshRangeBases()
+}
+
+func (p *PGXSchemaLoader) refreshArrayBases() error {
+	rows, err := p.conn.Query(context.Background(), sql.QueryArrayBaseTypes)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	bases := make(ArrayBaseCache)
+	var oid uint32
+	var base ArrayBase
+	for rows.Next() {
+		if err := rows.Scan(&oid, &base.Elem, &base.Array); err != nil {
+			return err
+		}
+		bases[oid] = base
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	p.arrayBases = bases
 	return nil
 }
 
+func (p *PGXSchemaLoader) refreshRangeBases() error {
+	rows, err := p.conn.Query(context.Background(), sql.QueryRangeBaseTypes)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	bases := make(RangeBaseCache)
+	var oid uint32
+	var base RangeBase
+	for rows.Next() {
+		if err := rows.Scan(&oid, &base.Elem, &base.Range); err != nil {
+			return err
+		}
+		bases[oid] = base
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	p.rangeBases = bases
+	return nil
+}
+
+func (p *PGXSchemaLoader) queryNames(query string) (NameCache, error) {
+	rows, err := p.conn.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(NameCache)
+	var oid uint32
+	var name string
+	for rows.Next() {
+		if err := rows.Scan(&oid, &name); err != nil {
+			return nil, err
+		}
+		names[oid] = name
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
 func (p *PGXSchemaLoader) RefreshColumnInfo() error {
 	rows, err := p.conn.Query(context.Background(), sql.QueryIdentityKeys)
 	if err != nil {
@@ -151,8 +282,9 @@ func (p *PGXSchemaLoader) RefreshColumnInfo() error {
 			keys                      pgtype.Array[pgtype.Text]
 			identityGenerationColumns pgtype.Array[pgtype.Text]
 			generatedColumns          pgtype.Array[pgtype.Text]
+			sequenceColumns           pgtype.Array[pgtype.Text]
 		)
-		if err := rows.Scan(&nspname, &relname, &keys, &identityGenerationColumns, &generatedColumns); err != nil {
+		if err := rows.Scan(&nspname, &relname, &keys, &identityGenerationColumns, &generatedColumns, &sequenceColumns); err != nil {
 			return err
 		}
 		tbls, ok := p.iKeys[nspname]
@@ -165,12 +297,84 @@ func (p *PGXSchemaLoader) RefreshColumnInfo() error {
 			keys:                   fieldSetWithList(keys),
 			identityGenerationList: fieldSetWithList(identityGenerationColumns),
 			generatedList:          fieldSetWithList(generatedColumns),
+			sequenceList:           fieldSetWithList(sequenceColumns),
 		}
 	}
 	return nil
 }
 
+// RefreshColumnMeta loads the types, the nullability and the default expressions of columns, which are not loaded by the
+// RefreshType since only the sinks mirroring the tables and the CheckSchemaCompatibility need them
+func (p *PGXSchemaLoader) RefreshColumnMeta() error {
+	return p.refreshColumnMeta(context.Background())
+}
+
+func (p *PGXSchemaLoader) refreshColumnMeta(ctx context.Context) error {
+	version, err := p.GetVersion()
+	if err != nil {
+		return err
+	}
+	if version < 120000 {
+		return p.loadColumnMeta(ctx, sql.QueryColumnMetaBeforePG12)
+	}
+	return p.loadColumnMeta(ctx, sql.QueryColumnMeta)
+}
+
+func (p *PGXSchemaLoader) loadColumnMeta(ctx context.Context, query string) error {
+	rows, err := p.conn.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	metas := make(ColumnMetaCache)
+	var nspname, relname string
+	for rows.Next() {
+		var meta ColumnMeta
+		var def pgtype.Text
+		if err := rows.Scan(&nspname, &relname, &meta.Name, &meta.Type, &meta.NotNull, &def, &meta.Filled); err != nil {
+			return err
+		}
+		meta.Default = def.String
+		tbls, ok := metas[nspname]
+		if !ok {
+			tbls = make(map[string][]ColumnMeta)
+			metas[nspname] = tbls
+		}
+		tbls[relname] = append(tbls[relname], meta)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	p.columnMetas = metas
+	return nil
+}
+
+// GetColumnMeta returns the metadata of the columns of the table in their order, loaded by the RefreshColumnMeta
+func (p *PGXSchemaLoader) GetColumnMeta(namespace, table string) ([]ColumnMeta, error) {
+	if tbls, ok := p.columnMetas[namespace]; !ok {
+		return nil, fmt.Errorf("%s.%s %w", namespace, table, ErrSchemaTableMissing)
+	} else if metas, ok := tbls[table]; !ok {
+		return nil, fmt.Errorf("%s.%s %w", namespace, table, ErrSchemaTableMissing)
+	} else {
+		return metas, nil
+	}
+}
+
 func (p *PGXSchemaLoader) GetTypeOID(namespace, table, field string) (oid uint32, err error) {
+	if p.bounded != nil {
+		cols, err := p.bounded.get(namespace, table)
+		if err != nil {
+			return 0, err
+		}
+		if cols == nil {
+			return 0, fmt.Errorf("%s.%s %w", namespace, table, ErrSchemaTableMissing)
+		}
+		if oid, ok := cols[field]; ok {
+			return oid, nil
+		}
+		return 0, fmt.Errorf("%s.%s.%s %w", namespace, table, field, ErrSchemaColumnMissing)
+	}
 	if tbls, ok := p.types[namespace]; !ok {
 		return 0, fmt.Errorf("%s.%s %w", namespace, table, ErrSchemaTableMissing)
 	} else if cols, ok := tbls[table]; !ok {
@@ -181,6 +385,28 @@ func (p *PGXSchemaLoader) GetTypeOID(namespace, table, field string) (oid uint32
 	return oid, nil
 }
 
+func (p *PGXSchemaLoader) GetTSConfigName(oid uint32) (name string, ok bool) {
+	name, ok = p.tsConfigs[oid]
+	return
+}
+
+// GetArrayBase returns the base types of the array of enums or domains
+func (p *PGXSchemaLoader) GetArrayBase(oid uint32) (base ArrayBase, ok bool) {
+	base, ok = p.arrayBases[oid]
+	return
+}
+
+// GetRangeBase returns the base types of the range not built in, like the range of a domain
+func (p *PGXSchemaLoader) GetRangeBase(oid uint32) (base RangeBase, ok bool) {
+	base, ok = p.rangeBases[oid]
+	return
+}
+
+func (p *PGXSchemaLoader) IsPseudoType(oid uint32) bool {
+	_, ok := p.pseudoTypes[oid]
+	return ok
+}
+
 func (p *PGXSchemaLoader) GetColumnInfo(namespace, table string) (*ColumnInfo, error) {
 	if tbls, ok := p.iKeys[namespace]; !ok {
 		return nil, fmt.Errorf("%s.%s %w", namespace, table, ErrSchemaIdentityMissing)
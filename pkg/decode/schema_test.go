@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -158,6 +159,122 @@ func TestSchemaLoader(t *testing.T) {
 		}
 	})
 
+	t.Run("GetTSConfigName", func(t *testing.T) {
+		var oid uint32
+		if err = conn.QueryRow(ctx, "select 'english'::regconfig::oid").Scan(&oid); err != nil {
+			t.Fatal(err)
+		}
+		if err = schema.RefreshType(); err != nil {
+			t.Fatalf("RefreshType fail: %v", err)
+		}
+		if name, ok := schema.GetTSConfigName(oid); !ok || name != "english" {
+			t.Fatalf("GetTSConfigName not match %v %v", name, ok)
+		}
+		if _, ok := schema.GetTSConfigName(0); ok {
+			t.Fatal("GetTSConfigName should not found invalid oid")
+		}
+	})
+
+	t.Run("IsPseudoType", func(t *testing.T) {
+		if err = schema.RefreshType(); err != nil {
+			t.Fatalf("RefreshType fail: %v", err)
+		}
+		if !schema.IsPseudoType(2278) || !schema.IsPseudoType(2275) {
+			t.Fatal("void and cstring should be pseudo types")
+		}
+		if schema.IsPseudoType(RefCursorOID) || schema.IsPseudoType(23) {
+			t.Fatal("refcursor and int4 should not be pseudo types")
+		}
+	})
+
+	t.Run("GetArrayBase", func(t *testing.T) {
+		if _, err = conn.Exec(ctx, "create type mood as enum ('sad', 'happy'); create domain posint as int4 check (value > 0); create domain small as posint"); err != nil {
+			t.Fatal(err)
+		}
+		if err = schema.RefreshType(); err != nil {
+			t.Fatalf("RefreshType fail: %v", err)
+		}
+		for name, expect := range map[string]ArrayBase{
+			"_mood":   {Elem: 25, Array: 1009},
+			"_posint": {Elem: 23, Array: 1007},
+			"_small":  {Elem: 23, Array: 1007},
+		} {
+			var oid uint32
+			if err = conn.QueryRow(ctx, "select oid from pg_type where typname = $1", name).Scan(&oid); err != nil {
+				t.Fatal(err)
+			}
+			if base, ok := schema.GetArrayBase(oid); !ok || base != expect {
+				t.Fatalf("unexpected base of %s %v", name, base)
+			}
+		}
+		if _, ok := schema.GetArrayBase(1007); ok {
+			t.Fatal("int4[] should not have a base")
+		}
+	})
+
+	t.Run("GetRangeBase", func(t *testing.T) {
+		if _, err = conn.Exec(ctx, "create domain price as numeric(20,9); create type pricerange as range (subtype = price); create type floatrange as range (subtype = float8)"); err != nil {
+			t.Fatal(err)
+		}
+		if err = schema.RefreshType(); err != nil {
+			t.Fatalf("RefreshType fail: %v", err)
+		}
+		for name, expect := range map[string]RangeBase{
+			"pricerange": {Elem: 1700, Range: 3906},
+			"floatrange": {Elem: 701, Range: 0},
+		} {
+			var oid uint32
+			if err = conn.QueryRow(ctx, "select oid from pg_type where typname = $1", name).Scan(&oid); err != nil {
+				t.Fatal(err)
+			}
+			if base, ok := schema.GetRangeBase(oid); !ok || base != expect {
+				t.Fatalf("unexpected base of %s %v", name, base)
+			}
+		}
+		if _, ok := schema.GetRangeBase(3906); ok {
+			t.Fatal("numrange should not have a base")
+		}
+	})
+
+	t.Run("GetColumnMeta", func(t *testing.T) {
+		for _, q := range []string{
+			"create sequence t_meta_seq",
+			"create table t_meta (id serial primary key, code bigint not null default nextval('t_meta_seq'), created timestamptz default now(), note text default 'n/a', v int)",
+		} {
+			if _, err = conn.Exec(ctx, q); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = schema.RefreshColumnMeta(); err != nil {
+			t.Fatal(err)
+		}
+		metas, err := schema.GetColumnMeta("public", "t_meta")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := []ColumnMeta{
+			{Name: "id", Type: "integer", NotNull: true, Default: "nextval('t_meta_id_seq'::regclass)", Filled: true},
+			{Name: "code", Type: "bigint", NotNull: true, Default: "nextval('t_meta_seq'::regclass)", Filled: true},
+			{Name: "created", Type: "timestamp with time zone", Default: "now()", Filled: true},
+			{Name: "note", Type: "text", Default: "'n/a'::text", Filled: true},
+			{Name: "v", Type: "integer"},
+		}
+		if !reflect.DeepEqual(metas, expect) {
+			t.Fatalf("unexpected %v", metas)
+		}
+		if _, err = schema.GetColumnMeta("public", "other"); !errors.Is(err, ErrSchemaTableMissing) {
+			t.Fatalf("unexpected %v", err)
+		}
+
+		// the query for the servers before PG12 lists the same columns, which are never generated
+		if err = schema.loadColumnMeta(ctx, sql.QueryColumnMetaBeforePG12); err != nil {
+			t.Fatal(err)
+		}
+		if metas, err = schema.GetColumnMeta("public", "t_meta"); err != nil || !reflect.DeepEqual(metas, expect) {
+			t.Fatalf("unexpected %v %v", metas, err)
+		}
+	})
+
 	t.Run("GetVersion", func(t *testing.T) {
 		if _, err := schema.GetVersion(); err != nil {
 			t.Fatal(err)
@@ -0,0 +1,98 @@
+package decode
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/replicase/pgcapture/pkg/sql"
+)
+
+// NewBoundedPGXSchemaLoader keeps the column types of at most maxTables recently decoded tables, instead of all the
+// tables of the database. The column types are loaded by a query on the first change of a table since it is evicted,
+// or since the RefreshType, which only clears them.
+func NewBoundedPGXSchemaLoader(conn *pgx.Conn, maxTables int) *PGXSchemaLoader {
+	p := NewPGXSchemaLoader(conn)
+	p.bounded = newTableCache(maxTables, p.queryTableTypes)
+	return p
+}
+
+type tableEntry struct {
+	key  string
+	cols map[string]uint32
+}
+
+// tableCache is a LRU cache of the column types keyed by "schema.table", and the missing tables are cached as nil,
+// so that the changes of them are not querying repeatedly.
+type tableCache struct {
+	mu    sync.Mutex
+	max   int
+	lru   *list.List
+	items map[string]*list.Element
+	load  func(namespace, table string) (map[string]uint32, error)
+}
+
+func newTableCache(max int, load func(namespace, table string) (map[string]uint32, error)) *tableCache {
+	return &tableCache{max: max, lru: list.New(), items: make(map[string]*list.Element), load: load}
+}
+
+func (c *tableCache) get(namespace, table string) (map[string]uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := namespace + "." + table
+	if e, ok := c.items[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*tableEntry).cols, nil
+	}
+	cols, err := c.load(namespace, table)
+	if err != nil {
+		return nil, err
+	}
+	c.items[key] = c.lru.PushFront(&tableEntry{key: key, cols: cols})
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*tableEntry).key)
+	}
+	return cols, nil
+}
+
+func (c *tableCache) reset() {
+	c.mu.Lock()
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.mu.Unlock()
+}
+
+func (c *tableCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (p *PGXSchemaLoader) queryTableTypes(namespace, table string) (map[string]uint32, error) {
+	rows, err := p.conn.Query(context.Background(), sql.QueryTableAttrTypeOID, namespace, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols map[string]uint32
+	var attname string
+	var atttypid uint32
+	for rows.Next() {
+		if err := rows.Scan(&attname, &atttypid); err != nil {
+			return nil, err
+		}
+		if cols == nil {
+			cols = make(map[string]uint32)
+		}
+		cols[attname] = atttypid
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return cols, nil
+}
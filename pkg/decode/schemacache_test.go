@@ -0,0 +1,70 @@
+package decode
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestPGXSchemaLoader_Bounded(t *testing.T) {
+	loads := map[string]int{}
+	types := map[string]map[string]uint32{
+		"t1": {"id": pgtype.Int4OID},
+		"t2": {"id": pgtype.Int8OID},
+		"t3": {"id": pgtype.TextOID},
+	}
+	p := &PGXSchemaLoader{}
+	p.bounded = newTableCache(2, func(namespace, table string) (map[string]uint32, error) {
+		loads[table]++
+		return types[table], nil
+	})
+
+	for _, c := range []struct {
+		table string
+		oid   uint32
+		loads int
+	}{
+		{table: "t1", oid: pgtype.Int4OID, loads: 1},
+		{table: "t2", oid: pgtype.Int8OID, loads: 1},
+		{table: "t1", oid: pgtype.Int4OID, loads: 1},
+		// the t2 is evicted as the least recently used
+		{table: "t3", oid: pgtype.TextOID, loads: 1},
+		{table: "t1", oid: pgtype.Int4OID, loads: 1},
+		{table: "t2", oid: pgtype.Int8OID, loads: 2},
+	} {
+		oid, err := p.GetTypeOID("public", c.table, "id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if oid != c.oid || loads[c.table] != c.loads {
+			t.Fatalf("unexpected %s %d %d", c.table, oid, loads[c.table])
+		}
+		if n := p.bounded.len(); n > 2 {
+			t.Fatalf("unexpected cached %d", n)
+		}
+	}
+
+	// the evicted relation reappearing with a changed layout is loaded again
+	types["t3"] = map[string]uint32{"id": pgtype.Int8OID}
+	if oid, err := p.GetTypeOID("public", "t3", "id"); err != nil || oid != pgtype.Int8OID || loads["t3"] != 2 {
+		t.Fatalf("unexpected %d %v %d", oid, err, loads["t3"])
+	}
+
+	if _, err := p.GetTypeOID("public", "t2", "other"); !errors.Is(err, ErrSchemaColumnMissing) {
+		t.Fatalf("unexpected %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := p.GetTypeOID("public", "t4", "id"); !errors.Is(err, ErrSchemaTableMissing) {
+			t.Fatalf("unexpected %v", err)
+		}
+	}
+	if loads["t4"] != 1 {
+		t.Fatalf("unexpected %d", loads["t4"])
+	}
+
+	p.bounded.reset()
+	if n := p.bounded.len(); n != 0 {
+		t.Fatalf("unexpected cached %d", n)
+	}
+}
@@ -0,0 +1,174 @@
+package decode
+
+import (
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+type SchemaEventKind int
+
+const (
+	// SchemaEventComment is a COMMENT ON, which removes the comment if the Comment is empty
+	SchemaEventComment SchemaEventKind = iota
+	// SchemaEventGrant is a GRANT of the privileges on the objects
+	SchemaEventGrant
+	// SchemaEventRevoke is a REVOKE of the privileges on the objects
+	SchemaEventRevoke
+)
+
+func (k SchemaEventKind) String() string {
+	switch k {
+	case SchemaEventComment:
+		return "comment"
+	case SchemaEventGrant:
+		return "grant"
+	case SchemaEventRevoke:
+		return "revoke"
+	}
+	return "unknown schema event"
+}
+
+// Privilege is a privilege of the GRANT or REVOKE, which is limited to the Columns if not empty
+type Privilege struct {
+	Name    string
+	Columns []string
+}
+
+// SchemaEvent is a typed COMMENT ON, GRANT or REVOKE statement of a DDL change, for the downstream to mirror the
+// documentation and the privileges without parsing the query
+type SchemaEvent struct {
+	Kind SchemaEventKind
+	// ObjectType is the lower case type of the objects, like "table", "column" or "schema"
+	ObjectType string
+	// Objects are the names of the objects, qualified by the schema if they are in the statement
+	Objects []string
+	// Column is the column of the COMMENT ON COLUMN, whose table is the only one of the Objects
+	Column  string
+	Comment string
+	// Privileges are empty for the ALL PRIVILEGES
+	Privileges []Privilege
+	// Roles are the grantees of the GRANT or REVOKE, and the PUBLIC and CURRENT_USER are in lower case
+	Roles       []string
+	GrantOption bool
+	// InSchema means the Objects are the schemas of the ALL TABLES IN SCHEMA or alike
+	InSchema bool
+}
+
+// ParseSchemaEvents returns the COMMENT ON, GRANT and REVOKE statements of the DDL change as the typed events,
+// while the other DDL statements of the change are ignored
+func ParseSchemaEvents(m *pb.Change) ([]SchemaEvent, error) {
+	var query string
+	for _, f := range m.New {
+		if f.Name == "query" {
+			if f.GetBinary() != nil {
+				query = string(f.GetBinary())
+			} else {
+				query = f.GetText()
+			}
+			break
+		}
+	}
+	tree, err := pg_query.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	var events []SchemaEvent
+	for _, stmt := range tree.Stmts {
+		if event, ok := StmtSchemaEvent(stmt.Stmt); ok {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// StmtSchemaEvent returns the typed event of the parsed statement if it is a COMMENT ON, GRANT or REVOKE
+func StmtSchemaEvent(stmt *pg_query.Node) (event SchemaEvent, ok bool) {
+	switch node := stmt.GetNode().(type) {
+	case *pg_query.Node_CommentStmt:
+		event.Kind = SchemaEventComment
+		event.ObjectType = objectType(node.CommentStmt.Objtype)
+		event.Comment = node.CommentStmt.Comment
+		name := objectName(node.CommentStmt.Object)
+		if node.CommentStmt.Objtype == pg_query.ObjectType_OBJECT_COLUMN {
+			if i := strings.LastIndexByte(name, '.'); i > 0 {
+				name, event.Column = name[:i], name[i+1:]
+			}
+		}
+		event.Objects = []string{name}
+		return event, true
+	case *pg_query.Node_GrantStmt:
+		event.Kind = SchemaEventRevoke
+		if node.GrantStmt.IsGrant {
+			event.Kind = SchemaEventGrant
+		}
+		event.ObjectType = objectType(node.GrantStmt.Objtype)
+		event.InSchema = node.GrantStmt.Targtype == pg_query.GrantTargetType_ACL_TARGET_ALL_IN_SCHEMA
+		event.GrantOption = node.GrantStmt.GrantOption
+		for _, o := range node.GrantStmt.Objects {
+			event.Objects = append(event.Objects, objectName(o))
+		}
+		for _, p := range node.GrantStmt.Privileges {
+			priv := p.GetAccessPriv()
+			if priv == nil {
+				continue
+			}
+			privilege := Privilege{Name: priv.PrivName}
+			for _, c := range priv.Cols {
+				privilege.Columns = append(privilege.Columns, objectName(c))
+			}
+			event.Privileges = append(event.Privileges, privilege)
+		}
+		for _, g := range node.GrantStmt.Grantees {
+			if role := g.GetRoleSpec(); role != nil {
+				event.Roles = append(event.Roles, roleName(role))
+			}
+		}
+		return event, true
+	}
+	return event, false
+}
+
+func objectType(t pg_query.ObjectType) string {
+	return strings.ToLower(strings.TrimPrefix(t.String(), "OBJECT_"))
+}
+
+func objectName(node *pg_query.Node) string {
+	switch n := node.GetNode().(type) {
+	case *pg_query.Node_String_:
+		return n.String_.Str
+	case *pg_query.Node_RangeVar:
+		if n.RangeVar.Schemaname == "" {
+			return n.RangeVar.Relname
+		}
+		return n.RangeVar.Schemaname + "." + n.RangeVar.Relname
+	case *pg_query.Node_List:
+		return joinNames(n.List.Items)
+	case *pg_query.Node_ObjectWithArgs:
+		return joinNames(n.ObjectWithArgs.Objname)
+	case *pg_query.Node_TypeName:
+		return joinNames(n.TypeName.Names)
+	}
+	return ""
+}
+
+func joinNames(nodes []*pg_query.Node) string {
+	names := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		names = append(names, objectName(n))
+	}
+	return strings.Join(names, ".")
+}
+
+func roleName(role *pg_query.RoleSpec) string {
+	switch role.Roletype {
+	case pg_query.RoleSpecType_ROLESPEC_PUBLIC:
+		return "public"
+	case pg_query.RoleSpecType_ROLESPEC_CURRENT_USER:
+		return "current_user"
+	case pg_query.RoleSpecType_ROLESPEC_SESSION_USER:
+		return "session_user"
+	}
+	return role.Rolename
+}
@@ -0,0 +1,57 @@
+package decode
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+func ddlChange(query string) *pb.Change {
+	return &pb.Change{Op: pb.Change_INSERT, Schema: ExtensionSchema, Table: ExtensionDDLLogs, New: []*pb.Field{
+		{Name: "query", Value: &pb.Field_Binary{Binary: []byte(query)}},
+	}}
+}
+
+func TestParseSchemaEvents(t *testing.T) {
+	for _, c := range []struct {
+		query  string
+		expect []SchemaEvent
+	}{
+		{
+			query:  "COMMENT ON COLUMN public.t.c IS 'the c'",
+			expect: []SchemaEvent{{Kind: SchemaEventComment, ObjectType: "column", Objects: []string{"public.t"}, Column: "c", Comment: "the c"}},
+		},
+		{
+			query:  "create table t2 (id int); COMMENT ON TABLE t2 IS NULL",
+			expect: []SchemaEvent{{Kind: SchemaEventComment, ObjectType: "table", Objects: []string{"t2"}}},
+		},
+		{
+			query: "GRANT SELECT, UPDATE (a, b) ON public.t, t2 TO reader, PUBLIC WITH GRANT OPTION",
+			expect: []SchemaEvent{{
+				Kind: SchemaEventGrant, ObjectType: "table", Objects: []string{"public.t", "t2"},
+				Privileges:  []Privilege{{Name: "select"}, {Name: "update", Columns: []string{"a", "b"}}},
+				Roles:       []string{"reader", "public"},
+				GrantOption: true,
+			}},
+		},
+		{
+			query:  "REVOKE ALL ON ALL TABLES IN SCHEMA s1 FROM writer",
+			expect: []SchemaEvent{{Kind: SchemaEventRevoke, ObjectType: "table", Objects: []string{"s1"}, Roles: []string{"writer"}, InSchema: true}},
+		},
+		{
+			query: "alter table t add column d int",
+		},
+	} {
+		events, err := ParseSchemaEvents(ddlChange(c.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(events, c.expect) {
+			t.Fatalf("unexpected %q: %+v", c.query, events)
+		}
+	}
+	if _, err := ParseSchemaEvents(ddlChange("COMMENT ON")); err == nil {
+		t.Fatal("unexpected")
+	}
+}
@@ -0,0 +1,61 @@
+package decode
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+// SystemColumns are the type oids of the system columns, which are decoded by the SystemColumns of the decoders
+// if the plugin sends them in the tuples. No user column can be named like them.
+var SystemColumns = map[string]uint32{
+	"xmin": pgtype.XIDOID,
+	"xmax": pgtype.XIDOID,
+	"cmin": pgtype.CIDOID,
+	"cmax": pgtype.CIDOID,
+}
+
+// systemColumnField decodes the system column into the field of its uint32 in binary, or nil if it is not one
+func systemColumnField(name string, s Field) *pb.Field {
+	oid, ok := SystemColumns[name]
+	if !ok {
+		return nil
+	}
+	var v uint32
+	switch s.Format {
+	case 'b':
+		if len(s.Datum) != 4 {
+			return nil
+		}
+		v = binary.BigEndian.Uint32(s.Datum)
+	case 't':
+		n, err := strconv.ParseUint(string(s.Datum), 10, 32)
+		if err != nil {
+			return nil
+		}
+		v = uint32(n)
+	default:
+		return nil
+	}
+	return &pb.Field{Name: name, Oid: oid, Value: &pb.Field_Binary{Binary: binary.BigEndian.AppendUint32(nil, v)}}
+}
+
+// ExtractSystemColumns removes the fields of the system columns decoded from the tuple, and returns their values
+// keyed by the names, which is nil if there is none
+func ExtractSystemColumns(fields []*pb.Field) ([]*pb.Field, map[string]uint32) {
+	var values map[string]uint32
+	kept := fields[:0]
+	for _, f := range fields {
+		if oid, ok := SystemColumns[f.Name]; ok && f.Oid == oid && len(f.GetBinary()) == 4 {
+			if values == nil {
+				values = make(map[string]uint32, len(SystemColumns))
+			}
+			values[f.Name] = binary.BigEndian.Uint32(f.GetBinary())
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept, values
+}
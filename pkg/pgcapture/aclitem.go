@@ -0,0 +1,98 @@
+package pgcapture
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var ErrInvalidACLItem = errors.New("invalid aclitem")
+
+// ACLItem is a parsed grant entry of the aclitem type, which is only available in the text format.
+// It can be used as the model field for both aclitem and aclitem[] columns.
+type ACLItem struct {
+	Grantee    string // empty for PUBLIC
+	Grantor    string
+	Privileges string // privilege letters like "arwd", each may be followed by '*' for the grant option
+	Valid      bool
+}
+
+func (a *ACLItem) ScanText(v pgtype.Text) (err error) {
+	if !v.Valid {
+		*a = ACLItem{}
+		return nil
+	}
+	*a, err = ParseACLItem(v.String)
+	return err
+}
+
+// ParseACLItem parses the "grantee=privileges/grantor" form produced by the aclitemout
+func ParseACLItem(s string) (item ACLItem, err error) {
+	if item.Grantee, s, err = parseACLName(s); err != nil {
+		return
+	}
+	if len(s) == 0 || s[0] != '=' {
+		return item, ErrInvalidACLItem
+	}
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return item, ErrInvalidACLItem
+	}
+	item.Privileges = s[1:i]
+	if item.Grantor, s, err = parseACLName(s[i+1:]); err != nil {
+		return
+	}
+	if len(s) != 0 || item.Grantor == "" {
+		return item, ErrInvalidACLItem
+	}
+	item.Valid = true
+	return
+}
+
+func (a ACLItem) HasPrivilege(p byte) bool {
+	return strings.IndexByte(a.Privileges, p) >= 0
+}
+
+func (a ACLItem) HasGrantOption(p byte) bool {
+	i := strings.IndexByte(a.Privileges, p)
+	return i >= 0 && i+1 < len(a.Privileges) && a.Privileges[i+1] == '*'
+}
+
+func (a ACLItem) String() string {
+	return quoteACLName(a.Grantee) + "=" + a.Privileges + "/" + quoteACLName(a.Grantor)
+}
+
+// parseACLName reads a role name which may be double-quoted with "" as the escaped quote
+func parseACLName(s string) (name, rest string, err error) {
+	if len(s) == 0 || s[0] != '"' {
+		i := strings.IndexAny(s, "=/")
+		if i < 0 {
+			i = len(s)
+		}
+		return s[:i], s[i:], nil
+	}
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] == '"' {
+			if i+1 < len(s) && s[i+1] == '"' {
+				sb.WriteByte('"')
+				i++
+				continue
+			}
+			return sb.String(), s[i+1:], nil
+		}
+		sb.WriteByte(s[i])
+	}
+	return "", "", ErrInvalidACLItem
+}
+
+// quoteACLName quotes the role name like the putid of aclitemout does
+func quoteACLName(name string) string {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+		}
+	}
+	return name
+}
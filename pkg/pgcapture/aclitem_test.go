@@ -0,0 +1,56 @@
+package pgcapture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+type ACLModel struct {
+	ACL  ACLItem   `pg:"acl"`
+	ACLs []ACLItem `pg:"acls"`
+}
+
+func (m *ACLModel) TableName() (schema, table string) {
+	return "", "acl"
+}
+
+func TestMakeModel_ACLItem(t *testing.T) {
+	ref, err := reflectModel(&ACLModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := makeModel(ref, []*pb.Field{
+		{Name: "acl", Oid: pgtype.ACLItemOID, Value: &pb.Field_Text{Text: "postgres=arwdDxt/postgres"}},
+		{Name: "acls", Oid: pgtype.ACLItemArrayOID, Value: &pb.Field_Text{Text: `{=r/postgres,"\"my \"\"user\"\"\"=r*w/admin"}`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := m.(*ACLModel)
+	if !reflect.DeepEqual(model.ACL, ACLItem{Grantee: "postgres", Grantor: "postgres", Privileges: "arwdDxt", Valid: true}) {
+		t.Fatalf("unexpected %v", model.ACL)
+	}
+	if !reflect.DeepEqual(model.ACLs, []ACLItem{
+		{Grantee: "", Grantor: "postgres", Privileges: "r", Valid: true},
+		{Grantee: `my "user"`, Grantor: "admin", Privileges: "r*w", Valid: true},
+	}) {
+		t.Fatalf("unexpected %v", model.ACLs)
+	}
+	if !model.ACLs[1].HasGrantOption('r') || model.ACLs[1].HasGrantOption('w') || !model.ACLs[1].HasPrivilege('w') {
+		t.Fatalf("unexpected %v", model.ACLs[1])
+	}
+	if s := model.ACLs[1].String(); s != `"my ""user"""=r*w/admin` {
+		t.Fatalf("unexpected %v", s)
+	}
+}
+
+func TestParseACLItem_Invalid(t *testing.T) {
+	for _, s := range []string{"", "postgres", "postgres=r", `"postgres=r/postgres`, "postgres=r/", "postgres=r/postgres/x"} {
+		if _, err := ParseACLItem(s); err != ErrInvalidACLItem {
+			t.Fatalf("unexpected %v for %q", err, s)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package pgcapture
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strconv"
+
+	pgtypeV4 "github.com/jackc/pgtype"
+)
+
+var arrayDimensionsType = reflect.TypeOf([]pgtypeV4.ArrayDimension(nil))
+
+// decodeTextV4 decodes the text value into the pgtype v4 decoder, and preserves the explicit dimension
+// bounds of arrays like '[-1:1]={a,b,c}' on its own, because the negative bounds are rejected by pgtype v4.
+func decodeTextV4(decoder pgtypeV4.TextDecoder, src []byte) error {
+	dims := arrayDimensions(decoder)
+	if !dims.IsValid() {
+		return decoder.DecodeText(ci, src)
+	}
+	bounds, rest, ok := splitArrayBounds(src)
+	if !ok {
+		return decoder.DecodeText(ci, src)
+	}
+	if err := decoder.DecodeText(ci, rest); err != nil {
+		return err
+	}
+	decoded := dims.Interface().([]pgtypeV4.ArrayDimension)
+	if len(decoded) != len(bounds) {
+		return errors.New("array dimensions mismatch: " + string(src))
+	}
+	for i, b := range bounds {
+		if decoded[i].Length != b.Length {
+			return errors.New("array dimensions mismatch: " + string(src))
+		}
+		decoded[i].LowerBound = b.LowerBound
+	}
+	return nil
+}
+
+func arrayDimensions(decoder pgtypeV4.TextDecoder) reflect.Value {
+	val := reflect.ValueOf(decoder)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	if dims := val.Elem().FieldByName("Dimensions"); dims.IsValid() && dims.Type() == arrayDimensionsType {
+		return dims
+	}
+	return reflect.Value{}
+}
+
+// splitArrayBounds splits the '[lower:upper]...=' prefix from the array literal
+func splitArrayBounds(src []byte) (bounds []pgtypeV4.ArrayDimension, rest []byte, ok bool) {
+	rest = src
+	for len(rest) != 0 && rest[0] == '[' {
+		end := bytes.IndexByte(rest, ']')
+		if end < 0 {
+			return nil, src, false
+		}
+		lower, upper, found := bytes.Cut(rest[1:end], []byte(":"))
+		if !found {
+			return nil, src, false
+		}
+		l, err := strconv.ParseInt(string(lower), 10, 32)
+		if err != nil {
+			return nil, src, false
+		}
+		u, err := strconv.ParseInt(string(upper), 10, 32)
+		if err != nil || u < l {
+			return nil, src, false
+		}
+		bounds = append(bounds, pgtypeV4.ArrayDimension{Length: int32(u - l + 1), LowerBound: int32(l)})
+		rest = rest[end+1:]
+	}
+	if len(bounds) == 0 || len(rest) == 0 || rest[0] != '=' {
+		return nil, src, false
+	}
+	return bounds, rest[1:], true
+}
@@ -3,6 +3,7 @@ package pgcapture
 import (
 	"context"
 	"reflect"
+	"sort"
 	"time"
 
 	pgtypeV4 "github.com/jackc/pgtype"
@@ -120,11 +121,16 @@ func (c *Consumer) ConsumeAsync(mh ModelAsyncHandlers) error {
 				c.errFn(change, err)
 				break
 			}
+			var unchanged []string
+			if m.Change.Op == pb.Change_UPDATE {
+				unchanged = unchangedColumns(ref, m.Change.New)
+			}
 			c.Bouncer.Handle(ref.hdl, change.Checkpoint, Change{
 				Op:         m.Change.Op,
 				Checkpoint: change.Checkpoint,
 				New:        n,
 				Old:        o,
+				Unchanged:  unchanged,
 			})
 			continue
 		}
@@ -171,7 +177,7 @@ func makeModel(ref reflection, fields []*pb.Field) (interface{}, error) {
 				}
 			} else {
 				if decoder, ok := field.(pgtypeV4.TextDecoder); ok {
-					err = decoder.DecodeText(ci, []byte(f.GetText()))
+					err = decodeTextV4(decoder, []byte(f.GetText()))
 				} else {
 					err = typeMap.Scan(f.Oid, pgtype.TextFormatCode, []byte(f.GetText()), field)
 				}
@@ -184,6 +190,21 @@ func makeModel(ref reflection, fields []*pb.Field) (interface{}, error) {
 	return ptr.Interface(), nil
 }
 
+// unchangedColumns returns the sorted columns of the model which are not in the fields
+func unchangedColumns(ref reflection, fields []*pb.Field) (columns []string) {
+	present := make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		present[f.Name] = struct{}{}
+	}
+	for name := range ref.idx {
+		if _, ok := present[name]; !ok {
+			columns = append(columns, name)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
 func (c *Consumer) Stop() {
 	c.Source.Stop()
 }
@@ -371,3 +371,293 @@ func TestMakeModel(t *testing.T) {
 		}
 	}
 }
+
+type TextArrayModel struct {
+	V5      []pgtype.Text         `pg:"v5"`
+	Ptrs    []*string             `pg:"ptrs"`
+	V4      pgtypeV4.TextArray    `pg:"v4"`
+	V4Var   pgtypeV4.VarcharArray `pg:"v4var"`
+	Varchar []pgtype.Text         `pg:"varchar"`
+}
+
+func (m *TextArrayModel) TableName() (schema, table string) {
+	return "", "text_array"
+}
+
+func TestMakeModel_TextArray(t *testing.T) {
+	ref, err := reflectModel(&TextArrayModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []*string{ptr("a,b"), ptr(`c"d`), ptr(`e\f`), ptr(""), nil, ptr("NULL"), ptr("g")}
+	text := `{"a,b","c\"d","e\\f","",NULL,"NULL",g}`
+
+	binary, err := typeMap.Encode(pgtype.TextArrayOID, pgtype.BinaryFormatCode, expect, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fields := range [][]*pb.Field{
+		{
+			{Name: "v5", Oid: pgtype.TextArrayOID, Value: &pb.Field_Text{Text: text}},
+			{Name: "ptrs", Oid: pgtype.TextArrayOID, Value: &pb.Field_Text{Text: text}},
+			{Name: "v4", Oid: pgtype.TextArrayOID, Value: &pb.Field_Text{Text: text}},
+			{Name: "v4var", Oid: pgtype.VarcharArrayOID, Value: &pb.Field_Text{Text: text}},
+			{Name: "varchar", Oid: pgtype.VarcharArrayOID, Value: &pb.Field_Text{Text: text}},
+		},
+		{
+			{Name: "v5", Oid: pgtype.TextArrayOID, Value: &pb.Field_Binary{Binary: binary}},
+			{Name: "ptrs", Oid: pgtype.TextArrayOID, Value: &pb.Field_Binary{Binary: binary}},
+			{Name: "v4", Oid: pgtype.TextArrayOID, Value: &pb.Field_Binary{Binary: binary}},
+			{Name: "v4var", Oid: pgtype.VarcharArrayOID, Value: &pb.Field_Binary{Binary: binary}},
+			{Name: "varchar", Oid: pgtype.VarcharArrayOID, Value: &pb.Field_Binary{Binary: binary}},
+		},
+	} {
+		m, err := makeModel(ref, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		model := m.(*TextArrayModel)
+		if len(model.V5) != len(expect) || len(model.Ptrs) != len(expect) || len(model.V4.Elements) != len(expect) || len(model.V4Var.Elements) != len(expect) || len(model.Varchar) != len(expect) {
+			t.Fatalf("unexpected %v", model)
+		}
+		for i, e := range expect {
+			for _, v := range []struct {
+				valid bool
+				s     string
+			}{
+				{valid: model.V5[i].Valid, s: model.V5[i].String},
+				{valid: model.Ptrs[i] != nil, s: deref(model.Ptrs[i])},
+				{valid: model.V4.Elements[i].Status == pgtypeV4.Present, s: model.V4.Elements[i].String},
+				{valid: model.V4Var.Elements[i].Status == pgtypeV4.Present, s: model.V4Var.Elements[i].String},
+				{valid: model.Varchar[i].Valid, s: model.Varchar[i].String},
+			} {
+				if (e == nil) == v.valid || e != nil && *e != v.s {
+					t.Fatalf("unexpected element %d: %v %q", i, v.valid, v.s)
+				}
+			}
+		}
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+type InfinityModel struct {
+	Tz     pgtype.Timestamptz   `pg:"tz"`
+	NegTz  pgtype.Timestamptz   `pg:"neg_tz"`
+	Date   pgtype.Date          `pg:"date"`
+	TzV4   pgtypeV4.Timestamptz `pg:"tz_v4"`
+	DateV4 pgtypeV4.Date        `pg:"date_v4"`
+}
+
+func (m *InfinityModel) TableName() (schema, table string) {
+	return "", "infinity"
+}
+
+func TestMakeModel_Infinity(t *testing.T) {
+	ref, err := reflectModel(&InfinityModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inf := []byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	negInf := []byte{0x80, 0, 0, 0, 0, 0, 0, 0}
+	dateInf := []byte{0x7f, 0xff, 0xff, 0xff}
+
+	for _, fields := range [][]*pb.Field{
+		{
+			{Name: "tz", Oid: pgtype.TimestamptzOID, Value: &pb.Field_Binary{Binary: inf}},
+			{Name: "neg_tz", Oid: pgtype.TimestamptzOID, Value: &pb.Field_Binary{Binary: negInf}},
+			{Name: "date", Oid: pgtype.DateOID, Value: &pb.Field_Binary{Binary: dateInf}},
+			{Name: "tz_v4", Oid: pgtype.TimestamptzOID, Value: &pb.Field_Binary{Binary: inf}},
+			{Name: "date_v4", Oid: pgtype.DateOID, Value: &pb.Field_Binary{Binary: dateInf}},
+		},
+		{
+			{Name: "tz", Oid: pgtype.TimestamptzOID, Value: &pb.Field_Text{Text: "infinity"}},
+			{Name: "neg_tz", Oid: pgtype.TimestamptzOID, Value: &pb.Field_Text{Text: "-infinity"}},
+			{Name: "date", Oid: pgtype.DateOID, Value: &pb.Field_Text{Text: "infinity"}},
+			{Name: "tz_v4", Oid: pgtype.TimestamptzOID, Value: &pb.Field_Text{Text: "infinity"}},
+			{Name: "date_v4", Oid: pgtype.DateOID, Value: &pb.Field_Text{Text: "infinity"}},
+		},
+	} {
+		m, err := makeModel(ref, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		model := m.(*InfinityModel)
+		if !model.Tz.Valid || model.Tz.InfinityModifier != pgtype.Infinity {
+			t.Fatalf("unexpected %v", model.Tz)
+		}
+		if !model.NegTz.Valid || model.NegTz.InfinityModifier != pgtype.NegativeInfinity {
+			t.Fatalf("unexpected %v", model.NegTz)
+		}
+		if !model.Date.Valid || model.Date.InfinityModifier != pgtype.Infinity {
+			t.Fatalf("unexpected %v", model.Date)
+		}
+		if model.TzV4.Status != pgtypeV4.Present || model.TzV4.InfinityModifier != pgtypeV4.Infinity {
+			t.Fatalf("unexpected %v", model.TzV4)
+		}
+		if model.DateV4.Status != pgtypeV4.Present || model.DateV4.InfinityModifier != pgtypeV4.Infinity {
+			t.Fatalf("unexpected %v", model.DateV4)
+		}
+	}
+}
+
+type BoundedArrayModel struct {
+	V5    pgtype.Array[pgtype.Text] `pg:"v5"`
+	V4    pgtypeV4.TextArray        `pg:"v4"`
+	Int   pgtype.Array[int32]       `pg:"int"`
+	IntV4 pgtypeV4.Int4Array        `pg:"int_v4"`
+}
+
+func (m *BoundedArrayModel) TableName() (schema, table string) {
+	return "", "bounded_array"
+}
+
+func TestMakeModel_ArrayLowerBound(t *testing.T) {
+	ref, err := reflectModel(&BoundedArrayModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// '[0:2]={a,b,c}'::text[] and '[-1:0]={1,2}'::int4[] in binary
+	text := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 25, 0, 0, 0, 3, 0, 0, 0, 0}
+	for _, s := range []string{"a", "b", "c"} {
+		text = append(text, 0, 0, 0, 1, s[0])
+	}
+	int4 := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 23, 0, 0, 0, 2, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 4, 0, 0, 0, 1, 0, 0, 0, 4, 0, 0, 0, 2}
+
+	for _, fields := range [][]*pb.Field{
+		{
+			{Name: "v5", Oid: pgtype.TextArrayOID, Value: &pb.Field_Text{Text: "[0:2]={a,b,c}"}},
+			{Name: "v4", Oid: pgtype.TextArrayOID, Value: &pb.Field_Text{Text: "[0:2]={a,b,c}"}},
+			{Name: "int", Oid: pgtype.Int4ArrayOID, Value: &pb.Field_Text{Text: "[-1:0]={1,2}"}},
+			{Name: "int_v4", Oid: pgtype.Int4ArrayOID, Value: &pb.Field_Text{Text: "[-1:0]={1,2}"}},
+		},
+		{
+			{Name: "v5", Oid: pgtype.TextArrayOID, Value: &pb.Field_Binary{Binary: text}},
+			{Name: "v4", Oid: pgtype.TextArrayOID, Value: &pb.Field_Binary{Binary: text}},
+			{Name: "int", Oid: pgtype.Int4ArrayOID, Value: &pb.Field_Binary{Binary: int4}},
+			{Name: "int_v4", Oid: pgtype.Int4ArrayOID, Value: &pb.Field_Binary{Binary: int4}},
+		},
+	} {
+		m, err := makeModel(ref, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		model := m.(*BoundedArrayModel)
+		for _, v := range []struct {
+			name        string
+			dims        []pgtype.ArrayDimension
+			lower, size int32
+		}{
+			{name: "v5", dims: model.V5.Dims, lower: 0, size: 3},
+			{name: "v4", dims: v5Dims(model.V4.Dimensions), lower: 0, size: 3},
+			{name: "int", dims: model.Int.Dims, lower: -1, size: 2},
+			{name: "int_v4", dims: v5Dims(model.IntV4.Dimensions), lower: -1, size: 2},
+		} {
+			if len(v.dims) != 1 || v.dims[0].LowerBound != v.lower || v.dims[0].Length != v.size {
+				t.Fatalf("unexpected dimensions of %s: %v", v.name, v.dims)
+			}
+		}
+		if len(model.IntV4.Elements) != 2 || model.IntV4.Elements[0].Int != 1 || model.IntV4.Elements[1].Int != 2 {
+			t.Fatalf("unexpected %v", model.IntV4.Elements)
+		}
+	}
+
+	if _, err = makeModel(ref, []*pb.Field{{Name: "int_v4", Oid: pgtype.Int4ArrayOID, Value: &pb.Field_Text{Text: "[-1:1]={1,2}"}}}); err == nil {
+		t.Fatal("mismatched dimensions should fail")
+	}
+}
+
+func v5Dims(dims []pgtypeV4.ArrayDimension) (ret []pgtype.ArrayDimension) {
+	for _, d := range dims {
+		ret = append(ret, pgtype.ArrayDimension{Length: d.Length, LowerBound: d.LowerBound})
+	}
+	return ret
+}
+
+type TextDateModel struct {
+	V5 pgtype.Date   `pg:"v5"`
+	V4 pgtypeV4.Date `pg:"v4"`
+}
+
+func (m *TextDateModel) TableName() (schema, table string) {
+	return "", "text_date"
+}
+
+func TestMakeModel_TextDate(t *testing.T) {
+	ref, err := reflectModel(&TextDateModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the text output of date under the DateStyle of source.DeterministicSessionSettings
+	m, err := makeModel(ref, []*pb.Field{
+		{Name: "v5", Oid: pgtype.DateOID, Value: &pb.Field_Text{Text: "2024-01-02"}},
+		{Name: "v4", Oid: pgtype.DateOID, Value: &pb.Field_Text{Text: "2024-01-02"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if model := m.(*TextDateModel); !model.V5.Time.Equal(expect) || !model.V4.Time.Equal(expect) {
+		t.Fatalf("unexpected %v", model)
+	}
+
+	// the output of other DateStyle like "SQL, DMY" is ambiguous and not accepted
+	if _, err = makeModel(ref, []*pb.Field{{Name: "v5", Oid: pgtype.DateOID, Value: &pb.Field_Text{Text: "02/01/2024"}}}); err == nil {
+		t.Fatal("non ISO date should fail")
+	}
+}
+
+type ToastModel struct {
+	ID   pgtype.Int4 `pg:"id"`
+	Data []byte      `pg:"data"`
+	Note pgtype.Text `pg:"note"`
+}
+
+func (m *ToastModel) TableName() (schema, table string) {
+	return "", "toast"
+}
+
+func TestUnchangedColumns(t *testing.T) {
+	ref, err := reflectModel(&ToastModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the unchanged external bytea is omitted from the new tuple, and left as nil in the model
+	fields := []*pb.Field{{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1}}}}
+	m, err := makeModel(ref, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model := m.(*ToastModel); model.ID.Int32 != 1 || model.Data != nil {
+		t.Fatalf("unexpected %v", model)
+	}
+	if unchanged := unchangedColumns(ref, fields); len(unchanged) != 2 || unchanged[0] != "data" || unchanged[1] != "note" {
+		t.Fatalf("unexpected %v", unchanged)
+	}
+
+	fields = append(fields, &pb.Field{Name: "data", Oid: pgtype.ByteaOID, Value: &pb.Field_Binary{Binary: []byte{}}}, &pb.Field{Name: "note", Oid: pgtype.TextOID})
+	if unchanged := unchangedColumns(ref, fields); unchanged != nil {
+		t.Fatalf("unexpected %v", unchanged)
+	}
+
+	// the table has more columns than the model, and only the unchanged data of the model is omitted
+	fields = []*pb.Field{
+		{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1}}},
+		{Name: "note", Oid: pgtype.TextOID},
+		{Name: "a", Oid: pgtype.TextOID},
+		{Name: "b", Oid: pgtype.TextOID},
+	}
+	if unchanged := unchangedColumns(ref, fields); len(unchanged) != 1 || unchanged[0] != "data" {
+		t.Fatalf("unexpected %v", unchanged)
+	}
+}
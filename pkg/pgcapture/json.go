@@ -19,7 +19,15 @@ type fieldOption struct {
 	omitempty bool
 }
 
+type JSONOption struct {
+	TimeFormat TimeFormat
+}
+
 func MarshalJSON(m Model) ([]byte, error) {
+	return MarshalJSONWithOption(m, JSONOption{})
+}
+
+func MarshalJSONWithOption(m Model, option JSONOption) ([]byte, error) {
 	var buf *bytes.Buffer
 	if v := bufPool.Get(); v != nil {
 		buf = v.(*bytes.Buffer)
@@ -90,7 +98,14 @@ func MarshalJSON(m Model) ([]byte, error) {
 				}
 			}
 		}
-		bs, err := json.Marshal(face)
+		var bs []byte
+		var err error
+		if option.TimeFormat != TimeFormatDefault {
+			bs, err = marshalTime(face, option.TimeFormat)
+		}
+		if bs == nil && err == nil {
+			bs, err = json.Marshal(face)
+		}
 		if err != nil {
 			if strings.Contains(err.Error(), "cannot encode status undefined") {
 				continue
@@ -3,6 +3,7 @@ package pgcapture
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	pgtypeV4 "github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v5/pgtype"
@@ -85,3 +86,67 @@ type m2 struct {
 func (m *m2) TableName() (schema, table string) {
 	return "", ""
 }
+
+func TestMarshalJSONWithOption_TimeFormat(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 890000000, time.FixedZone("", 8*3600))
+	var tz Timetz
+	if err := tz.Scan("05:06:07.89+05:30"); err != nil {
+		t.Fatal(err)
+	}
+	input := &m3{
+		F1:  pgtype.Timestamp{Time: ts.UTC(), Valid: true},
+		F2:  pgtype.Timestamptz{Time: ts, Valid: true},
+		F3:  pgtype.Date{Time: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), Valid: true},
+		F4:  pgtype.Time{Microseconds: (5*3600+6*60+7)*1000000 + 890000, Valid: true},
+		F5:  tz,
+		F6:  pgtypeV4.Timestamptz{Time: ts, Status: pgtypeV4.Present},
+		F7:  pgtypeV4.Date{Status: pgtypeV4.Present, InfinityModifier: pgtypeV4.Infinity},
+		F8:  pgtype.Timestamptz{Valid: false},
+		F9:  ts,
+		F10: "05:06:07",
+	}
+
+	testCases := []struct {
+		format TimeFormat
+		expect string
+	}{
+		{
+			format: TimeFormatRFC3339,
+			expect: `{"F1":"2021-03-03T21:06:07.89","F2":"2021-03-04T05:06:07.89+08:00","F3":"2021-03-04","F4":"05:06:07.89","F5":"05:06:07.89+05:30","F6":"2021-03-04T05:06:07.89+08:00","F7":"infinity","F8":null,"F9":"2021-03-04T05:06:07.89+08:00","F10":"05:06:07"}`,
+		},
+		{
+			format: TimeFormatPostgresISO,
+			expect: `{"F1":"2021-03-03 21:06:07.89","F2":"2021-03-04 05:06:07.89+08","F3":"2021-03-04","F4":"05:06:07.89","F5":"05:06:07.89+05:30","F6":"2021-03-04 05:06:07.89+08","F7":"infinity","F8":null,"F9":"2021-03-04 05:06:07.89+08","F10":"05:06:07"}`,
+		},
+		{
+			format: TimeFormatEpochMillis,
+			expect: `{"F1":1614805567890,"F2":1614805567890,"F3":1614816000000,"F4":18367890,"F5":84967890,"F6":1614805567890,"F7":"infinity","F8":null,"F9":1614805567890,"F10":"05:06:07"}`,
+		},
+	}
+	for _, tc := range testCases {
+		bs, err := MarshalJSONWithOption(input, JSONOption{TimeFormat: tc.format})
+		if err != nil {
+			t.Fatalf("unexpected err %v", err)
+		}
+		if string(bs) != tc.expect {
+			t.Fatalf("unexpected json %v for format %v", string(bs), tc.format)
+		}
+	}
+}
+
+type m3 struct {
+	F1  pgtype.Timestamp
+	F2  pgtype.Timestamptz
+	F3  pgtype.Date
+	F4  pgtype.Time
+	F5  Timetz
+	F6  pgtypeV4.Timestamptz
+	F7  pgtypeV4.Date
+	F8  pgtype.Timestamptz
+	F9  time.Time
+	F10 string
+}
+
+func (m *m3) TableName() (schema, table string) {
+	return "", ""
+}
@@ -19,6 +19,9 @@ type Change struct {
 	Checkpoint cursor.Checkpoint
 	New        interface{}
 	Old        interface{}
+	// Unchanged are the columns of the model missing from the new tuple of an UPDATE, which are mostly the unchanged
+	// values stored in the external TOAST, and their fields in the New are left as zero instead of the actual values
+	Unchanged []string
 }
 
 type ModelHandlerFunc func(change Change) error
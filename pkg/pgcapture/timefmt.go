@@ -0,0 +1,236 @@
+package pgcapture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	pgtypeV4 "github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const TimetzOID = 1266
+
+var ErrInvalidTimetz = errors.New("invalid timetz")
+
+// TimeFormat controls how the timestamp, timestamptz, date, time and timetz fields are rendered by the MarshalJSONWithOption
+type TimeFormat int
+
+const (
+	// TimeFormatDefault keeps the format of the field's own json.Marshaler
+	TimeFormatDefault TimeFormat = iota
+	// TimeFormatRFC3339 renders like "2006-01-02T15:04:05.999999Z07:00"
+	TimeFormatRFC3339
+	// TimeFormatPostgresISO renders like the ISO DateStyle of Postgres, "2006-01-02 15:04:05.999999-07"
+	TimeFormatPostgresISO
+	// TimeFormatEpochMillis renders the number of milliseconds since the unix epoch, or since the midnight for time and timetz
+	TimeFormatEpochMillis
+)
+
+// Timetz is the time with time zone, which is not supported by the pgtype
+type Timetz struct {
+	Microseconds int64 // Number of microseconds since midnight
+	Offset       int32 // Seconds east of UTC
+	Valid        bool
+}
+
+func (t *Timetz) Scan(src any) (err error) {
+	switch src := src.(type) {
+	case nil:
+		*t = Timetz{}
+		return nil
+	case []byte:
+		if len(src) != 12 {
+			return ErrInvalidTimetz
+		}
+		// the zone is sent as seconds west of UTC
+		*t = Timetz{
+			Microseconds: int64(binary.BigEndian.Uint64(src)),
+			Offset:       -int32(binary.BigEndian.Uint32(src[8:])),
+			Valid:        true,
+		}
+		return nil
+	case string:
+		*t, err = parseTimetz(src)
+		return err
+	}
+	return fmt.Errorf("cannot scan %T into Timetz", src)
+}
+
+func (t Timetz) MarshalJSON() ([]byte, error) {
+	if !t.Valid {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.Quote(formatClock(t.Microseconds) + formatOffset(t.Offset, true))), nil
+}
+
+func parseTimetz(s string) (t Timetz, err error) {
+	i := strings.LastIndexAny(s, "+-")
+	if i < 0 {
+		return t, ErrInvalidTimetz
+	}
+	clock, err := time.Parse("15:04:05.999999", s[:i])
+	if err != nil {
+		return t, ErrInvalidTimetz
+	}
+	var offset int64
+	for n, part := range strings.Split(s[i+1:], ":") {
+		v, err := strconv.ParseInt(part, 10, 32)
+		if err != nil || n > 2 {
+			return t, ErrInvalidTimetz
+		}
+		offset += v * []int64{3600, 60, 1}[n]
+	}
+	if s[i] == '-' {
+		offset = -offset
+	}
+	return Timetz{
+		Microseconds: int64(clock.Hour()*3600+clock.Minute()*60+clock.Second())*1000000 + int64(clock.Nanosecond()/1000),
+		Offset:       int32(offset),
+		Valid:        true,
+	}, nil
+}
+
+// marshalTime returns nil without error if the field is not a time type
+func marshalTime(face any, format TimeFormat) ([]byte, error) {
+	switch v := face.(type) {
+	case *time.Time:
+		return formatTimestamp(*v, true, format), nil
+	case *pgtype.Timestamptz:
+		if !v.Valid {
+			return []byte("null"), nil
+		}
+		return formatInfinity(v.InfinityModifier, func() []byte { return formatTimestamp(v.Time, true, format) }), nil
+	case *pgtype.Timestamp:
+		if !v.Valid {
+			return []byte("null"), nil
+		}
+		return formatInfinity(v.InfinityModifier, func() []byte { return formatTimestamp(v.Time, false, format) }), nil
+	case *pgtype.Date:
+		if !v.Valid {
+			return []byte("null"), nil
+		}
+		return formatInfinity(v.InfinityModifier, func() []byte { return formatDate(v.Time, format) }), nil
+	case *pgtype.Time:
+		if !v.Valid {
+			return []byte("null"), nil
+		}
+		return formatTime(v.Microseconds, 0, false, format), nil
+	case *Timetz:
+		if !v.Valid {
+			return []byte("null"), nil
+		}
+		return formatTime(v.Microseconds, v.Offset, true, format), nil
+	case *pgtypeV4.Timestamptz:
+		if v.Status != pgtypeV4.Present {
+			return formatStatus(v.Status), nil
+		}
+		return formatInfinity(pgtype.InfinityModifier(v.InfinityModifier), func() []byte { return formatTimestamp(v.Time, true, format) }), nil
+	case *pgtypeV4.Timestamp:
+		if v.Status != pgtypeV4.Present {
+			return formatStatus(v.Status), nil
+		}
+		return formatInfinity(pgtype.InfinityModifier(v.InfinityModifier), func() []byte { return formatTimestamp(v.Time, false, format) }), nil
+	case *pgtypeV4.Date:
+		if v.Status != pgtypeV4.Present {
+			return formatStatus(v.Status), nil
+		}
+		return formatInfinity(pgtype.InfinityModifier(v.InfinityModifier), func() []byte { return formatDate(v.Time, format) }), nil
+	case *pgtypeV4.Time:
+		if v.Status != pgtypeV4.Present {
+			return formatStatus(v.Status), nil
+		}
+		return formatTime(v.Microseconds, 0, false, format), nil
+	}
+	return nil, nil
+}
+
+// formatStatus returns nil for the undefined status to let the json.Marshal report it
+func formatStatus(status pgtypeV4.Status) []byte {
+	if status == pgtypeV4.Null {
+		return []byte("null")
+	}
+	return nil
+}
+
+func formatInfinity(im pgtype.InfinityModifier, finite func() []byte) []byte {
+	if im != pgtype.Finite {
+		return []byte(strconv.Quote(im.String()))
+	}
+	return finite()
+}
+
+func formatTimestamp(t time.Time, withZone bool, format TimeFormat) []byte {
+	switch format {
+	case TimeFormatEpochMillis:
+		return strconv.AppendInt(nil, t.UnixMilli(), 10)
+	case TimeFormatPostgresISO:
+		if !withZone {
+			return []byte(strconv.Quote(t.Format("2006-01-02 15:04:05.999999")))
+		}
+		_, offset := t.Zone()
+		return []byte(strconv.Quote(t.Format("2006-01-02 15:04:05.999999") + formatOffset(int32(offset), false)))
+	default:
+		if !withZone {
+			return []byte(strconv.Quote(t.Format("2006-01-02T15:04:05.999999")))
+		}
+		return []byte(strconv.Quote(t.Format("2006-01-02T15:04:05.999999Z07:00")))
+	}
+}
+
+func formatDate(t time.Time, format TimeFormat) []byte {
+	if format == TimeFormatEpochMillis {
+		return strconv.AppendInt(nil, time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).UnixMilli(), 10)
+	}
+	return []byte(strconv.Quote(t.Format("2006-01-02")))
+}
+
+func formatTime(us int64, offset int32, withZone bool, format TimeFormat) []byte {
+	switch format {
+	case TimeFormatEpochMillis:
+		const day = int64(24 * time.Hour / time.Millisecond)
+		ms := us/1000 - int64(offset)*1000
+		return strconv.AppendInt(nil, (ms%day+day)%day, 10)
+	case TimeFormatPostgresISO:
+		if !withZone {
+			return []byte(strconv.Quote(formatClock(us)))
+		}
+		return []byte(strconv.Quote(formatClock(us) + formatOffset(offset, false)))
+	default:
+		if !withZone {
+			return []byte(strconv.Quote(formatClock(us)))
+		}
+		return []byte(strconv.Quote(formatClock(us) + formatOffset(offset, true)))
+	}
+}
+
+// formatClock formats the microseconds since midnight, which allows the 24:00:00 like Postgres
+func formatClock(us int64) string {
+	s := fmt.Sprintf("%02d:%02d:%02d", us/3600000000, us/60000000%60, us/1000000%60)
+	if frac := us % 1000000; frac != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+	}
+	return s
+}
+
+// formatOffset formats the offset like "+08:00" and "Z" for the RFC3339, or like "+08" and "+05:30" for the Postgres ISO
+func formatOffset(offset int32, rfc3339 bool) string {
+	if rfc3339 && offset == 0 {
+		return "Z"
+	}
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	s := fmt.Sprintf("%s%02d", sign, offset/3600)
+	if rfc3339 || offset%3600 != 0 {
+		s += fmt.Sprintf(":%02d", offset/60%60)
+	}
+	if offset%60 != 0 {
+		s += fmt.Sprintf(":%02d", offset%60)
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package pgcapture
+
+import (
+	"database/sql/driver"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// RegisterType registers the codec of an extension type, like the pgtype.HstoreCodec for hstore or the pgtype.TextCodec for citext,
+// with its oid in the source database, so that the fields of the type can be decoded into the models.
+// The array type is also registered with the arrayOID if it is not 0, and its elements are decoded by the same codec.
+// The OIDs of extension types are different in each database, and can be found by
+// `SELECT oid, typarray FROM pg_type WHERE typname = 'hstore'`.
+// It should be called before consuming, because the registry is not safe for concurrent use.
+func RegisterType(name string, oid, arrayOID uint32, codec pgtype.Codec) {
+	t := &pgtype.Type{Name: name, OID: oid, Codec: codec}
+	typeMap.RegisterType(t)
+	if arrayOID != 0 {
+		typeMap.RegisterType(&pgtype.Type{Name: "_" + name, OID: arrayOID, Codec: &pgtype.ArrayCodec{ElementType: t}})
+	}
+}
+
+const (
+	Int2VectorOID = 22
+	OIDVectorOID  = 30
+)
+
+func init() {
+	int2, _ := typeMap.TypeForOID(pgtype.Int2OID)
+	oid, _ := typeMap.TypeForOID(pgtype.OIDOID)
+	typeMap.RegisterType(&pgtype.Type{Name: "int2vector", OID: Int2VectorOID, Codec: vectorCodec{&pgtype.ArrayCodec{ElementType: int2}}})
+	typeMap.RegisterType(&pgtype.Type{Name: "oidvector", OID: OIDVectorOID, Codec: vectorCodec{&pgtype.ArrayCodec{ElementType: oid}}})
+}
+
+// vectorCodec decodes the int2vector and the oidvector into slices like []int16 and []uint32.
+// Their binary format is the same as the arrays, but the text format is space-separated
+// and is converted into the array literal before decoding.
+type vectorCodec struct {
+	*pgtype.ArrayCodec
+}
+
+func (c vectorCodec) PlanScan(m *pgtype.Map, oid uint32, format int16, target any) pgtype.ScanPlan {
+	plan := c.ArrayCodec.PlanScan(m, oid, format, target)
+	if plan == nil || format != pgtype.TextFormatCode {
+		return plan
+	}
+	return vectorTextScanPlan{next: plan}
+}
+
+func (c vectorCodec) DecodeDatabaseSQLValue(m *pgtype.Map, oid uint32, format int16, src []byte) (driver.Value, error) {
+	if format == pgtype.TextFormatCode {
+		src = vectorToArrayText(src)
+	}
+	return c.ArrayCodec.DecodeDatabaseSQLValue(m, oid, format, src)
+}
+
+func (c vectorCodec) DecodeValue(m *pgtype.Map, oid uint32, format int16, src []byte) (any, error) {
+	if format == pgtype.TextFormatCode {
+		src = vectorToArrayText(src)
+	}
+	return c.ArrayCodec.DecodeValue(m, oid, format, src)
+}
+
+type vectorTextScanPlan struct {
+	next pgtype.ScanPlan
+}
+
+func (p vectorTextScanPlan) Scan(src []byte, target any) error {
+	return p.next.Scan(vectorToArrayText(src), target)
+}
+
+// vectorToArrayText converts the "1 2 3" into the "{1,2,3}"
+func vectorToArrayText(src []byte) []byte {
+	if src == nil {
+		return nil
+	}
+	return []byte("{" + strings.Join(strings.Fields(string(src)), ",") + "}")
+}
@@ -0,0 +1,164 @@
+package pgcapture
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+const (
+	testHstoreOID      = 90001
+	testHstoreArrayOID = 90002
+	testCitextOID      = 90003
+	testCitextArrayOID = 90004
+)
+
+type ExtensionArrayModel struct {
+	Hstores    []pgtype.Hstore             `pg:"hstores"`
+	HstoreArr  pgtype.Array[pgtype.Hstore] `pg:"hstore_arr"`
+	Citexts    []string                    `pg:"citexts"`
+	NullHstore []pgtype.Hstore             `pg:"null_hstore"`
+}
+
+func (m *ExtensionArrayModel) TableName() (schema, table string) {
+	return "", "extension_array"
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	return binary.BigEndian.AppendUint32(b, uint32(v))
+}
+
+func binaryHstore(pairs [][2]*string) []byte {
+	b := appendInt32(nil, int32(len(pairs)))
+	for _, p := range pairs {
+		b = appendInt32(b, int32(len(*p[0])))
+		b = append(b, *p[0]...)
+		if p[1] == nil {
+			b = appendInt32(b, -1)
+		} else {
+			b = appendInt32(b, int32(len(*p[1])))
+			b = append(b, *p[1]...)
+		}
+	}
+	return b
+}
+
+func TestRegisterType_ExtensionArrays(t *testing.T) {
+	RegisterType("hstore", testHstoreOID, testHstoreArrayOID, pgtype.HstoreCodec{})
+	RegisterType("citext", testCitextOID, testCitextArrayOID, pgtype.TextCodec{})
+
+	ref, err := reflectModel(&ExtensionArrayModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elements := [][]byte{
+		binaryHstore([][2]*string{{ptr("a"), ptr("1")}, {ptr("b"), nil}}),
+		binaryHstore([][2]*string{{ptr("c"), ptr("2")}}),
+	}
+	arr := appendInt32(nil, 1)
+	arr = appendInt32(arr, 0)
+	arr = appendInt32(arr, testHstoreOID)
+	arr = appendInt32(arr, int32(len(elements)))
+	arr = appendInt32(arr, 1)
+	for _, e := range elements {
+		arr = appendInt32(arr, int32(len(e)))
+		arr = append(arr, e...)
+	}
+	text := `{"\"a\"=>\"1\", \"b\"=>NULL","\"c\"=>\"2\""}`
+
+	for _, hstores := range []*pb.Field{
+		{Oid: testHstoreArrayOID, Value: &pb.Field_Binary{Binary: arr}},
+		{Oid: testHstoreArrayOID, Value: &pb.Field_Text{Text: text}},
+	} {
+		m, err := makeModel(ref, []*pb.Field{
+			{Name: "hstores", Oid: hstores.Oid, Value: hstores.Value},
+			{Name: "hstore_arr", Oid: hstores.Oid, Value: hstores.Value},
+			{Name: "citexts", Oid: testCitextArrayOID, Value: &pb.Field_Text{Text: `{Abc,"D,e"}`}},
+			{Name: "null_hstore", Oid: testHstoreArrayOID},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		model := m.(*ExtensionArrayModel)
+		for _, got := range [][]pgtype.Hstore{model.Hstores, model.HstoreArr.Elements} {
+			if len(got) != 2 || len(got[0]) != 2 || len(got[1]) != 1 ||
+				deref(got[0]["a"]) != "1" || got[0]["b"] != nil || deref(got[1]["c"]) != "2" {
+				t.Fatalf("unexpected %v", got)
+			}
+			if _, ok := got[0]["b"]; !ok {
+				t.Fatalf("unexpected %v", got)
+			}
+		}
+		if len(model.Citexts) != 2 || model.Citexts[0] != "Abc" || model.Citexts[1] != "D,e" {
+			t.Fatalf("unexpected %v", model.Citexts)
+		}
+		if model.NullHstore != nil {
+			t.Fatalf("unexpected %v", model.NullHstore)
+		}
+	}
+}
+
+type VectorModel struct {
+	Keys    []int16  `pg:"keys"`
+	Classes []uint32 `pg:"classes"`
+	Empty   []uint32 `pg:"empty"`
+}
+
+func (m *VectorModel) TableName() (schema, table string) {
+	return "", "vector"
+}
+
+// binaryVector encodes the vector like the array_send does, which starts from the lower bound 0
+func binaryVector(elemOID uint32, size int, elements ...uint32) []byte {
+	arr := appendInt32(nil, 1)
+	arr = appendInt32(arr, 0)
+	arr = appendInt32(arr, int32(elemOID))
+	arr = appendInt32(arr, int32(len(elements)))
+	arr = appendInt32(arr, 0)
+	for _, e := range elements {
+		arr = appendInt32(arr, int32(size))
+		if size == 2 {
+			arr = binary.BigEndian.AppendUint16(arr, uint16(e))
+		} else {
+			arr = binary.BigEndian.AppendUint32(arr, e)
+		}
+	}
+	return arr
+}
+
+func TestMakeModel_Vectors(t *testing.T) {
+	ref, err := reflectModel(&VectorModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fields := range [][]*pb.Field{
+		{
+			{Name: "keys", Oid: Int2VectorOID, Value: &pb.Field_Binary{Binary: binaryVector(pgtype.Int2OID, 2, 1, 3)}},
+			{Name: "classes", Oid: OIDVectorOID, Value: &pb.Field_Binary{Binary: binaryVector(pgtype.OIDOID, 4, 1978, 3126, 4294967295)}},
+			{Name: "empty", Oid: OIDVectorOID, Value: &pb.Field_Binary{Binary: appendInt32(appendInt32(appendInt32(nil, 0), 0), pgtype.OIDOID)}},
+		},
+		{
+			{Name: "keys", Oid: Int2VectorOID, Value: &pb.Field_Text{Text: "1 3"}},
+			{Name: "classes", Oid: OIDVectorOID, Value: &pb.Field_Text{Text: "1978 3126 4294967295"}},
+			{Name: "empty", Oid: OIDVectorOID, Value: &pb.Field_Text{Text: ""}},
+		},
+	} {
+		m, err := makeModel(ref, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		model := m.(*VectorModel)
+		if len(model.Keys) != 2 || model.Keys[0] != 1 || model.Keys[1] != 3 {
+			t.Fatalf("unexpected %v", model.Keys)
+		}
+		if len(model.Classes) != 3 || model.Classes[0] != 1978 || model.Classes[1] != 3126 || model.Classes[2] != 4294967295 {
+			t.Fatalf("unexpected %v", model.Classes)
+		}
+		if model.Empty == nil || len(model.Empty) != 0 {
+			t.Fatalf("unexpected %v", model.Empty)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package sink
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+var ErrCSVIncompatible = errors.New("only inserts of the same relation can be encoded into csv")
+
+// ErrCSVUnknownType means a binary value is of a type unknown to the pgtype, like the enums and the types of the extensions,
+// which can not be converted into the text representation
+var ErrCSVUnknownType = errors.New("unknown type oid of binary value")
+
+// CSVNull is the unquoted marker of NULL in the csv, which should be passed as the NULL option of the COPY
+const CSVNull = `\N`
+
+// CSVEncoder renders the inserts of the same relation into csv rows in the order of the Columns,
+// which can be loaded by the query of the CopyCSVQuery.
+// Binary values are converted into their text representations, so the encoder is not safe for concurrent use.
+type CSVEncoder struct {
+	Columns []string
+
+	typeMap *pgtype.Map
+}
+
+func NewCSVEncoder(columns []string) *CSVEncoder {
+	return &CSVEncoder{Columns: columns, typeMap: pgtype.NewMap()}
+}
+
+// CopyCSVQuery returns the COPY FROM STDIN query accepting rows of the CSVEncoder
+func CopyCSVQuery(schema, table string, columns []string) string {
+	return fmt.Sprintf(`COPY %s (%s) FROM STDIN WITH (FORMAT csv, NULL '%s')`, pgx.Identifier{schema, table}.Sanitize(), quoteColumns(columns), CSVNull)
+}
+
+// CopyStageTable is the temporary table the csv is copied into, whose rows are then inserted into the target table
+// with the ON CONFLICT DO NOTHING like the INSERT, since the COPY has no such clause
+const CopyStageTable = "pgcapture_copy_stage"
+
+// CreateCopyStageQuery creates the CopyStageTable of the columns of the table without any constraint,
+// which is dropped after inserted, or at the end of the transaction
+func CreateCopyStageQuery(schema, table string, columns []string) string {
+	return fmt.Sprintf(`CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA`,
+		pgx.Identifier{"pg_temp", CopyStageTable}.Sanitize(), quoteColumns(columns), pgx.Identifier{schema, table}.Sanitize())
+}
+
+// InsertCopyStageQuery moves the rows of the CopyStageTable into the table, skipping the ones conflicting by the keys,
+// and drops the CopyStageTable for the next COPY of other columns
+func InsertCopyStageQuery(schema, table string, columns, keys []string, pgVersion int64) string {
+	var query strings.Builder
+	query.WriteString(fmt.Sprintf(`INSERT INTO %s (%s)`, pgx.Identifier{schema, table}.Sanitize(), quoteColumns(columns)))
+	if pgVersion >= 100000 {
+		query.WriteString(" OVERRIDING SYSTEM VALUE")
+	}
+	query.WriteString(fmt.Sprintf(` SELECT %s FROM %s`, quoteColumns(columns), pgx.Identifier{"pg_temp", CopyStageTable}.Sanitize()))
+	if len(keys) != 0 {
+		query.WriteString(fmt.Sprintf(` ON CONFLICT (%s) DO NOTHING`, quoteColumns(keys)))
+	}
+	query.WriteString(fmt.Sprintf(`;DROP TABLE %s`, pgx.Identifier{"pg_temp", CopyStageTable}.Sanitize()))
+	return query.String()
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pgx.Identifier{c}.Sanitize()
+	}
+	return strings.Join(quoted, ",")
+}
+
+func (e *CSVEncoder) Encode(w io.Writer, changes []*pb.Change) error {
+	buf := bufio.NewWriter(w)
+	values := make(map[string]*pb.Field, len(e.Columns))
+	for _, c := range changes {
+		if c.Op != pb.Change_INSERT || c.Schema != changes[0].Schema || c.Table != changes[0].Table {
+			return ErrCSVIncompatible
+		}
+		for _, f := range c.New {
+			values[f.Name] = f
+		}
+		for i, name := range e.Columns {
+			f, ok := values[name]
+			if !ok {
+				return fmt.Errorf("column %s is missing from %s.%s", name, c.Schema, c.Table)
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := e.writeValue(buf, f); err != nil {
+				return fmt.Errorf("column %s of %s.%s: %w", name, c.Schema, c.Table, err)
+			}
+		}
+		buf.WriteByte('\n')
+		for k := range values {
+			delete(values, k)
+		}
+	}
+	return buf.Flush()
+}
+
+func (e *CSVEncoder) writeValue(buf *bufio.Writer, f *pb.Field) error {
+	var text string
+	switch v := f.Value.(type) {
+	case nil:
+		buf.WriteString(CSVNull)
+		return nil
+	case *pb.Field_Text:
+		text = v.Text
+	case *pb.Field_Binary:
+		dt, ok := e.typeMap.TypeForOID(f.Oid)
+		if !ok {
+			return fmt.Errorf("%w %d", ErrCSVUnknownType, f.Oid)
+		}
+		value, err := dt.Codec.DecodeValue(e.typeMap, f.Oid, pgtype.BinaryFormatCode, v.Binary)
+		if err != nil {
+			return err
+		}
+		bs, err := e.typeMap.Encode(f.Oid, pgtype.TextFormatCode, value, nil)
+		if err != nil {
+			return err
+		}
+		text = string(bs)
+	}
+	// always quote the values, so that they are not confused with the unquoted NULL marker
+	buf.WriteByte('"')
+	buf.WriteString(strings.ReplaceAll(text, `"`, `""`))
+	buf.WriteByte('"')
+	return nil
+}
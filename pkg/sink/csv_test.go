@@ -0,0 +1,127 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/internal/test"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+var csvColumns = []string{"id", "txt", "bin"}
+
+func csvChanges(t *testing.T) []*pb.Change {
+	m := pgtype.NewMap()
+	encode := func(oid uint32, v any) []byte {
+		bs, err := m.Encode(oid, pgtype.BinaryFormatCode, v, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bs
+	}
+	return []*pb.Change{
+		{Op: pb.Change_INSERT, Schema: "public", Table: "csv", New: []*pb.Field{
+			{Name: "txt", Oid: pgtype.TextOID, Value: &pb.Field_Text{Text: "a,\"b\"\nc"}},
+			{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: encode(pgtype.Int4OID, int32(1))}},
+			{Name: "bin", Oid: pgtype.ByteaOID, Value: &pb.Field_Binary{Binary: encode(pgtype.ByteaOID, []byte{0xde, 0xad})}},
+		}},
+		{Op: pb.Change_INSERT, Schema: "public", Table: "csv", New: []*pb.Field{
+			{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: encode(pgtype.Int4OID, int32(2))}},
+			{Name: "txt", Oid: pgtype.TextOID, Value: &pb.Field_Text{Text: `\N`}},
+			{Name: "bin", Oid: pgtype.ByteaOID},
+		}},
+	}
+}
+
+func TestCSVEncoder(t *testing.T) {
+	changes := csvChanges(t)
+	buf := bytes.NewBuffer(nil)
+	if err := NewCSVEncoder(csvColumns).Encode(buf, changes); err != nil {
+		t.Fatal(err)
+	}
+	expect := "\"1\",\"a,\"\"b\"\"\nc\",\"\\xdead\"\n\"2\",\"\\N\",\\N\n"
+	if buf.String() != expect {
+		t.Fatalf("unexpected %q", buf.String())
+	}
+
+	if q := CopyCSVQuery("public", "csv", csvColumns); q != `COPY "public"."csv" ("id","txt","bin") FROM STDIN WITH (FORMAT csv, NULL '\N')` {
+		t.Fatalf("unexpected %v", q)
+	}
+	if q := CreateCopyStageQuery("public", "csv", csvColumns); q != `CREATE TEMP TABLE "pg_temp"."pgcapture_copy_stage" ON COMMIT DROP AS SELECT "id","txt","bin" FROM "public"."csv" WITH NO DATA` {
+		t.Fatalf("unexpected %v", q)
+	}
+	if q := InsertCopyStageQuery("public", "csv", csvColumns, []string{"id"}, 100000); q != `INSERT INTO "public"."csv" ("id","txt","bin") OVERRIDING SYSTEM VALUE SELECT "id","txt","bin" FROM "pg_temp"."pgcapture_copy_stage" ON CONFLICT ("id") DO NOTHING;DROP TABLE "pg_temp"."pgcapture_copy_stage"` {
+		t.Fatalf("unexpected %v", q)
+	}
+
+	for _, c := range []*pb.Change{
+		{Op: pb.Change_UPDATE, Schema: "public", Table: "csv"},
+		{Op: pb.Change_INSERT, Schema: "public", Table: "other"},
+	} {
+		if err := NewCSVEncoder(csvColumns).Encode(buf, []*pb.Change{changes[0], c}); !errors.Is(err, ErrCSVIncompatible) {
+			t.Fatalf("unexpected %v", err)
+		}
+	}
+	if err := NewCSVEncoder(append(csvColumns, "missing")).Encode(buf, changes); err == nil {
+		t.Fatal("missing column should fail")
+	}
+
+	// the binary enum value is of an oid not known by the pgtype
+	enum := &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "csv", New: []*pb.Field{
+		{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 3}}},
+		{Name: "txt", Oid: 16385, Value: &pb.Field_Binary{Binary: []byte("happy")}},
+		{Name: "bin", Oid: pgtype.ByteaOID},
+	}}
+	if err := NewCSVEncoder(csvColumns).Encode(buf, []*pb.Change{enum}); !errors.Is(err, ErrCSVUnknownType) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func TestCSVEncoder_Copy(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err = conn.Exec(ctx, "DROP TABLE IF EXISTS csv; CREATE TABLE csv (id int primary key, txt text, bin bytea)"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err = NewCSVEncoder(csvColumns).Encode(buf, csvChanges(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.PgConn().CopyFrom(ctx, buf, CopyCSVQuery("public", "csv", csvColumns)); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := conn.Query(ctx, "SELECT id, txt, bin FROM csv ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got [][]any
+	for rows.Next() {
+		var id int32
+		var txt pgtype.Text
+		var bin []byte
+		if err = rows.Scan(&id, &txt, &bin); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, []any{id, txt, bin})
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 ||
+		got[0][0] != int32(1) || got[0][1] != (pgtype.Text{String: "a,\"b\"\nc", Valid: true}) || !bytes.Equal(got[0][2].([]byte), []byte{0xde, 0xad}) ||
+		got[1][0] != int32(2) || got[1][1] != (pgtype.Text{String: `\N`, Valid: true}) || got[1][2].([]byte) != nil {
+		t.Fatalf("unexpected %v", got)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/replicase/pgcapture/internal/fault"
 	"github.com/replicase/pgcapture/pkg/cursor"
 	"github.com/replicase/pgcapture/pkg/source"
 )
@@ -25,6 +26,15 @@ type BaseSink struct {
 	CleanFn   CleanFn
 	cleanOnce sync.Once
 
+	// IdleFlushInterval flushes the changes buffered by the sink once no change is received within it,
+	// so that they are not held until the next change in the low traffic periods. It is disabled if zero.
+	IdleFlushInterval time.Duration
+	// flushFn flushes the buffered changes on idle, if the sink buffers any
+	flushFn func(committed chan cursor.Checkpoint) error
+
+	// FaultInjector fails the applying of the changes by its rules, to test the recovery of the sink. It never fails if nil.
+	FaultInjector *fault.Injector
+
 	committed chan cursor.Checkpoint
 	state     int64
 	err       atomic.Value
@@ -46,18 +56,36 @@ func (b *BaseSink) apply(changes chan source.Change, applyFn ApplyFn) (committed
 	atomic.StoreInt64(&b.state, 2)
 
 	go func() {
-		ticker := time.NewTicker(time.Second)
+		tick := time.Second
+		if b.IdleFlushInterval > 0 && b.IdleFlushInterval < tick {
+			tick = b.IdleFlushInterval
+		}
+		ticker := time.NewTicker(tick)
+		received, idle := time.Now(), true
 		for atomic.LoadInt64(&b.state) == 2 {
 			select {
 			case change, more := <-changes:
 				if !more {
 					goto cleanup
 				}
-				if err := applyFn(len(changes), change, b.committed); err != nil {
+				received, idle = time.Now(), false
+				err := b.FaultInjector.Check(fault.Apply, change.Checkpoint.LSN)
+				if err == nil {
+					err = applyFn(len(changes), change, b.committed)
+				}
+				if err != nil {
 					b.err.Store(fmt.Errorf("%w", err))
 					goto cleanup
 				}
 			case <-ticker.C:
+				if b.IdleFlushInterval <= 0 || b.flushFn == nil || idle || time.Since(received) < b.IdleFlushInterval {
+					continue
+				}
+				idle = true
+				if err := b.flushFn(b.committed); err != nil {
+					b.err.Store(fmt.Errorf("%w", err))
+					goto cleanup
+				}
 			}
 		}
 	cleanup:
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/replicase/pgcapture/internal/fault"
 	"github.com/replicase/pgcapture/pkg/cursor"
 	"github.com/replicase/pgcapture/pkg/pb"
 	"github.com/replicase/pgcapture/pkg/source"
@@ -133,6 +134,73 @@ func TestBaseSink_Error(t *testing.T) {
 	close(changes)
 }
 
+func TestBaseSink_ApplyFault(t *testing.T) {
+	sink := sink{}
+	sink.Setup()
+	sink.FaultInjector = fault.NewInjector().FailAtLSN(fault.Apply, 2, nil)
+	changes := make(chan source.Change, 3)
+	committed := sink.Apply(changes)
+
+	for lsn := uint64(1); lsn <= 3; lsn++ {
+		changes <- source.Change{Checkpoint: cursor.Checkpoint{LSN: lsn}}
+	}
+
+	if cp := <-committed; cp.LSN != 1 {
+		t.Fatalf("unexpected %v", cp)
+	}
+	if _, more := <-committed; more {
+		t.Fatal("committed channel should be closed")
+	}
+	if err := sink.Stop(); !errors.Is(err, fault.ErrInjected) {
+		t.Fatalf("unexpected %v", err)
+	}
+	close(changes)
+}
+
+func TestBaseSink_IdleFlush(t *testing.T) {
+	sink := sink{}
+	sink.Setup()
+	sink.IdleFlushInterval = 50 * time.Millisecond
+	// the sink holds the checkpoints until the batch of 3 is full, or it goes idle
+	var pending []cursor.Checkpoint
+	sink.flushFn = func(committed chan cursor.Checkpoint) error {
+		for _, cp := range pending {
+			committed <- cp
+		}
+		pending = pending[:0]
+		return nil
+	}
+	changes := make(chan source.Change)
+	committed := sink.BaseSink.apply(changes, func(_ int, change source.Change, committed chan cursor.Checkpoint) error {
+		if pending = append(pending, change.Checkpoint); len(pending) == 3 {
+			return sink.flushFn(committed)
+		}
+		return nil
+	})
+
+	start := time.Now()
+	for lsn := uint64(1); lsn <= 2; lsn++ {
+		changes <- source.Change{Checkpoint: cursor.Checkpoint{LSN: lsn}}
+	}
+	for lsn := uint64(1); lsn <= 2; lsn++ {
+		if cp := <-committed; cp.LSN != lsn {
+			t.Fatalf("unexpected %v", cp)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("flushed before idle %v", elapsed)
+	}
+
+	// the flush is not repeated until the next change
+	select {
+	case cp := <-committed:
+		t.Fatalf("unexpected %v", cp)
+	case <-time.After(150 * time.Millisecond):
+	}
+	sink.Stop()
+	close(changes)
+}
+
 func TestBaseSink_SecondApply(t *testing.T) {
 	sink := sink{}
 	sink.Setup()
@@ -2,12 +2,15 @@ package sink
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"regexp"
@@ -39,6 +42,26 @@ type PGXSink struct {
 	LogReader   io.Reader
 	BatchTXSize int
 
+	// CopyThreshold applies a batch of at least CopyThreshold inserts into the same relation by the COPY instead of
+	// the multi-row INSERT, for the snapshots and backfills dominated by inserts, and it is disabled if zero.
+	// The batches of inserts hold at most CopyBatchSize rows then, defaults to the DefaultCopyBatchSize.
+	// The applied transactions are wrapped in an explicit transaction, so that the COPY is still atomic with the
+	// checkpoint and applied in order with the other changes. The rows are copied into the temporary CopyStageTable,
+	// and then inserted with the ON CONFLICT DO NOTHING like the INSERT, which skips the existing ones.
+	// The batches having the binary values of the types unknown to the CSVEncoder, like the enums, are still inserted.
+	// The COPYs are not parallelized over multiple connections, which can not share the transaction.
+	CopyThreshold int
+	CopyBatchSize int
+
+	// SkipMissingRoleGrants skips the GRANT and REVOKE statements of the DDLs to the roles not existing on the target,
+	// instead of failing, since the roles are not captured. The roles are loaded at the Setup.
+	SkipMissingRoleGrants bool
+
+	// ApplyJSONBPatches applies the fields marked by the decode.JSONBPatchOID of the updates by the nested jsonb_set of
+	// their paths, instead of replacing the whole documents. The changes having the marked fields fail with the
+	// ErrJSONBPatchNotApplied if disabled, instead of overwriting the documents with the patches.
+	ApplyJSONBPatches bool
+
 	conn           *pgx.Conn
 	raw            *pgconn.PgConn
 	pipeline       *pgconn.Pipeline
@@ -54,6 +77,21 @@ type PGXSink struct {
 	inTX           bool
 	pendingChanges []pendingChange
 	pendingCommits []pendingCommit
+	sequences      map[sequenceKey]int64
+	explicitTx     bool
+	csv            *CSVEncoder
+	roles          map[string]bool
+}
+
+const (
+	insertBatchSize      = 2500
+	DefaultCopyBatchSize = 10000
+)
+
+type sequenceKey struct {
+	Schema string
+	Table  string
+	Column string
 }
 
 type insertBatch struct {
@@ -81,11 +119,15 @@ func (b *insertBatch) flush() [][]*pb.Field {
 }
 
 type pendingChange struct {
-	sql           string
-	args          [][]byte
-	paramOIDs     []uint32
-	paramFormats  []int16
-	resultFormats []int16
+	sql string
+	// copy is the csv data for the COPY query of the sql if not nil, which copies into the stage created by the stage
+	// query, and the unstage query inserts the staged rows into the target
+	copy           []byte
+	stage, unstage string
+	args           [][]byte
+	paramOIDs      []uint32
+	paramFormats   []int16
+	resultFormats  []int16
 }
 
 type pendingCommit struct {
@@ -105,7 +147,16 @@ func (p *PGXSink) Setup() (cp cursor.Checkpoint, err error) {
 		return cp, err
 	}
 	p.raw = p.conn.PgConn()
-	p.inserts.records = make([][]*pb.Field, 2500)
+	p.inserts.records = make([][]*pb.Field, insertBatchSize)
+	if p.CopyThreshold > 0 {
+		size := p.CopyBatchSize
+		if size <= 0 {
+			size = DefaultCopyBatchSize
+		}
+		p.inserts.records = make([][]*pb.Field, size)
+		p.csv = NewCSVEncoder(nil)
+	}
+	p.sequences = make(map[sequenceKey]int64)
 	p.pgSrcID = pgText(p.SourceID)
 	p.replLag = -1
 
@@ -135,6 +186,12 @@ func (p *PGXSink) Setup() (cp cursor.Checkpoint, err error) {
 		return cp, err
 	}
 
+	if p.SkipMissingRoleGrants {
+		if p.roles, err = p.loadRoles(ctx); err != nil {
+			return cp, err
+		}
+	}
+
 	p.BaseSink.CleanFn = func() {
 		p.conn.Close(context.Background())
 	}
@@ -206,6 +263,9 @@ func (p *PGXSink) findCheckpoint(ctx context.Context) (cp cursor.Checkpoint, err
 
 func (p *PGXSink) Apply(changes chan source.Change) chan cursor.Checkpoint {
 	var first bool
+	p.BaseSink.flushFn = func(_ chan cursor.Checkpoint) error {
+		return p.flushCommits()
+	}
 	return p.BaseSink.apply(changes, func(sourceRemaining int, change source.Change, committed chan cursor.Checkpoint) (err error) {
 		if !first {
 			p.log.WithFields(logrus.Fields{
@@ -238,6 +298,9 @@ func (p *PGXSink) Apply(changes chan source.Change) chan cursor.Checkpoint {
 			}
 			if decode.IsDDL(msg.Change) {
 				err = p.handleDDL(msg.Change)
+			} else if decode.IsDigest(msg.Change) {
+				// the digest is for the drift detection, which is not a row of any table to be applied
+				break
 			} else {
 				if len(p.skip) != 0 && p.skip[fmt.Sprintf("%s.%s", msg.Change.Schema, msg.Change.Table)] {
 					p.log.WithFields(logrus.Fields{
@@ -281,7 +344,20 @@ func (p *PGXSink) handleBegin(b *pb.Begin) {
 	p.startPipeline()
 }
 
+// ErrJSONBPatchNotApplied means the change has the jsonb patches, which are only applied to the updates
+// with the ApplyJSONBPatches
+var ErrJSONBPatchNotApplied = errors.New("the jsonb patches are not applied")
+
 func (p *PGXSink) handleChange(m *pb.Change) (err error) {
+	if len(m.Old) == 0 && len(m.New) == 0 {
+		// the change of a relation without any column can not be applied by keys, skip it
+		return nil
+	}
+	for _, f := range m.New {
+		if f.Oid == decode.JSONBPatchOID && (m.Op != pb.Change_UPDATE || !p.ApplyJSONBPatches) {
+			return fmt.Errorf("%w: %s of %s %s.%s", ErrJSONBPatchNotApplied, f.Name, m.Op, m.Schema, m.Table)
+		}
+	}
 	switch m.Op {
 	case pb.Change_INSERT:
 		return p.handleInsert(m)
@@ -333,6 +409,10 @@ parse:
 		if stmt.Stmt.GetRefreshMatViewStmt() != nil {
 			continue
 		}
+		if role := p.missingRole(stmt.Stmt); role != "" {
+			p.log.WithField("Role", role).Warn("privilege statement skipped due to the missing role on the target")
+			continue
+		}
 		stmts = append(stmts, stmt)
 	}
 
@@ -377,6 +457,42 @@ parse:
 	return sb.String(), relations, len(stmts), nil
 }
 
+func (p *PGXSink) loadRoles(ctx context.Context) (map[string]bool, error) {
+	rows, err := p.conn.Query(ctx, sql.QueryRoles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// the role keywords of the grantees always exist
+	roles := map[string]bool{"public": true, "current_user": true, "session_user": true}
+	var role string
+	for rows.Next() {
+		if err = rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles[role] = true
+	}
+	return roles, rows.Err()
+}
+
+// missingRole returns the first grantee of the GRANT or REVOKE not existing on the target if the SkipMissingRoleGrants
+func (p *PGXSink) missingRole(stmt *pg_query.Node) string {
+	if p.roles == nil {
+		return ""
+	}
+	event, ok := decode.StmtSchemaEvent(stmt)
+	if !ok || event.Kind == decode.SchemaEventComment {
+		return ""
+	}
+	for _, role := range event.Roles {
+		if !p.roles[role] {
+			return role
+		}
+	}
+	return ""
+}
+
 func (p *PGXSink) performDDL(ddl string) (err error) {
 	p.pendingChanges = p.pendingChanges[:0]
 	if err = p.endPipeline(); err != nil {
@@ -399,6 +515,22 @@ func (p *PGXSink) flushInsert() (err error) {
 	}
 
 	info, _ := p.schema.GetColumnInfo(p.inserts.Schema, p.inserts.Table)
+	if p.CopyThreshold > 0 && len(batch) >= p.CopyThreshold {
+		// the batch having the binary values unknown to the csv is inserted with their oids instead
+		if err = p.copyInsert(info, batch); !errors.Is(err, ErrCSVUnknownType) {
+			return err
+		}
+		p.log.WithFields(logrus.Fields{"Schema": p.inserts.Schema, "Table": p.inserts.Table}).WithError(err).Debug("batch inserted instead of the copy")
+	}
+	for len(batch) > insertBatchSize {
+		p.insertRecords(info, batch[:insertBatchSize])
+		batch = batch[insertBatchSize:]
+	}
+	p.insertRecords(info, batch)
+	return nil
+}
+
+func (p *PGXSink) insertRecords(info *decode.ColumnInfo, batch [][]*pb.Field) {
 	cols, filtered := info.Filter(batch[0], func(i decode.ColumnInfo, field string) bool {
 		return !i.IsGenerated(field)
 	})
@@ -432,6 +564,9 @@ func (p *PGXSink) flushInsert() (err error) {
 				vals[c] = []byte(field.GetText())
 				oids[c] = 0
 			}
+			if info.IsSequence(field.Name) {
+				p.trackSequence(p.inserts.Schema, p.inserts.Table, field)
+			}
 			c++
 		}
 	}
@@ -456,7 +591,93 @@ func (p *PGXSink) flushInsert() (err error) {
 		paramFormats:  fmts,
 		resultFormats: rets,
 	})
-	return
+}
+
+// copyInsert encodes the batch into the csv for the COPY, which is applied in order with the other pending changes
+func (p *PGXSink) copyInsert(info *decode.ColumnInfo, batch [][]*pb.Field) error {
+	_, filtered := info.Filter(batch[0], func(i decode.ColumnInfo, field string) bool {
+		return !i.IsGenerated(field)
+	})
+	columns := make([]string, len(filtered))
+	for i, f := range filtered {
+		columns[i] = f.Name
+	}
+	changes := make([]*pb.Change, len(batch))
+	for i, record := range batch {
+		changes[i] = &pb.Change{Op: pb.Change_INSERT, Schema: p.inserts.Schema, Table: p.inserts.Table, New: record}
+		for _, field := range record {
+			if info.IsSequence(field.Name) && !info.IsGenerated(field.Name) {
+				p.trackSequence(p.inserts.Schema, p.inserts.Table, field)
+			}
+		}
+	}
+	p.csv.Columns = columns
+	buf := &bytes.Buffer{}
+	if err := p.csv.Encode(buf, changes); err != nil {
+		return err
+	}
+	p.pendingChanges = append(p.pendingChanges, pendingChange{
+		sql:     CopyCSVQuery("pg_temp", CopyStageTable, columns),
+		copy:    buf.Bytes(),
+		stage:   CreateCopyStageQuery(p.inserts.Schema, p.inserts.Table, columns),
+		unstage: InsertCopyStageQuery(p.inserts.Schema, p.inserts.Table, columns, info.ListKeys(), p.pgVersion),
+	})
+	return nil
+}
+
+// trackSequence keeps the max explicit value inserted into the serial or identity column,
+// since the explicit values do not advance the sequence of the target
+func (p *PGXSink) trackSequence(schema, table string, field *pb.Field) {
+	v, ok := sequenceValue(field)
+	if !ok {
+		return
+	}
+	key := sequenceKey{Schema: schema, Table: table, Column: field.Name}
+	if prev, ok := p.sequences[key]; !ok || v > prev {
+		p.sequences[key] = v
+	}
+}
+
+// flushSequences advances the sequences behind the inserted values, so that the later inserts using the column default
+// on the target will not collide with the replicated rows
+func (p *PGXSink) flushSequences() {
+	if p.pgVersion < 100000 {
+		// pg_sequence_last_value is not available before PG10
+		return
+	}
+	for key, v := range p.sequences {
+		p.pendingChanges = append(p.pendingChanges, pendingChange{
+			sql: sql.AdvanceSequence,
+			args: [][]byte{
+				[]byte(pgx.Identifier{key.Schema, key.Table}.Sanitize()),
+				[]byte(key.Column),
+				[]byte(strconv.FormatInt(v, 10)),
+			},
+			paramOIDs:     []uint32{0, 0, 0},
+			paramFormats:  []int16{pgtype.TextFormatCode, pgtype.TextFormatCode, pgtype.TextFormatCode},
+			resultFormats: []int16{pgtype.TextFormatCode},
+		})
+		delete(p.sequences, key)
+	}
+}
+
+func sequenceValue(field *pb.Field) (int64, bool) {
+	switch v := field.Value.(type) {
+	case *pb.Field_Binary:
+		switch {
+		case field.Oid == pgtype.Int2OID && len(v.Binary) == 2:
+			return int64(int16(binary.BigEndian.Uint16(v.Binary))), true
+		case field.Oid == pgtype.Int4OID && len(v.Binary) == 4:
+			return int64(int32(binary.BigEndian.Uint32(v.Binary))), true
+		case field.Oid == pgtype.Int8OID && len(v.Binary) == 8:
+			return int64(binary.BigEndian.Uint64(v.Binary)), true
+		}
+	case *pb.Field_Text:
+		if n, err := strconv.ParseInt(v.Text, 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
 }
 
 func (p *PGXSink) handleInsert(m *pb.Change) (err error) {
@@ -542,48 +763,44 @@ func (p *PGXSink) handleUpdate(m *pb.Change) (err error) {
 	}
 
 	fields := len(sets) + len(keys)
-	vals := make([][]byte, fields)
-	oids := make([]uint32, fields)
-	fmts := make([]int16, fields)
-
-	var j int
-	for ; j < len(sets); j++ {
-		field := sets[j]
+	vals := make([][]byte, 0, fields)
+	oids := make([]uint32, 0, fields)
+	fmts := make([]int16, 0, fields)
+	add := func(field *pb.Field) {
 		if field.Value == nil {
-			fmts[j] = 1
-			vals[j] = nil
-			oids[j] = field.Oid
+			vals, oids, fmts = append(vals, nil), append(oids, field.Oid), append(fmts, 1)
 		} else if value, ok := field.Value.(*pb.Field_Binary); ok {
-			fmts[j] = 1
-			vals[j] = value.Binary
-			oids[j] = field.Oid
+			vals, oids, fmts = append(vals, value.Binary), append(oids, field.Oid), append(fmts, 1)
 		} else {
-			fmts[j] = 0
-			vals[j] = []byte(field.GetText())
-			oids[j] = 0
+			vals, oids, fmts = append(vals, []byte(field.GetText())), append(oids, 0), append(fmts, 0)
 		}
 	}
 
-	for i := 0; i < len(keys); i++ {
-		k := i + j
-		field := keys[i]
-		if field.Value == nil {
-			fmts[k] = 1
-			vals[k] = nil
-			oids[k] = field.Oid
-		} else if value, ok := field.Value.(*pb.Field_Binary); ok {
-			fmts[k] = 1
-			vals[k] = value.Binary
-			oids[k] = field.Oid
-		} else {
-			fmts[k] = 0
-			vals[k] = []byte(field.GetText())
-			oids[k] = 0
+	var patches []int
+	for j, field := range sets {
+		// the partial jsonb update is applied by the jsonb_set of each path, instead of replacing the whole document
+		if field.Oid != decode.JSONBPatchOID {
+			add(field)
+			continue
+		}
+		ps, ok := decode.ParseJSONBPatches(field)
+		if !ok {
+			return fmt.Errorf("%w: %s of %s.%s", decode.ErrJSONBPatch, field.Name, m.Schema, m.Table)
+		}
+		if patches == nil {
+			patches = make([]int, len(sets))
 		}
+		patches[j] = len(ps)
+		for _, patch := range ps {
+			vals, oids, fmts = append(vals, []byte(patch.PathText()), []byte(patch.Value)), append(oids, 0, 0), append(fmts, 0, 0)
+		}
+	}
+	for _, field := range keys {
+		add(field)
 	}
 
 	p.pendingChanges = append(p.pendingChanges, pendingChange{
-		sql:           sql.UpdateQuery(m.Schema, m.Table, sets, keys),
+		sql:           sql.PatchUpdateQuery(m.Schema, m.Table, sets, patches, keys),
 		args:          vals,
 		paramOIDs:     oids,
 		paramFormats:  fmts,
@@ -600,8 +817,15 @@ func (p *PGXSink) handleCommit(sourceRemaining int, cp cursor.Checkpoint, commit
 	if err = p.flushInsert(); err != nil {
 		return err
 	}
+	p.flushSequences()
 
 	for _, q := range p.pendingChanges {
+		if q.copy != nil {
+			if err = p.copyFrom(q); err != nil {
+				return err
+			}
+			continue
+		}
 		p.pipeline.SendQueryParams(q.sql, q.args, q.paramOIDs, q.paramFormats, q.resultFormats)
 	}
 	p.pendingChanges = p.pendingChanges[:0]
@@ -611,48 +835,99 @@ func (p *PGXSink) handleCommit(sourceRemaining int, cp cursor.Checkpoint, commit
 	})
 
 	if len(p.pendingCommits) == p.BatchTXSize || sourceRemaining == 0 {
-		var (
-			cmt   []byte
-			seq   []byte
-			mid   []byte
-			cmtTs []byte
-			id    []byte
-		)
-
-		// pgtype does not support uint64, so we have to encode it as text
-		cmt, err = p.conn.TypeMap().Encode(0, pgtype.TextFormatCode, pgLSN(cp.LSN), nil)
-		if err != nil {
-			return err
-		}
-		seq, err = p.conn.TypeMap().Encode(pgtype.Int4OID, pgtype.BinaryFormatCode, pgInt4(int32(cp.Seq)), nil)
-		if err != nil {
-			return err
-		}
-		mid, err = p.conn.TypeMap().Encode(pgtype.ByteaOID, pgtype.BinaryFormatCode, cp.Data, nil)
-		if err != nil {
-			return err
-		}
-		cmtTs, err = p.conn.TypeMap().Encode(pgtype.TimestamptzOID, pgtype.BinaryFormatCode, pgTz(commit.CommitTime), nil)
-		if err != nil {
-			return err
-		}
-		id, err = p.conn.TypeMap().Encode(pgtype.TextOID, pgtype.BinaryFormatCode, p.pgSrcID, nil)
-		if err != nil {
-			return err
-		}
-		p.pipeline.SendQueryParams(UpdateSourceSQL, [][]byte{cmt, seq, mid, cmtTs, id}, []uint32{0, pgtype.Int4OID, pgtype.ByteaOID, pgtype.TimestamptzOID, pgtype.TextOID}, []int16{pgtype.TextFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode}, []int16{pgtype.TextFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode})
-		err = p.endPipeline()
+		return p.endCommits()
 	}
 	return
 }
 
+// flushCommits applies the pending commits held for the batch on idle, and the pipeline is restarted for
+// the transaction in progress, whose changes are held until its commit
+func (p *PGXSink) flushCommits() (err error) {
+	if len(p.pendingCommits) == 0 {
+		return nil
+	}
+	if err = p.endCommits(); err == nil && p.inTX {
+		p.startPipeline()
+	}
+	return err
+}
+
+// endCommits updates the source checkpoint to the last pending commit and ends the pipeline
+func (p *PGXSink) endCommits() (err error) {
+	last := p.pendingCommits[len(p.pendingCommits)-1]
+	cp, commit := last.checkPoint, last.commit
+	var (
+		cmt   []byte
+		seq   []byte
+		mid   []byte
+		cmtTs []byte
+		id    []byte
+	)
+
+	// pgtype does not support uint64, so we have to encode it as text
+	cmt, err = p.conn.TypeMap().Encode(0, pgtype.TextFormatCode, pgLSN(cp.LSN), nil)
+	if err != nil {
+		return err
+	}
+	seq, err = p.conn.TypeMap().Encode(pgtype.Int4OID, pgtype.BinaryFormatCode, pgInt4(int32(cp.Seq)), nil)
+	if err != nil {
+		return err
+	}
+	mid, err = p.conn.TypeMap().Encode(pgtype.ByteaOID, pgtype.BinaryFormatCode, cp.Data, nil)
+	if err != nil {
+		return err
+	}
+	cmtTs, err = p.conn.TypeMap().Encode(pgtype.TimestamptzOID, pgtype.BinaryFormatCode, pgTz(commit.CommitTime), nil)
+	if err != nil {
+		return err
+	}
+	id, err = p.conn.TypeMap().Encode(pgtype.TextOID, pgtype.BinaryFormatCode, p.pgSrcID, nil)
+	if err != nil {
+		return err
+	}
+	p.pipeline.SendQueryParams(UpdateSourceSQL, [][]byte{cmt, seq, mid, cmtTs, id}, []uint32{0, pgtype.Int4OID, pgtype.ByteaOID, pgtype.TimestamptzOID, pgtype.TextOID}, []int16{pgtype.TextFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode}, []int16{pgtype.TextFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode, pgtype.BinaryFormatCode})
+	return p.endPipeline()
+}
+
 func (p *PGXSink) startPipeline() {
 	if p.pipeline == nil {
 		p.pipeline = p.raw.StartPipeline(context.Background())
+		if p.CopyThreshold > 0 && !p.explicitTx {
+			// the pipeline is interrupted by the COPY, so the implicit transaction of it is not enough
+			p.pipeline.SendQueryParams("BEGIN", nil, nil, nil, nil)
+			p.explicitTx = true
+		}
+	}
+}
+
+// copyFrom performs the COPY between the pipelines in the same explicit transaction, since the COPY is not allowed in a pipeline
+func (p *PGXSink) copyFrom(q pendingChange) (err error) {
+	if err = p.pipeline.Sync(); err != nil {
+		return err
+	}
+	if err = p.pipeline.Close(); err != nil {
+		return err
+	}
+	p.pipeline = nil
+	ctx := context.Background()
+	if _, err = p.raw.Exec(ctx, q.stage).ReadAll(); err != nil {
+		return err
 	}
+	if _, err = p.raw.CopyFrom(ctx, bytes.NewReader(q.copy), q.sql); err != nil {
+		return err
+	}
+	if _, err = p.raw.Exec(ctx, q.unstage).ReadAll(); err != nil {
+		return err
+	}
+	p.startPipeline()
+	return nil
 }
 
 func (p *PGXSink) endPipeline() (err error) {
+	if p.explicitTx {
+		p.pipeline.SendQueryParams("COMMIT", nil, nil, nil, nil)
+		p.explicitTx = false
+	}
 	if err = p.pipeline.Sync(); err != nil {
 		return err
 	}
@@ -712,14 +987,37 @@ func pgInt4(i int32) pgtype.Int4 {
 }
 
 func pgTz(ts uint64) pgtype.Timestamptz {
-	return pgtype.Timestamptz{Time: PGTime2Time(ts), Valid: true}
+	return pgtype.Timestamptz{Time: PGTime2Time(ts), InfinityModifier: pgTimeInfinityModifier(ts), Valid: true}
 }
 
+// PGInfinityTime and PGNegativeInfinityTime are the sentinels returned by the PGTime2Time for 'infinity' and '-infinity',
+// which are out of the range of Postgres timestamps by default.
+var (
+	PGInfinityTime         = time.Date(294277, 1, 1, 0, 0, 0, 0, time.UTC)
+	PGNegativeInfinityTime = time.Date(-4714, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
 func PGTime2Time(ts uint64) time.Time {
+	switch pgTimeInfinityModifier(ts) {
+	case pgtype.Infinity:
+		return PGInfinityTime
+	case pgtype.NegativeInfinity:
+		return PGNegativeInfinityTime
+	}
 	micro := microsecFromUnixEpochToY2K + int64(ts)
 	return time.Unix(micro/microInSecond, (micro%microInSecond)*nsInSecond)
 }
 
+func pgTimeInfinityModifier(ts uint64) pgtype.InfinityModifier {
+	switch int64(ts) {
+	case math.MaxInt64:
+		return pgtype.Infinity
+	case math.MinInt64:
+		return pgtype.NegativeInfinity
+	}
+	return pgtype.Finite
+}
+
 func clone(s string) string {
 	b := make([]byte, len(s))
 	copy(b, s)
@@ -4,8 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"io"
 	"log"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -23,6 +26,7 @@ import (
 	"github.com/replicase/pgcapture/pkg/pb"
 	"github.com/replicase/pgcapture/pkg/source"
 	"github.com/replicase/pgcapture/pkg/sql"
+	"github.com/sirupsen/logrus"
 )
 
 func newPGXSink(batchTXSize int) *PGXSink {
@@ -765,3 +769,563 @@ func TestPGXSink_ScanCheckpointFromLog(t *testing.T) {
 	}
 	sink.Stop()
 }
+
+func TestPGTime2Time_Infinity(t *testing.T) {
+	var negInf int64 = math.MinInt64
+	if ts := PGTime2Time(math.MaxInt64); !ts.Equal(PGInfinityTime) {
+		t.Fatalf("unexpected %v", ts)
+	}
+	if ts := PGTime2Time(uint64(negInf)); !ts.Equal(PGNegativeInfinityTime) {
+		t.Fatalf("unexpected %v", ts)
+	}
+	if ts := PGTime2Time(0); !ts.Equal(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected %v", ts)
+	}
+	if tz := pgTz(math.MaxInt64); tz.InfinityModifier != pgtype.Infinity {
+		t.Fatalf("unexpected %v", tz)
+	}
+	if tz := pgTz(uint64(negInf)); tz.InfinityModifier != pgtype.NegativeInfinity {
+		t.Fatalf("unexpected %v", tz)
+	}
+	if tz := pgTz(0); tz.InfinityModifier != pgtype.Finite {
+		t.Fatalf("unexpected %v", tz)
+	}
+}
+
+func TestPGXSink_SerialSequence(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "DROP EXTENSION IF EXISTS pgcapture")
+	if _, err = conn.Exec(ctx, "create table t3 (id serial primary key, v text)"); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newPGXSink(1)
+	if _, err = sink.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan source.Change, 3)
+	changes <- source.Change{
+		Checkpoint: cursor.Checkpoint{LSN: 1},
+		Message:    &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{}}},
+	}
+	changes <- source.Change{
+		Checkpoint: cursor.Checkpoint{LSN: 1},
+		Message: &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{
+			Op: pb.Change_INSERT, Schema: "public", Table: "t3",
+			New: []*pb.Field{
+				{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 42}}},
+				{Name: "v", Oid: pgtype.TextOID, Value: &pb.Field_Text{Text: "a"}},
+			},
+		}}},
+	}
+	changes <- source.Change{
+		Checkpoint: cursor.Checkpoint{LSN: 1},
+		Message:    &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{}}},
+	}
+
+	committed := sink.Apply(changes)
+	if cp := <-committed; cp.LSN != 1 {
+		t.Fatalf("unexpected %v", cp)
+	}
+	sink.Stop()
+
+	var next int64
+	if err = conn.QueryRow(ctx, "select nextval(pg_get_serial_sequence('public.t3', 'id'))").Scan(&next); err != nil {
+		t.Fatal(err)
+	}
+	if next != 43 {
+		t.Fatalf("sequence should be advanced behind the replicated value, got %d", next)
+	}
+}
+
+func TestPGXSink_SerialSequenceBounds(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "DROP EXTENSION IF EXISTS pgcapture")
+	for _, q := range []string{
+		"create table t5 (id int generated by default as identity (start 1000 minvalue 1000) primary key, v text)",
+		"create table t6 (id int generated by default as identity (increment -1) primary key, v text)",
+		"create table t7 (id int generated by default as identity (start 1000 minvalue 1000) primary key, v text)",
+	} {
+		if _, err = conn.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink := newPGXSink(1)
+	if _, err = sink.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(table string, id int32) *pb.Change {
+		return &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: table, New: []*pb.Field{
+			{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: binary.BigEndian.AppendUint32(nil, uint32(id))}},
+			{Name: "v", Oid: pgtype.TextOID, Value: &pb.Field_Text{Text: "a"}},
+		}}
+	}
+	// the values below the start of the never called sequence, and the values of the descending sequence are skipped
+	changes := copyTx(1, insert("t5", 42), insert("t6", 42), insert("t7", 1000))
+	ch := make(chan source.Change, len(changes))
+	for _, c := range changes {
+		ch <- c
+	}
+	if cp := <-sink.Apply(ch); cp.LSN != 1 {
+		t.Fatalf("unexpected %v", cp)
+	}
+	sink.Stop()
+
+	for table, expect := range map[string]int64{"t5": 1000, "t6": -1, "t7": 1001} {
+		var next int64
+		if err = conn.QueryRow(ctx, "select nextval(pg_get_serial_sequence('public."+table+"', 'id'))").Scan(&next); err != nil {
+			t.Fatal(err)
+		}
+		if next != expect {
+			t.Fatalf("unexpected next value %d of %s", next, table)
+		}
+	}
+}
+
+func TestSequenceValue(t *testing.T) {
+	for _, c := range []struct {
+		field *pb.Field
+		value int64
+		ok    bool
+	}{
+		{field: &pb.Field{Oid: pgtype.Int2OID, Value: &pb.Field_Binary{Binary: []byte{0, 7}}}, value: 7, ok: true},
+		{field: &pb.Field{Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 1, 0}}}, value: 256, ok: true},
+		{field: &pb.Field{Oid: pgtype.Int8OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1, 0, 0, 0, 0}}}, value: 1 << 32, ok: true},
+		{field: &pb.Field{Oid: pgtype.Int8OID, Value: &pb.Field_Text{Text: "-5"}}, value: -5, ok: true},
+		{field: &pb.Field{Oid: pgtype.Int4OID, Value: nil}},
+		{field: &pb.Field{Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{1}}}},
+		{field: &pb.Field{Oid: pgtype.TextOID, Value: &pb.Field_Text{Text: "a"}}},
+	} {
+		if v, ok := sequenceValue(c.field); v != c.value || ok != c.ok {
+			t.Fatalf("unexpected %v %v for %v", v, ok, c.field)
+		}
+	}
+}
+
+func copyTx(lsn uint64, changes ...*pb.Change) []source.Change {
+	txs := []source.Change{{Checkpoint: cursor.Checkpoint{LSN: lsn}, Message: &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{}}}}}
+	for _, c := range changes {
+		txs = append(txs, source.Change{Checkpoint: cursor.Checkpoint{LSN: lsn}, Message: &pb.Message{Type: &pb.Message_Change{Change: c}}})
+	}
+	return append(txs, source.Change{Checkpoint: cursor.Checkpoint{LSN: lsn}, Message: &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{}}}})
+}
+
+func copyInserts(table string, from, to int) (changes []*pb.Change) {
+	for i := from; i <= to; i++ {
+		changes = append(changes, &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: table, New: []*pb.Field{
+			{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: binary.BigEndian.AppendUint32(nil, uint32(i))}},
+			{Name: "v", Oid: pgtype.TextOID, Value: &pb.Field_Text{Text: "v" + strconv.Itoa(i)}},
+			{Name: "g", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: binary.BigEndian.AppendUint32(nil, uint32(i*2))}},
+		}})
+	}
+	return changes
+}
+
+func TestPGXSink_CopyInsert(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "DROP EXTENSION IF EXISTS pgcapture")
+	if _, err = conn.Exec(ctx, "create table t4 (id int primary key, v text, g int generated always as (id * 2) stored)"); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newPGXSink(2)
+	sink.CopyThreshold = 3
+	sink.CopyBatchSize = 4
+	if _, err = sink.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the copied batches should be applied in order with the changes before and after them
+	var changes []source.Change
+	changes = append(changes, copyTx(1, append(copyInserts("t4", 1, 6),
+		&pb.Change{Op: pb.Change_UPDATE, Schema: "public", Table: "t4", New: []*pb.Field{
+			{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 5}}},
+			{Name: "v", Oid: pgtype.TextOID, Value: &pb.Field_Text{Text: "updated"}},
+		}},
+		&pb.Change{Op: pb.Change_DELETE, Schema: "public", Table: "t4", Old: []*pb.Field{
+			{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 6}}},
+		}},
+	)...)...)
+	changes = append(changes, copyTx(2, append(copyInserts("t4", 6, 7), &pb.Change{Op: pb.Change_DELETE, Schema: "public", Table: "t4", Old: []*pb.Field{
+		{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1}}},
+	}})...)...)
+	changes = append(changes, copyTx(3, copyInserts("t4", 8, 10)...)...)
+
+	ch := make(chan source.Change, len(changes))
+	for _, c := range changes {
+		ch <- c
+	}
+	committed := sink.Apply(ch)
+	for _, lsn := range []uint64{1, 2, 3} {
+		if cp := <-committed; cp.LSN != lsn {
+			t.Fatalf("unexpected %v", cp)
+		}
+	}
+	sink.Stop()
+
+	rows, err := conn.Query(ctx, "select id, v, g from t4 order by id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for rows.Next() {
+		var id, g int
+		var v string
+		if err = rows.Scan(&id, &v, &g); err != nil {
+			t.Fatal(err)
+		}
+		if g != id*2 {
+			t.Fatalf("unexpected generated %v of %v", g, id)
+		}
+		got = append(got, v)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "v2,v3,v4,updated,v6,v7,v8,v9,v10" {
+		t.Fatalf("unexpected %v", got)
+	}
+
+	var cp string
+	if err = conn.QueryRow(ctx, "select commit from pgcapture.sources where id = $1", "repl_test").Scan(&cp); err != nil || cp != pglogrepl.LSN(3).String() {
+		t.Fatalf("unexpected %v %v", cp, err)
+	}
+}
+
+func TestPGXSink_CopyInsertConflict(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "DROP EXTENSION IF EXISTS pgcapture")
+	for _, q := range []string{
+		"create table t4 (id int primary key, v text, g int generated always as (id * 2) stored)",
+		"create table t9 (id int primary key, v text, g int)",
+		"insert into t4 (id, v) values (2, 'existing'), (4, 'existing')",
+	} {
+		if _, err = conn.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink := newPGXSink(1)
+	sink.CopyThreshold = 2
+	if _, err = sink.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the copied rows conflicting with the existing ones are skipped like the INSERT, and the stage is reused by
+	// the next COPY of another table in the same transaction
+	changes := copyTx(1, append(copyInserts("t4", 1, 5), copyInserts("t9", 1, 3)...)...)
+	ch := make(chan source.Change, len(changes))
+	for _, c := range changes {
+		ch <- c
+	}
+	if cp := <-sink.Apply(ch); cp.LSN != 1 {
+		t.Fatalf("unexpected %v", cp)
+	}
+	sink.Stop()
+
+	for table, expect := range map[string]string{"t4": "v1,existing,v3,existing,v5", "t9": "v1,v2,v3"} {
+		var got string
+		if err = conn.QueryRow(ctx, "select string_agg(v, ',' order by id) from "+table).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != expect {
+			t.Fatalf("unexpected %v of %v", got, table)
+		}
+	}
+}
+
+func TestPGXSink_CopyInsertEnum(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "DROP EXTENSION IF EXISTS pgcapture")
+	for _, q := range []string{
+		"create type mood as enum ('sad', 'happy')",
+		"create table t8 (id int primary key, m mood)",
+	} {
+		if _, err = conn.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var oid uint32
+	if err = conn.QueryRow(ctx, "select 'mood'::regtype::oid").Scan(&oid); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newPGXSink(1)
+	sink.CopyThreshold = 2
+	if _, err = sink.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the binary enum values can not be copied as csv, and the batch is inserted instead
+	var inserts []*pb.Change
+	for i, m := range []string{"sad", "happy", "sad"} {
+		inserts = append(inserts, &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t8", New: []*pb.Field{
+			{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: binary.BigEndian.AppendUint32(nil, uint32(i+1))}},
+			{Name: "m", Oid: oid, Value: &pb.Field_Binary{Binary: []byte(m)}},
+		}})
+	}
+	changes := copyTx(1, inserts...)
+	ch := make(chan source.Change, len(changes))
+	for _, c := range changes {
+		ch <- c
+	}
+	if cp := <-sink.Apply(ch); cp.LSN != 1 {
+		t.Fatalf("unexpected %v", cp)
+	}
+	sink.Stop()
+
+	var got string
+	if err = conn.QueryRow(ctx, "select string_agg(m::text, ',' order by id) from t8").Scan(&got); err != nil || got != "sad,happy,sad" {
+		t.Fatalf("unexpected %v %v", got, err)
+	}
+}
+
+func TestPGXSink_IdleFlush(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "DROP EXTENSION IF EXISTS pgcapture")
+	if _, err = conn.Exec(ctx, "create table t4 (id int primary key, v text, g int)"); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newPGXSink(10)
+	sink.IdleFlushInterval = 100 * time.Millisecond
+	if _, err = sink.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first transaction is held for the batch since the second one is already queued,
+	// whose commit is not received yet
+	next := copyTx(2, copyInserts("t4", 2, 2)...)
+	ch := make(chan source.Change, 10)
+	for _, c := range append(copyTx(1, copyInserts("t4", 1, 1)...), next[:2]...) {
+		ch <- c
+	}
+	committed := sink.Apply(ch)
+	if cp := <-committed; cp.LSN != 1 {
+		t.Fatalf("unexpected %v", cp)
+	}
+	var count int
+	if err = conn.QueryRow(ctx, "select count(*) from t4").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("unexpected %v %v", count, err)
+	}
+
+	// the transaction in progress is still applied on its commit
+	ch <- next[2]
+	if cp := <-committed; cp.LSN != 2 {
+		t.Fatalf("unexpected %v", cp)
+	}
+	sink.Stop()
+	if err = conn.QueryRow(ctx, "select count(*) from t4").Scan(&count); err != nil || count != 2 {
+		t.Fatalf("unexpected %v %v", count, err)
+	}
+}
+
+func BenchmarkPGXSink_CopyInsert(b *testing.B) {
+	for _, threshold := range []int{0, 100} {
+		b.Run("threshold="+strconv.Itoa(threshold), func(b *testing.B) {
+			ctx := context.Background()
+			conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close(ctx)
+
+			conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+			conn.Exec(ctx, "DROP EXTENSION IF EXISTS pgcapture")
+			if _, err = conn.Exec(ctx, "create table t5 (id int primary key, v text, g int)"); err != nil {
+				b.Fatal(err)
+			}
+
+			sink := newPGXSink(1)
+			sink.CopyThreshold = threshold
+			if _, err = sink.Setup(); err != nil {
+				b.Fatal(err)
+			}
+			const rows = 1000
+			ch := make(chan source.Change, rows+2)
+			committed := sink.Apply(ch)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, c := range copyTx(uint64(i+1), copyInserts("t5", i*rows+1, (i+1)*rows)...) {
+					ch <- c
+				}
+				<-committed
+			}
+			b.StopTimer()
+			sink.Stop()
+		})
+	}
+}
+
+func TestPGXSink_UnchangedToastBytea(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "DROP EXTENSION IF EXISTS pgcapture")
+	for _, q := range []string{
+		"create table t6 (id int primary key, data bytea, v int)",
+		"alter table t6 alter column data set storage external",
+		"insert into t6 values (1, decode(repeat('ab', 100000), 'hex'), 1)",
+	} {
+		if _, err = conn.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink := newPGXSink(1)
+	if _, err = sink.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	// the unchanged toast is omitted from the update by the decoder
+	changes := copyTx(1, &pb.Change{Op: pb.Change_UPDATE, Schema: "public", Table: "t6", New: []*pb.Field{
+		{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1}}},
+		{Name: "v", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 2}}},
+	}})
+	ch := make(chan source.Change, len(changes))
+	for _, c := range changes {
+		ch <- c
+	}
+	if cp := <-sink.Apply(ch); cp.LSN != 1 {
+		t.Fatalf("unexpected %v", cp)
+	}
+	sink.Stop()
+
+	var size, v int
+	if err = conn.QueryRow(ctx, "select length(data), v from t6 where id = 1").Scan(&size, &v); err != nil {
+		t.Fatal(err)
+	}
+	if size != 100000 || v != 2 {
+		t.Fatalf("the unchanged bytea should not be zeroed, got %v %v", size, v)
+	}
+}
+
+func TestPGXSink_JSONBPatch(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	conn.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	conn.Exec(ctx, "DROP EXTENSION IF EXISTS pgcapture")
+	for _, q := range []string{
+		"create table t7 (id int primary key, js jsonb, v int)",
+		`insert into t7 values (1, '{"a": {"b": 1, "c": 2}, "d": [1, 2]}', 1)`,
+	} {
+		if _, err = conn.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink := newPGXSink(1)
+	sink.ApplyJSONBPatches = true
+	if _, err = sink.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	changes := copyTx(1, &pb.Change{Op: pb.Change_UPDATE, Schema: "public", Table: "t7", New: []*pb.Field{
+		{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1}}},
+		{Name: "js", Oid: decode.JSONBPatchOID, Value: &pb.Field_Text{Text: `{"$patch": [{"path": ["a", "b"], "value": "x"}, {"path": ["d", "1"], "value": 3}]}`}},
+		{Name: "v", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 2}}},
+	}})
+	ch := make(chan source.Change, len(changes))
+	for _, c := range changes {
+		ch <- c
+	}
+	if cp := <-sink.Apply(ch); cp.LSN != 1 {
+		t.Fatalf("unexpected %v", cp)
+	}
+	sink.Stop()
+
+	var js string
+	var v int
+	if err = conn.QueryRow(ctx, "select js::text, v from t7 where id = 1").Scan(&js, &v); err != nil {
+		t.Fatal(err)
+	}
+	if js != `{"a": {"b": "x", "c": 2}, "d": [1, 3]}` || v != 2 {
+		t.Fatalf("the patches should be applied to the document, got %v %v", js, v)
+	}
+}
+
+func TestPGXSink_JSONBPatchNotApplied(t *testing.T) {
+	js := &pb.Field{Name: "js", Oid: decode.JSONBPatchOID, Value: &pb.Field_Text{Text: `{"$patch": [{"path": ["a"], "value": 1}]}`}}
+	id := &pb.Field{Name: "id", Oid: pgtype.Int4OID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1}}}
+	// the patches are never written as the documents
+	for _, c := range []struct {
+		apply bool
+		op    pb.Change_Operation
+	}{
+		{apply: false, op: pb.Change_UPDATE},
+		{apply: false, op: pb.Change_INSERT},
+		{apply: true, op: pb.Change_INSERT},
+	} {
+		p := &PGXSink{ApplyJSONBPatches: c.apply}
+		err := p.handleChange(&pb.Change{Op: c.op, Schema: "public", Table: "t", New: []*pb.Field{id, js}})
+		if !errors.Is(err, ErrJSONBPatchNotApplied) {
+			t.Fatalf("unexpected %v of %v", err, c)
+		}
+	}
+}
+
+func TestPGXSink_SkipMissingRoleGrants(t *testing.T) {
+	p := &PGXSink{roles: map[string]bool{"public": true, "reader": true}, log: logrus.WithField("From", "test")}
+	command, _, count, err := p.parseDDL([]*pb.Field{{Name: "query", Value: &pb.Field_Binary{Binary: []byte(
+		"COMMENT ON TABLE t IS 'x';GRANT SELECT ON t TO reader;GRANT SELECT ON t TO reader, writer;REVOKE ALL ON t FROM PUBLIC",
+	)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || command != "COMMENT ON TABLE t IS 'x';GRANT SELECT ON t TO reader;REVOKE ALL ON t FROM PUBLIC;" {
+		t.Fatalf("unexpected %d %q", count, command)
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"hash/fnv"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/apache/pulsar-client-go/pulsar"
 	"github.com/replicase/pgcapture/pkg/cursor"
+	"github.com/replicase/pgcapture/pkg/pb"
 	"github.com/replicase/pgcapture/pkg/source"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/protobuf/proto"
@@ -29,12 +35,30 @@ type PulsarSink struct {
 
 	SetupTracker SetupTracker
 
+	// RoutingKeyFunc computes the routing key of each change, which is set as the OrderingKey of the message,
+	// and the messages of a partitioned topic are routed to the partitions by the hash of their keys.
+	// The changes without routing key go to the DefaultRoutingPartition. The BEGIN and COMMIT frame the transaction in
+	// every partition it touches: the BEGIN is copied to a partition before the first change routed to it, and the
+	// COMMIT is sent to each of them, whose checkpoint is committed after all the copies are sent. The copies carry the
+	// same checkpoint, so the consumers reading multiple partitions receive the BEGIN and COMMIT once per partition.
+	// The partitions are counted at the Setup, so the topic should not be repartitioned while the sink is running.
+	RoutingKeyFunc func(change source.Change) []byte
+
+	// DeleteAsTombstone follows each DELETE by a tombstone for the topic compaction, which is keyed by the table and
+	// the old keys of the row with no payload, and its checkpoint is kept in the cursor.CheckpointProperty.
+	// The DELETE itself is still sent as is, so that it is delivered by the pulsar sources, which skip the tombstones.
+	DeleteAsTombstone bool
+
 	client     pulsar.Client
 	tracker    cursor.Tracker
 	producer   pulsar.Producer
 	log        *logrus.Entry
 	prev       cursor.Checkpoint
 	consistent bool
+	partitions uint32
+	txBegin    *pulsar.ProducerMessage
+	// txKeys are the ordering keys framing the transaction in progress, keyed by their partitions
+	txKeys map[int]string
 }
 
 func (p *PulsarSink) Setup() (cp cursor.Checkpoint, err error) {
@@ -57,8 +81,7 @@ func (p *PulsarSink) Setup() (cp cursor.Checkpoint, err error) {
 		return cp, err
 	}
 
-	// Set up the producer first to avoid the existence of another producer when trying to read the latest message
-	p.producer, err = p.client.CreateProducer(pulsar.ProducerOptions{
+	options := pulsar.ProducerOptions{
 		Topic:               p.PulsarTopic,
 		Name:                p.PulsarTopic + "-producer", // fixed for exclusive producer
 		Properties:          map[string]string{"host": host},
@@ -66,7 +89,18 @@ func (p *PulsarSink) Setup() (cp cursor.Checkpoint, err error) {
 		CompressionType:     pulsar.ZSTD,
 		BatchingMaxMessages: 1000,
 		BatchingMaxSize:     1024 * 1024,
-	})
+	}
+	if p.RoutingKeyFunc != nil {
+		options.MessageRouter = routeByOrderingKey
+		partitions, err := p.client.TopicPartitions(p.PulsarTopic)
+		if err != nil {
+			return cp, err
+		}
+		p.partitions = uint32(len(partitions))
+	}
+
+	// Set up the producer first to avoid the existence of another producer when trying to read the latest message
+	p.producer, err = p.client.CreateProducer(options)
 	if err != nil {
 		return cp, err
 	}
@@ -126,42 +160,164 @@ func (p *PulsarSink) Apply(changes chan source.Change) chan cursor.Checkpoint {
 			return nil
 		}
 
-		bs, err := proto.Marshal(change.Message)
+		msgs, err := p.producerMessages(change)
 		if err != nil {
 			return err
 		}
 
-		p.producer.SendAsync(context.Background(), &pulsar.ProducerMessage{
-			Key:                 change.Checkpoint.ToKey(), // for topic compaction, not routing policy
-			Payload:             bs,
-			ReplicationClusters: p.ReplicatedClusters,
-		}, func(id pulsar.MessageID, message *pulsar.ProducerMessage, err error) {
-			var idHex string
-			if id != nil {
-				idHex = hex.EncodeToString(id.Serialize())
-			}
-
-			if err != nil {
-				p.log.WithFields(logrus.Fields{
-					"MessageLSN":         change.Checkpoint.LSN,
-					"MessageIDHex":       idHex,
-					"ReplicatedClusters": p.ReplicatedClusters,
-				}).Errorf("fail to send message to pulsar: %v", err)
-				p.BaseSink.err.Store(fmt.Errorf("%w", err))
-				p.BaseSink.Stop()
-				return
-			}
-
-			cp := change.Checkpoint
-			if err := p.tracker.Commit(cp, id); err != nil {
-				p.log.WithFields(logrus.Fields{
-					"MessageLSN":   change.Checkpoint.LSN,
-					"MessageSeq":   change.Checkpoint.Seq,
-					"MessageIDHex": idHex,
-				}).Errorf("fail to commit message to tracker: %v", err)
-			}
-			committed <- cp
-		})
+		// the checkpoint is committed after all the messages of the change are sent, like the tombstone following
+		// the DELETE, or the COMMIT sent to multiple partitions
+		remaining := int32(len(msgs))
+		for _, msg := range msgs {
+			p.producer.SendAsync(context.Background(), msg, func(id pulsar.MessageID, message *pulsar.ProducerMessage, err error) {
+				var idHex string
+				if id != nil {
+					idHex = hex.EncodeToString(id.Serialize())
+				}
+
+				if err != nil {
+					p.log.WithFields(logrus.Fields{
+						"MessageLSN":         change.Checkpoint.LSN,
+						"MessageIDHex":       idHex,
+						"ReplicatedClusters": p.ReplicatedClusters,
+					}).Errorf("fail to send message to pulsar: %v", err)
+					p.BaseSink.err.Store(fmt.Errorf("%w", err))
+					p.BaseSink.Stop()
+					return
+				}
+				if atomic.AddInt32(&remaining, -1) != 0 {
+					return
+				}
+
+				cp := change.Checkpoint
+				if err := p.tracker.Commit(cp, id); err != nil {
+					p.log.WithFields(logrus.Fields{
+						"MessageLSN":   change.Checkpoint.LSN,
+						"MessageSeq":   change.Checkpoint.Seq,
+						"MessageIDHex": idHex,
+					}).Errorf("fail to commit message to tracker: %v", err)
+				}
+				committed <- cp
+			})
+		}
 		return nil
 	})
 }
+
+// producerMessages returns the message of the change, followed by the tombstone if it is a DELETE with the DeleteAsTombstone
+func (p *PulsarSink) producerMessages(change source.Change) ([]*pulsar.ProducerMessage, error) {
+	msg := &pulsar.ProducerMessage{
+		Key:                 change.Checkpoint.ToKey(), // for topic compaction, not routing policy
+		ReplicationClusters: p.ReplicatedClusters,
+	}
+	if change.Checkpoint.GlobalSeq != 0 {
+		msg.Properties = map[string]string{cursor.GlobalSeqProperty: strconv.FormatUint(change.Checkpoint.GlobalSeq, 10)}
+	}
+	if p.RoutingKeyFunc != nil {
+		msg.OrderingKey = string(p.RoutingKeyFunc(change))
+	}
+	bs, err := proto.Marshal(change.Message)
+	if err != nil {
+		return nil, err
+	}
+	msg.Payload = bs
+	if p.partitions > 1 {
+		switch change.Message.Type.(type) {
+		case *pb.Message_Begin:
+			p.txBegin = msg
+			p.txKeys = map[int]string{partitionOf(msg.OrderingKey, p.partitions): msg.OrderingKey}
+			return []*pulsar.ProducerMessage{msg}, nil
+		case *pb.Message_Commit:
+			return p.framedCommit(msg), nil
+		}
+	}
+	msgs := p.framedBegin(msg)
+	c := change.Message.GetChange()
+	if !p.DeleteAsTombstone || c == nil || c.Op != pb.Change_DELETE {
+		return msgs, nil
+	}
+	tombstone := &pulsar.ProducerMessage{
+		Key:                 tombstoneKey(c),
+		OrderingKey:         msg.OrderingKey,
+		Properties:          map[string]string{cursor.CheckpointProperty: msg.Key},
+		ReplicationClusters: p.ReplicatedClusters,
+	}
+	for k, v := range msg.Properties {
+		tombstone.Properties[k] = v
+	}
+	return append(msgs, tombstone), nil
+}
+
+// framedBegin returns the message preceded by a copy of the BEGIN if it is the first message of the transaction
+// routed to its partition
+func (p *PulsarSink) framedBegin(msg *pulsar.ProducerMessage) []*pulsar.ProducerMessage {
+	if p.txBegin == nil {
+		return []*pulsar.ProducerMessage{msg}
+	}
+	partition := partitionOf(msg.OrderingKey, p.partitions)
+	if _, ok := p.txKeys[partition]; ok {
+		return []*pulsar.ProducerMessage{msg}
+	}
+	p.txKeys[partition] = msg.OrderingKey
+	begin := *p.txBegin
+	begin.OrderingKey = msg.OrderingKey
+	return []*pulsar.ProducerMessage{&begin, msg}
+}
+
+// framedCommit returns the copies of the COMMIT to all the partitions touched by the transaction, in the partition order
+func (p *PulsarSink) framedCommit(msg *pulsar.ProducerMessage) []*pulsar.ProducerMessage {
+	keys := p.txKeys
+	p.txBegin, p.txKeys = nil, nil
+	if keys == nil {
+		keys = make(map[int]string, 1)
+	}
+	partition := partitionOf(msg.OrderingKey, p.partitions)
+	if _, ok := keys[partition]; !ok {
+		keys[partition] = msg.OrderingKey
+	}
+	partitions := make([]int, 0, len(keys))
+	for partition := range keys {
+		partitions = append(partitions, partition)
+	}
+	sort.Ints(partitions)
+	msgs := make([]*pulsar.ProducerMessage, len(partitions))
+	for i, partition := range partitions {
+		commit := *msg
+		commit.OrderingKey = keys[partition]
+		msgs[i] = &commit
+	}
+	return msgs
+}
+
+// tombstoneKey is the "schema.table" followed by the old keys of the row, the binary values are in hex
+func tombstoneKey(c *pb.Change) string {
+	var sb strings.Builder
+	sb.WriteString(c.Schema + "." + c.Table)
+	for _, f := range c.Old {
+		sb.WriteString("|" + f.Name + "=")
+		switch v := f.Value.(type) {
+		case *pb.Field_Binary:
+			sb.WriteString(hex.EncodeToString(v.Binary))
+		case *pb.Field_Text:
+			sb.WriteString(v.Text)
+		}
+	}
+	return sb.String()
+}
+
+// DefaultRoutingPartition receives the messages without routing key
+const DefaultRoutingPartition = 0
+
+// routeByOrderingKey routes the messages by the fnv hash of their ordering keys
+func routeByOrderingKey(msg *pulsar.ProducerMessage, metadata pulsar.TopicMetadata) int {
+	return partitionOf(msg.OrderingKey, metadata.NumPartitions())
+}
+
+func partitionOf(key string, n uint32) int {
+	if key == "" || n <= 1 {
+		return DefaultRoutingPartition
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % n)
+}
@@ -1,6 +1,8 @@
 package sink
 
 import (
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"github.com/replicase/pgcapture/pkg/cursor"
 	"github.com/replicase/pgcapture/pkg/pb"
 	"github.com/replicase/pgcapture/pkg/source"
+	"google.golang.org/protobuf/proto"
 )
 
 func newPulsarSink(topic string, tracker *cursormock.MockTracker) *PulsarSink {
@@ -143,3 +146,229 @@ func TestPulsarSink_DuplicatedSink(t *testing.T) {
 		t.Fatal("unexpected", err)
 	}
 }
+
+type topicMetadata uint32
+
+func (m topicMetadata) NumPartitions() uint32 {
+	return uint32(m)
+}
+
+func TestPulsarSink_RoutingKeyFunc(t *testing.T) {
+	// route by the tenant column, which is not the primary key
+	sink := &PulsarSink{RoutingKeyFunc: func(change source.Change) []byte {
+		for _, f := range change.Message.GetChange().GetNew() {
+			if f.Name == "tenant" {
+				return []byte(f.GetText())
+			}
+		}
+		return nil
+	}}
+	change := func(id, tenant string) source.Change {
+		return source.Change{Message: &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{New: []*pb.Field{
+			{Name: "id", Value: &pb.Field_Text{Text: id}},
+			{Name: "tenant", Value: &pb.Field_Text{Text: tenant}},
+		}}}}}
+	}
+	route := func(c source.Change) int {
+		return routeByOrderingKey(&pulsar.ProducerMessage{OrderingKey: string(sink.RoutingKeyFunc(c))}, topicMetadata(16))
+	}
+
+	partitions := map[string]int{}
+	for i := 0; i < 100; i++ {
+		tenant := "tenant-" + strconv.Itoa(i%10)
+		p := route(change(strconv.Itoa(i), tenant))
+		if prev, ok := partitions[tenant]; ok && prev != p {
+			t.Fatalf("changes of %s are routed to different partitions %v %v", tenant, prev, p)
+		}
+		if p < 0 || p >= 16 {
+			t.Fatalf("unexpected %v", p)
+		}
+		partitions[tenant] = p
+	}
+	distinct := map[int]bool{}
+	for _, p := range partitions {
+		distinct[p] = true
+	}
+	if len(distinct) < 2 {
+		t.Fatalf("tenants should be spread out %v", partitions)
+	}
+
+	if p := route(source.Change{Message: &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{}}}}); p != DefaultRoutingPartition {
+		t.Fatalf("unexpected %v", p)
+	}
+	if p := routeByOrderingKey(&pulsar.ProducerMessage{OrderingKey: "tenant-1"}, topicMetadata(0)); p != DefaultRoutingPartition {
+		t.Fatalf("unexpected %v", p)
+	}
+}
+
+func TestPulsarSink_RoutingKeyFuncFraming(t *testing.T) {
+	sink := &PulsarSink{partitions: 16, RoutingKeyFunc: func(change source.Change) []byte {
+		if c := change.Message.GetChange(); c != nil {
+			return []byte(c.Table)
+		}
+		return nil
+	}}
+	begin := source.Change{Checkpoint: cursor.Checkpoint{LSN: 100}, Message: &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{}}}}
+	commit := source.Change{Checkpoint: cursor.Checkpoint{LSN: 100}, Message: &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{}}}}
+	change := func(table string) source.Change {
+		return source.Change{Checkpoint: cursor.Checkpoint{LSN: 100}, Message: &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Table: table}}}}
+	}
+	// the tables routed to different partitions
+	var a, b string
+	for i := 0; b == ""; i++ {
+		table := "t" + strconv.Itoa(i)
+		if a == "" && partitionOf(table, 16) != DefaultRoutingPartition {
+			a = table
+		} else if a != "" && partitionOf(table, 16) != partitionOf(a, 16) && partitionOf(table, 16) != DefaultRoutingPartition {
+			b = table
+		}
+	}
+
+	var sent []*pulsar.ProducerMessage
+	for _, c := range []source.Change{begin, change(a), change(b), change(a), commit} {
+		msgs, err := sink.producerMessages(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, msgs...)
+	}
+	frames := map[int][]string{}
+	for _, msg := range sent {
+		var m pb.Message
+		if err := proto.Unmarshal(msg.Payload, &m); err != nil {
+			t.Fatal(err)
+		}
+		partition := routeByOrderingKey(msg, topicMetadata(16))
+		switch {
+		case m.GetBegin() != nil:
+			frames[partition] = append(frames[partition], "begin")
+		case m.GetCommit() != nil:
+			frames[partition] = append(frames[partition], "commit")
+		default:
+			frames[partition] = append(frames[partition], m.GetChange().Table)
+		}
+	}
+	// every partition touched by the transaction sees it framed by the BEGIN and the COMMIT
+	expect := map[int][]string{
+		DefaultRoutingPartition: {"begin", "commit"},
+		partitionOf(a, 16):      {"begin", a, a, "commit"},
+		partitionOf(b, 16):      {"begin", b, "commit"},
+	}
+	if !reflect.DeepEqual(frames, expect) {
+		t.Fatalf("unexpected %v", frames)
+	}
+
+	// the next transaction only frames its own partitions
+	sent = nil
+	for _, c := range []source.Change{begin, change(b), commit} {
+		msgs, err := sink.producerMessages(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, msgs...)
+	}
+	if len(sent) != 5 {
+		t.Fatalf("unexpected %v", len(sent))
+	}
+}
+
+func TestPulsarSink_DeleteAsTombstone(t *testing.T) {
+	change := func(op pb.Change_Operation) source.Change {
+		return source.Change{
+			Checkpoint: cursor.Checkpoint{LSN: 100, Seq: 2, GlobalSeq: 5},
+			Message: &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: op, Schema: "public", Table: "t1", Old: []*pb.Field{
+				{Name: "id", Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1}}},
+				{Name: "tenant", Value: &pb.Field_Text{Text: "a"}},
+			}}}},
+		}
+	}
+	sink := &PulsarSink{DeleteAsTombstone: true}
+	msgs, err := sink.producerMessages(change(pb.Change_DELETE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the DELETE is still sent as is, followed by the tombstone
+	if len(msgs) != 2 || msgs[0].Key != "0/64|2" || len(msgs[0].Payload) == 0 {
+		t.Fatalf("unexpected %v", msgs)
+	}
+	if tombstone := msgs[1]; tombstone.Key != "public.t1|id=00000001|tenant=a" || tombstone.Payload != nil {
+		t.Fatalf("unexpected tombstone %v %v", tombstone.Key, tombstone.Payload)
+	} else if tombstone.Properties[cursor.CheckpointProperty] != "0/64|2" || tombstone.Properties[cursor.GlobalSeqProperty] != "5" {
+		t.Fatalf("unexpected %v", tombstone.Properties)
+	}
+
+	// the other changes, or the DELETE without the option, are sent without tombstone
+	for _, tc := range []struct {
+		sink *PulsarSink
+		op   pb.Change_Operation
+	}{
+		{sink: sink, op: pb.Change_UPDATE},
+		{sink: &PulsarSink{}, op: pb.Change_DELETE},
+	} {
+		msgs, err := tc.sink.producerMessages(change(tc.op))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 || msgs[0].Key != "0/64|2" || len(msgs[0].Payload) == 0 {
+			t.Fatalf("unexpected %v", msgs)
+		}
+		if _, ok := msgs[0].Properties[cursor.CheckpointProperty]; ok {
+			t.Fatalf("unexpected %v", msgs[0].Properties)
+		}
+	}
+}
+
+func TestPulsarSink_DeleteAsTombstoneRoundTrip(t *testing.T) {
+	topic := time.Now().Format("20060102150405") + "-tombstone"
+	ctrl := gomock.NewController(t)
+	tracker := cursormock.NewMockTracker(ctrl)
+
+	sink := newPulsarSink(topic, tracker)
+	sink.DeleteAsTombstone = true
+	tracker.EXPECT().Last().Return(cursor.Checkpoint{}, nil)
+	tracker.EXPECT().Start()
+	if _, err := sink.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := []*pb.Field{{Name: "id", Value: &pb.Field_Text{Text: "1"}}}
+	sent := []*pb.Message{
+		{Type: &pb.Message_Begin{Begin: &pb.Begin{FinalLsn: 1}}},
+		{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1", New: old}}},
+		{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_DELETE, Schema: "public", Table: "t1", Old: old}}},
+		{Type: &pb.Message_Commit{Commit: &pb.Commit{CommitLsn: 1, EndLsn: 2}}},
+	}
+	changes := make(chan source.Change)
+	committed := sink.Apply(changes)
+	for i, m := range sent {
+		change := source.Change{Checkpoint: cursor.Checkpoint{LSN: 1, Seq: uint32(i)}, Message: m}
+		tracker.EXPECT().Commit(change.Checkpoint, gomock.Any()).Return(nil)
+		changes <- change
+		if recv := <-committed; !recv.Equal(change.Checkpoint) {
+			t.Fatalf("unexpected %v", recv)
+		}
+	}
+	close(changes)
+	tracker.EXPECT().Close()
+	if err := sink.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the DELETE is delivered by the source, while the tombstone is skipped
+	src := &source.PulsarReaderSource{
+		BaseSource:   source.BaseSource{ReadTimeout: time.Millisecond * 100},
+		PulsarOption: pulsar.ClientOptions{URL: test.GetPulsarURL()},
+		PulsarTopic:  topic,
+	}
+	received, err := src.Capture(cursor.Checkpoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range sent {
+		change := <-received
+		if change.Checkpoint.LSN != 1 || change.Checkpoint.Seq != uint32(i) || !proto.Equal(change.Message, m) {
+			t.Fatalf("unexpected %v %v", change.Checkpoint, change.Message.String())
+		}
+	}
+	src.Stop()
+}
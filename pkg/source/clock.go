@@ -0,0 +1,33 @@
+package source
+
+import "time"
+
+// Clock is the time of the PGXSource, for the report interval, the receive timeout, the reconnect backoff and
+// the slot creation timeout, which can be replaced to drive the timers deterministically
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls the fn in its own goroutine after the duration d like the time.AfterFunc
+	AfterFunc(d time.Duration, fn func()) Timer
+}
+
+// Timer is the timer created by the Clock.AfterFunc, which is stopped like the time.Timer
+type Timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, fn func()) Timer {
+	return time.AfterFunc(d, fn)
+}
+
+// sleepClock waits for the duration d by the clock
+func sleepClock(clock Clock, d time.Duration) {
+	done := make(chan struct{})
+	clock.AfterFunc(d, func() { close(done) })
+	<-done
+}
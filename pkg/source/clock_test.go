@@ -0,0 +1,132 @@
+package source
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/replicase/pgcapture/pkg/cursor"
+)
+
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	fn    func()
+	done  bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, fn func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), fn: fn}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward and fires the timers due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	for _, t := range c.timers {
+		if !t.done && !t.at.After(c.now) {
+			t.done = true
+			due = append(due, t)
+		}
+	}
+	c.mu.Unlock()
+	for _, t := range due {
+		go t.fn()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	stopped := !t.done
+	t.done = true
+	return stopped
+}
+
+func TestPGXSource_Clock(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	src := newFakePGXSource(conn)
+	src.Clock = clock
+	src.Commit(cursor.Checkpoint{LSN: 100})
+
+	ctx := context.Background()
+	for _, expect := range []struct {
+		advance time.Duration
+		updates int
+	}{
+		{advance: 0, updates: 1},
+		{advance: 4 * time.Second, updates: 1},
+		{advance: time.Second - time.Nanosecond, updates: 1},
+		{advance: 2 * time.Nanosecond, updates: 2},
+		// the interval is counted from the last update sent
+		{advance: time.Second, updates: 2},
+		{advance: 4 * time.Second, updates: 2},
+		{advance: time.Nanosecond, updates: 3},
+	} {
+		clock.Advance(expect.advance)
+		conn.messages <- keepalive(false)
+		if _, err := src.fetching(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(conn.updates) != expect.updates {
+			t.Fatalf("unexpected %d updates at %v, expected %d", len(conn.updates), clock.Now(), expect.updates)
+		}
+	}
+	if u := conn.updates[len(conn.updates)-1]; u.WALWritePosition != pglogrepl.LSN(100) {
+		t.Fatalf("unexpected %v", u)
+	}
+
+	// the reply requested by the keepalive is sent at the next fetching regardless of the interval
+	conn.messages <- keepalive(true)
+	conn.messages <- keepalive(false)
+	for i := 0; i < 2; i++ {
+		if _, err := src.fetching(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(conn.updates) != 4 {
+		t.Fatalf("unexpected %d updates", len(conn.updates))
+	}
+
+	// the reconnect backoff waits for the clock
+	src.state = 2
+	deadline := clock.Now().Add(30 * time.Second)
+	slept := make(chan bool)
+	go func() { slept <- src.sleep(30 * time.Second) }()
+	for i := 0; ; i++ {
+		select {
+		case ok := <-slept:
+			if !ok || clock.Now().Before(deadline) {
+				t.Fatalf("unexpected wake up at %v", clock.Now())
+			}
+			return
+		default:
+		}
+		if i > 1000 {
+			t.Fatal("unexpected still sleeping")
+		}
+		clock.Advance(100 * time.Millisecond)
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,84 @@
+package source
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/pkg/decode"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+// DigestField is the jsonb field of the digest change, which holds the TableDigest keyed by "schema.table"
+const DigestField = "digest"
+
+// TableDigest is the running counts of the row changes of a table delivered since the source started
+type TableDigest struct {
+	Inserts uint64 `json:"inserts"`
+	Updates uint64 `json:"updates"`
+	Deletes uint64 `json:"deletes"`
+}
+
+type digest struct {
+	tables  map[string]*TableDigest
+	pending uint64
+	emitted time.Time
+}
+
+// ParseDigest decodes the digest change emitted by the PGXSource, which can be told by the decode.IsDigest
+func ParseDigest(m *pb.Change) (tables map[string]TableDigest, err error) {
+	for _, f := range m.New {
+		if f.Name == DigestField {
+			err = json.Unmarshal([]byte(f.GetText()), &tables)
+			return
+		}
+	}
+	return nil, nil
+}
+
+// countDigest adds the row change to the digest if the digest is enabled
+func (p *PGXSource) countDigest(m *pb.Change, now time.Time) {
+	if m == nil || decode.IsDDL(m) || (p.DigestEveryChanges <= 0 && p.DigestInterval <= 0) {
+		return
+	}
+	if p.digest == nil {
+		p.digest = &digest{tables: make(map[string]*TableDigest), emitted: now}
+	}
+	key := m.Schema + "." + m.Table
+	t, ok := p.digest.tables[key]
+	if !ok {
+		t = &TableDigest{}
+		p.digest.tables[key] = t
+	}
+	switch m.Op {
+	case pb.Change_INSERT:
+		t.Inserts++
+	case pb.Change_UPDATE:
+		t.Updates++
+	case pb.Change_DELETE:
+		t.Deletes++
+	}
+	p.digest.pending++
+}
+
+// digestDue reports whether the digest should be emitted at the COMMIT, after the DigestEveryChanges changes
+// or the DigestInterval since the last one, and only if there are changes since then
+func (p *PGXSource) digestDue(now time.Time) bool {
+	if p.digest == nil || p.digest.pending == 0 {
+		return false
+	}
+	return (p.DigestEveryChanges > 0 && p.digest.pending >= uint64(p.DigestEveryChanges)) ||
+		(p.DigestInterval > 0 && now.Sub(p.digest.emitted) >= p.DigestInterval)
+}
+
+func (p *PGXSource) digestMessage(now time.Time) *pb.Message {
+	p.digest.pending = 0
+	p.digest.emitted = now
+	bs, _ := json.Marshal(p.digest.tables)
+	return &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{
+		Op:     pb.Change_INSERT,
+		Schema: decode.ExtensionSchema,
+		Table:  decode.ExtensionDigests,
+		New:    []*pb.Field{{Name: DigestField, Oid: pgtype.JSONBOID, Value: &pb.Field_Text{Text: string(bs)}}},
+	}}}
+}
@@ -0,0 +1,103 @@
+package source
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/replicase/pgcapture/pkg/decode"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+func digestTx(conn *fakeReplConn, lsn uint64, changes ...*pb.Change) {
+	conn.messages <- xLogData(lsn, &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{FinalLsn: lsn}}})
+	for _, c := range changes {
+		conn.messages <- xLogData(lsn, &pb.Message{Type: &pb.Message_Change{Change: c}})
+	}
+	conn.messages <- xLogData(lsn, &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{CommitLsn: lsn, EndLsn: lsn + 1}}})
+}
+
+func readDigest(t *testing.T, changes chan Change, lsn uint64, seq uint32) map[string]TableDigest {
+	c := <-changes
+	m := c.Message.GetChange()
+	if m == nil || !decode.IsDigest(m) || c.Checkpoint.LSN != lsn || c.Checkpoint.Seq != seq {
+		t.Fatalf("unexpected %v %v", c.Checkpoint, c.Message.String())
+	}
+	tables, err := ParseDigest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+func TestPGXSource_Digest(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 20)}
+	digestTx(conn, 100,
+		&pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"},
+		&pb.Change{Op: pb.Change_UPDATE, Schema: "public", Table: "t1"},
+	)
+	digestTx(conn, 200,
+		&pb.Change{Op: pb.Change_DELETE, Schema: "public", Table: "t2"},
+		&pb.Change{Op: pb.Change_INSERT, Schema: decode.ExtensionSchema, Table: decode.ExtensionDDLLogs},
+	)
+	digestTx(conn, 300, &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t2"})
+
+	src := newFakePGXSource(conn)
+	src.DigestEveryChanges = 2
+	src.DDLDelivery = DDLDeliveryEmitNoRefresh
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expect := range []struct {
+		skip   int
+		lsn    uint64
+		seq    uint32
+		tables map[string]TableDigest
+	}{
+		// the digest is delivered before the COMMIT of the transaction reaching the cadence, and the DDL is not counted
+		{skip: 3, lsn: 100, seq: 3, tables: map[string]TableDigest{"public.t1": {Inserts: 1, Updates: 1}}},
+		{skip: 6, lsn: 300, seq: 2, tables: map[string]TableDigest{"public.t1": {Inserts: 1, Updates: 1}, "public.t2": {Inserts: 1, Deletes: 1}}},
+	} {
+		for i := 0; i < expect.skip; i++ {
+			<-changes
+		}
+		if tables := readDigest(t, changes, expect.lsn, expect.seq); !reflect.DeepEqual(tables, expect.tables) {
+			t.Fatalf("unexpected %v", tables)
+		}
+		if c := <-changes; c.Message.GetCommit() == nil || c.Checkpoint.LSN != expect.lsn || c.Checkpoint.Seq != expect.seq+1 {
+			t.Fatalf("unexpected %v %v", c.Checkpoint, c.Message.String())
+		}
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPGXSource_DigestInterval(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 20)}
+	src := newFakePGXSource(conn)
+	src.DigestInterval = 50 * time.Millisecond
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digestTx(conn, 100, &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"})
+	readTx(t, changes, 1)
+	time.Sleep(100 * time.Millisecond)
+	digestTx(conn, 200, &pb.Change{Op: pb.Change_UPDATE, Schema: "public", Table: "t1"})
+	<-changes
+	<-changes
+	if tables := readDigest(t, changes, 200, 2); !reflect.DeepEqual(tables, map[string]TableDigest{"public.t1": {Inserts: 1, Updates: 1}}) {
+		t.Fatalf("unexpected %v", tables)
+	}
+	if c := <-changes; c.Message.GetCommit() == nil || c.Checkpoint.Seq != 3 {
+		t.Fatalf("unexpected %v %v", c.Checkpoint, c.Message.String())
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,116 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/replicase/pgcapture/pkg/cursor"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type ExportFormat int
+
+const (
+	// ExportJSONLines writes one JSON object with the checkpoint, the WAL positions and the message per line
+	ExportJSONLines ExportFormat = iota
+	// ExportProtoDelimited writes the messages in protobuf, each prefixed with its length in uvarint
+	ExportProtoDelimited
+)
+
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+type exportedChange struct {
+	LSN       uint64 `json:"lsn"`
+	Seq       uint32 `json:"seq"`
+	GlobalSeq uint64 `json:"gseq,omitempty"`
+	// the WAL positions are only set by the PGXSource, for the provenance of each change in the audit logs
+	WALStart     uint64          `json:"wal_start,omitempty"`
+	ServerWALEnd uint64          `json:"server_wal_end,omitempty"`
+	CommitLSN    uint64          `json:"commit_lsn,omitempty"`
+	EndLSN       uint64          `json:"end_lsn,omitempty"`
+	Message      json.RawMessage `json:"message"`
+}
+
+// ExportChanges captures from the checkpoint and writes the changes to the w until the ctx is done,
+// then commits the last exported transaction and stops the src.
+func ExportChanges(ctx context.Context, src Source, cp cursor.Checkpoint, w io.Writer, format ExportFormat) (err error) {
+	if format != ExportJSONLines && format != ExportProtoDelimited {
+		return ErrUnknownExportFormat
+	}
+	changes, err := src.Capture(cp)
+	if err != nil {
+		return err
+	}
+
+	var committed cursor.Checkpoint
+	defer func() {
+		if committed.LSN != 0 {
+			src.Commit(committed)
+		}
+		if stopErr := src.Stop(); err == nil {
+			err = stopErr
+		}
+	}()
+
+	buf := bufio.NewWriter(w)
+	for {
+		select {
+		case <-ctx.Done():
+			return buf.Flush()
+		case change, more := <-changes:
+			if !more {
+				if err = buf.Flush(); err != nil {
+					return err
+				}
+				return src.Error()
+			}
+			if err = writeChange(buf, change, format); err != nil {
+				return err
+			}
+			if change.Message.GetCommit() != nil {
+				if err = buf.Flush(); err != nil {
+					return err
+				}
+				committed = change.Checkpoint
+			}
+		}
+	}
+}
+
+func writeChange(w *bufio.Writer, change Change, format ExportFormat) error {
+	switch format {
+	case ExportJSONLines:
+		m, err := protojson.Marshal(change.Message)
+		if err != nil {
+			return err
+		}
+		line, err := json.Marshal(exportedChange{
+			LSN:          change.Checkpoint.LSN,
+			Seq:          change.Checkpoint.Seq,
+			GlobalSeq:    change.Checkpoint.GlobalSeq,
+			WALStart:     change.WALStart,
+			ServerWALEnd: change.ServerWALEnd,
+			CommitLSN:    change.CommitLSN,
+			EndLSN:       change.EndLSN,
+			Message:      m,
+		})
+		if err != nil {
+			return err
+		}
+		w.Write(line)
+		return w.WriteByte('\n')
+	default:
+		m, err := proto.Marshal(change.Message)
+		if err != nil {
+			return err
+		}
+		w.Write(binary.AppendUvarint(nil, uint64(len(m))))
+		_, err = w.Write(m)
+		return err
+	}
+}
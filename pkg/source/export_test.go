@@ -0,0 +1,109 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/replicase/pgcapture/pkg/cursor"
+	"github.com/replicase/pgcapture/pkg/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var errExportDone = errors.New("done")
+
+func newExportFakeSource() *managedFakeSource {
+	var changes []Change
+	for i, m := range fakeTx(100) {
+		changes = append(changes, Change{Checkpoint: cursor.Checkpoint{LSN: 100, Seq: uint32(i)}, Message: m, WALStart: 90 + uint64(i), ServerWALEnd: 500, CommitLSN: 100, EndLSN: 108})
+	}
+	return newManagedFakeSource(func(ctx context.Context) (Change, error) {
+		if len(changes) == 0 {
+			return Change{}, errExportDone
+		}
+		change := changes[0]
+		changes = changes[1:]
+		return change, nil
+	})
+}
+
+func TestExportChanges_JSONLines(t *testing.T) {
+	src := newExportFakeSource()
+	buf := bytes.NewBuffer(nil)
+	if err := ExportChanges(context.Background(), src, cursor.Checkpoint{LSN: 50}, buf, ExportJSONLines); !errors.Is(err, errExportDone) {
+		t.Fatalf("unexpected %v", err)
+	}
+	if src.cp.LSN != 50 {
+		t.Fatalf("unexpected %v", src.cp)
+	}
+	if len(src.committed) != 1 || src.committed[0].LSN != 100 {
+		t.Fatalf("the last transaction should be committed %v", src.committed)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	expect := fakeTx(100)
+	if len(lines) != len(expect) {
+		t.Fatalf("unexpected %v", lines)
+	}
+	for i, line := range lines {
+		var exported exportedChange
+		if err := json.Unmarshal([]byte(line), &exported); err != nil {
+			t.Fatal(err)
+		}
+		m := &pb.Message{}
+		if err := protojson.Unmarshal(exported.Message, m); err != nil {
+			t.Fatal(err)
+		}
+		if exported.LSN != 100 || exported.Seq != uint32(i) || !proto.Equal(m, expect[i]) {
+			t.Fatalf("unexpected %v", line)
+		}
+		if exported.WALStart != 90+uint64(i) || exported.ServerWALEnd != 500 || exported.CommitLSN != 100 || exported.EndLSN != 108 {
+			t.Fatalf("unexpected wal positions %v", line)
+		}
+	}
+}
+
+func TestExportChanges_ProtoDelimited(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := ExportChanges(context.Background(), newExportFakeSource(), cursor.Checkpoint{}, buf, ExportProtoDelimited); !errors.Is(err, errExportDone) {
+		t.Fatalf("unexpected %v", err)
+	}
+	data := buf.Bytes()
+	for _, expect := range fakeTx(100) {
+		n, l := binary.Uvarint(data)
+		if l <= 0 || len(data) < l+int(n) {
+			t.Fatalf("unexpected %v", data)
+		}
+		m := &pb.Message{}
+		if err := proto.Unmarshal(data[l:l+int(n)], m); err != nil || !proto.Equal(m, expect) {
+			t.Fatalf("unexpected %v %v", m, err)
+		}
+		data = data[l+int(n):]
+	}
+	if len(data) != 0 {
+		t.Fatalf("unexpected %v", data)
+	}
+}
+
+func TestExportChanges_ContextDone(t *testing.T) {
+	src := newManagedFakeSource(func(ctx context.Context) (Change, error) {
+		<-ctx.Done()
+		return Change{}, ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ExportChanges(ctx, src, cursor.Checkpoint{}, bytes.NewBuffer(nil), ExportJSONLines); err != nil {
+		t.Fatalf("unexpected %v", err)
+	}
+	if len(src.committed) != 0 {
+		t.Fatalf("unexpected %v", src.committed)
+	}
+	if err := ExportChanges(ctx, src, cursor.Checkpoint{}, bytes.NewBuffer(nil), ExportFormat(-1)); !errors.Is(err, ErrUnknownExportFormat) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/replicase/pgcapture/pkg/sql"
+	"github.com/sirupsen/logrus"
+)
+
+// openTx is a transaction open on the server, which holds the restart_lsn of the slots from advancing past its start,
+// so that the server retains the WAL since then even if all the committed transactions are acknowledged
+type openTx struct {
+	pid int32
+	xid uint32
+	age time.Duration
+}
+
+// queryOldestOpenTx returns the oldest transaction having an xid on the server, or false if there is none
+func queryOldestOpenTx(ctx context.Context, conn *pgx.Conn) (tx openTx, ok bool, err error) {
+	var seconds float64
+	if err = conn.QueryRow(ctx, sql.QueryOldestOpenTransaction).Scan(&tx.pid, &tx.xid, &seconds); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return tx, false, nil
+		}
+		return tx, false, err
+	}
+	tx.age = time.Duration(seconds * float64(time.Second))
+	return tx, true, nil
+}
+
+// checkOpenTransactions reports the age of the oldest transaction open on the server,
+// and calls the OnLongTransaction once for each transaction older than the LongTransactionThreshold
+func (p *PGXSource) checkOpenTransactions(ctx context.Context) {
+	if p.LongTransactionThreshold <= 0 || p.queryOldestTx == nil {
+		return
+	}
+	tx, ok, err := p.queryOldestTx(ctx)
+	if err != nil {
+		p.log.WithError(err).Warn("failed to query the oldest open transaction")
+		return
+	}
+	p.metrics().Gauge(MetricOldestOpenTxAge, tx.age.Seconds(), map[string]string{"slot": p.ReplSlot})
+	if !ok || tx.age <= p.LongTransactionThreshold || tx.xid == p.alertedXid {
+		return
+	}
+	p.alertedXid = tx.xid
+	p.log.WithFields(logrus.Fields{"ReplSlot": p.ReplSlot, "PID": tx.pid, "Xid": tx.xid, "Age": tx.age}).Warn("the oldest open transaction exceeds the threshold")
+	if p.OnLongTransaction != nil {
+		p.OnLongTransaction(tx.pid, tx.xid, tx.age)
+	}
+}
@@ -0,0 +1,110 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/replicase/pgcapture/internal/test"
+)
+
+func TestQueryOldestOpenTx(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	writer, err := pgx.Connect(ctx, test.GetPostgresURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close(ctx)
+
+	tx, err := writer.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	var xid uint32
+	if err = tx.QueryRow(ctx, "select txid_current()::text::bigint").Scan(&xid); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	oldest, ok, err := queryOldestOpenTx(ctx, conn)
+	if err != nil || !ok {
+		t.Fatalf("unexpected %v %v", ok, err)
+	}
+	if oldest.pid != int32(writer.PgConn().PID()) || oldest.xid != xid || oldest.age < 100*time.Millisecond {
+		t.Fatalf("unexpected %v, expected the transaction %v", oldest, xid)
+	}
+}
+
+func TestPGXSource_LongTransaction(t *testing.T) {
+	src := newFakePGXSource(&fakeReplConn{})
+	src.LongTransactionThreshold = time.Minute
+	metrics := &memoryMetricsSink{}
+	src.Metrics = metrics
+	type alert struct {
+		pid int32
+		xid uint32
+		age time.Duration
+	}
+	var alerts []alert
+	src.OnLongTransaction = func(pid int32, xid uint32, age time.Duration) {
+		alerts = append(alerts, alert{pid: pid, xid: xid, age: age})
+	}
+	// the transaction left open on the server, which is not received since it is not committed yet
+	var oldest *openTx
+	var queryErr error
+	src.queryOldestTx = func(ctx context.Context) (openTx, bool, error) {
+		if oldest == nil {
+			return openTx{}, false, queryErr
+		}
+		return *oldest, true, queryErr
+	}
+
+	age := func() float64 {
+		metrics.calls = nil
+		src.checkOpenTransactions(context.Background())
+		total, _ := metrics.sum("gauge", MetricOldestOpenTxAge, map[string]string{"slot": TestSlot})
+		return total
+	}
+	if a := age(); a != 0 {
+		t.Fatalf("unexpected age %v", a)
+	}
+	oldest = &openTx{pid: 10, xid: 700, age: time.Second}
+	if a := age(); a != 1 || len(alerts) != 0 {
+		t.Fatalf("unexpected age %v %v", a, alerts)
+	}
+	// the callback is called once for the same transaction
+	oldest.age = time.Hour
+	if a := age(); a != 3600 || age() != 3600 {
+		t.Fatalf("unexpected age %v", a)
+	}
+	if len(alerts) != 1 || alerts[0] != (alert{pid: 10, xid: 700, age: time.Hour}) {
+		t.Fatalf("unexpected alerts %v", alerts)
+	}
+	oldest = &openTx{pid: 11, xid: 800, age: 2 * time.Minute}
+	if a := age(); a != 120 || len(alerts) != 2 || alerts[1].xid != 800 {
+		t.Fatalf("unexpected age %v %v", a, alerts)
+	}
+
+	// the failed query is skipped until the next check
+	queryErr = errors.New("permission denied")
+	if a := age(); a != 0 || len(alerts) != 2 {
+		t.Fatalf("unexpected age %v %v", a, alerts)
+	}
+}
+
+func TestPGXSource_LongTransactionDisabled(t *testing.T) {
+	src := newFakePGXSource(&fakeReplConn{})
+	src.queryOldestTx = func(ctx context.Context) (openTx, bool, error) {
+		t.Fatal("the open transactions should not be queried")
+		return openTx{}, false, nil
+	}
+	src.checkOpenTransactions(context.Background())
+}
@@ -16,6 +16,36 @@ import (
 type Change struct {
 	Checkpoint cursor.Checkpoint
 	Message    *pb.Message
+
+	// The WAL positions below are only set by the PGXSource. Only the Checkpoint.LSN is safe to be acked,
+	// which is the commit LSN of the transaction, because acking the other positions in the middle of
+	// a transaction makes the server skip the rest of it after restarted.
+
+	// WALStart is the starting position of the XLogData carrying the message
+	WALStart uint64
+	// ServerWALEnd is the current end of WAL on the server when the XLogData is sent
+	ServerWALEnd uint64
+	// CommitLSN is the commit LSN of the transaction containing the message, which is taken from BEGIN and COMMIT
+	CommitLSN uint64
+	// EndLSN is the end of the commit record, which is only known at the COMMIT,
+	// and is set on all the changes of the transaction with the TransactionalDelivery, which delivers them after the COMMIT
+	EndLSN uint64
+	// Latency is the time from the commit of the transaction to the delivery of the message,
+	// which is only set by the PGXSource with the MeasureLatency
+	Latency time.Duration
+	// ApplicationName is the application_name of the writer of the transaction, which is best-effort only
+	// and set by the PGXSource with the ApplicationNameFunc, and is empty if unknown
+	ApplicationName string
+	// SchemaFingerprint is the fingerprint of the layout of the relation, which is only set on the first change of each
+	// relation since the start or since its layout changed, by the PGXSource with the SchemaFingerprint
+	SchemaFingerprint string
+	// SystemColumns are the xmin, xmax, cmin and cmax of the row change sent by the plugin, keyed by the names,
+	// which are only set by the PGXSource with the SystemColumns
+	SystemColumns map[string]uint32
+
+	// commitTime is the commit time of the transaction in microseconds since 2000-01-01, which the Latency is
+	// measured from at the delivery, and is only set with the MeasureLatency
+	commitTime uint64
 }
 
 type Source interface {
@@ -25,16 +55,36 @@ type Source interface {
 	Stop() error
 }
 
+// DurableCommitter is the Source distinguishing the checkpoints durably persisted by the sink from the delivered ones
+type DurableCommitter interface {
+	CommitDurable(cp cursor.Checkpoint)
+}
+
+// TwoPhaseCommitter is the Source advancing only to the prepared checkpoint confirmed after the external commit of the consumer
+type TwoPhaseCommitter interface {
+	PrepareCommit(cp cursor.Checkpoint)
+	ConfirmCommit()
+}
+
 type RequeueSource interface {
 	Source
 	Requeue(cp cursor.Checkpoint, reason string)
 }
 
+// ErrConsumerAbandoned means the consumer stops receiving the changes, like panicked or disconnected,
+// while the source blocks on sending a change for longer than the ConsumerTimeout
+var ErrConsumerAbandoned = errors.New("the consumer stops receiving changes")
+
 type BaseSource struct {
 	ReadTimeout time.Duration
 
+	// ConsumerTimeout stops the source with the ErrConsumerAbandoned if a change can not be sent to the consumer within it,
+	// instead of blocking forever. The source blocked on sending is always released by the Stop. It is disabled if zero.
+	ConsumerTimeout time.Duration
+
 	state   int64
 	stopped chan struct{}
+	quit    chan struct{}
 
 	err atomic.Value
 }
@@ -53,6 +103,7 @@ func (b *BaseSource) Stop() error {
 		for !atomic.CompareAndSwapInt64(&b.state, 2, 3) {
 			runtime.Gosched()
 		}
+		close(b.quit)
 		fallthrough
 	case 3:
 		<-b.stopped
@@ -73,6 +124,7 @@ func (b *BaseSource) capture(readFn ReadFn, flushFn FlushFn) (chan Change, error
 	}
 
 	b.stopped = make(chan struct{})
+	b.quit = make(chan struct{})
 	changes := make(chan Change, 1000)
 
 	atomic.StoreInt64(&b.state, 2)
@@ -101,13 +153,41 @@ func (b *BaseSource) capture(readFn ReadFn, flushFn FlushFn) (chan Change, error
 				return
 			}
 			if change.Message != nil {
-				changes <- change
+				if err = b.send(changes, change); err != nil {
+					b.err.Store(err)
+					return
+				}
+				if atomic.LoadInt64(&b.state) != 2 {
+					return
+				}
 			}
 		}
 	}()
 	return changes, nil
 }
 
+// send blocks until the change is received by the consumer, the source is stopped, or the ConsumerTimeout exceeds
+func (b *BaseSource) send(changes chan Change, change Change) error {
+	select {
+	case changes <- change:
+		return nil
+	default:
+	}
+	var timeout <-chan time.Time
+	if b.ConsumerTimeout > 0 {
+		timer := time.NewTimer(b.ConsumerTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case changes <- change:
+	case <-b.quit:
+	case <-timeout:
+		return fmt.Errorf("%w: blocked for %v", ErrConsumerAbandoned, b.ConsumerTimeout)
+	}
+	return nil
+}
+
 type CaptureFn func(changes chan Change) error
 type FlushFn func()
 type ReadFn func(ctx context.Context) (Change, error)
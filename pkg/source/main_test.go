@@ -3,6 +3,7 @@ package source
 import (
 	"context"
 	"errors"
+	"runtime"
 	"testing"
 	"time"
 
@@ -158,3 +159,45 @@ func TestBaseSink_CommitPanic(t *testing.T) {
 	s.Commit(cursor.Checkpoint{})
 	t.Fatal("should panic")
 }
+
+func TestBaseSource_ConsumerAbandoned(t *testing.T) {
+	for _, timeout := range []time.Duration{0, 50 * time.Millisecond} {
+		before := runtime.NumGoroutine()
+		source := source{
+			BaseSource: BaseSource{ReadTimeout: time.Second, ConsumerTimeout: timeout},
+			ReadFn: func(ctx context.Context) (Change, error) {
+				return Change{Message: &pb.Message{}}, nil
+			},
+		}
+		changes, _ := source.Capture(cursor.Checkpoint{})
+		// the consumer receives a few changes and then abandons the channel, which is filled up
+		for i := 0; i < 3; i++ {
+			<-changes
+		}
+		if timeout == 0 {
+			// the source blocked on sending is still released by the Stop
+			time.Sleep(50 * time.Millisecond)
+			if err := source.Stop(); err != nil {
+				t.Fatalf("unexpected %v", err)
+			}
+		} else {
+			select {
+			case <-source.Flushed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the source should clean up after the consumer timeout")
+			}
+			if err := source.Stop(); !errors.Is(err, ErrConsumerAbandoned) {
+				t.Fatalf("unexpected %v", err)
+			}
+		}
+		if _, more := <-source.Flushed; more {
+			t.Fatal("clean func should be called once")
+		}
+		for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > before {
+			t.Fatalf("goroutines leaked %v > %v", n, before)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/replicase/pgcapture/pkg/cursor"
+)
+
+var (
+	ErrSourceExists   = errors.New("source already exists")
+	ErrSourceNotFound = errors.New("source not found")
+)
+
+// Manager supervises multiple sources, for example one PGXSource per database,
+// and each source keeps its own slot and checkpoint.
+type Manager struct {
+	mu      sync.Mutex
+	sources map[string]*managedSource
+}
+
+type managedSource struct {
+	src     Source
+	cp      cursor.Checkpoint
+	changes chan Change
+	running bool
+	err     error
+}
+
+type SourceHealth struct {
+	Running bool
+	Err     error
+}
+
+type Health struct {
+	Healthy bool
+	Sources map[string]SourceHealth
+}
+
+func NewManager() *Manager {
+	return &Manager{sources: make(map[string]*managedSource)}
+}
+
+// Add registers the source with the checkpoint to start capturing from
+func (m *Manager) Add(name string, src Source, cp cursor.Checkpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sources[name]; ok {
+		return fmt.Errorf("%s %w", name, ErrSourceExists)
+	}
+	m.sources[name] = &managedSource{src: src, cp: cp}
+	return nil
+}
+
+func (m *Manager) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.sources))
+	for name := range m.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start starts capturing the named source, and returns the same changes channel if it is already running
+func (m *Manager) Start(name string) (chan Change, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms, ok := m.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("%s %w", name, ErrSourceNotFound)
+	}
+	if ms.running {
+		return ms.changes, nil
+	}
+	changes, err := ms.src.Capture(ms.cp)
+	if err != nil {
+		ms.err = err
+		return nil, err
+	}
+	ms.changes, ms.running, ms.err = changes, true, nil
+	return changes, nil
+}
+
+// StartAll starts all the sources, and stops the started ones if any of them fails to start
+func (m *Manager) StartAll() (map[string]chan Change, error) {
+	all := make(map[string]chan Change)
+	for _, name := range m.Names() {
+		changes, err := m.Start(name)
+		if err != nil {
+			m.StopAll()
+			return nil, fmt.Errorf("start source %s: %w", name, err)
+		}
+		all[name] = changes
+	}
+	return all, nil
+}
+
+func (m *Manager) Commit(name string, cp cursor.Checkpoint) error {
+	m.mu.Lock()
+	ms, ok := m.sources[name]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrSourceNotFound)
+	}
+	ms.src.Commit(cp)
+	return nil
+}
+
+func (m *Manager) Stop(name string) error {
+	m.mu.Lock()
+	ms, ok := m.sources[name]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrSourceNotFound)
+	}
+	return m.stop(ms)
+}
+
+func (m *Manager) stop(ms *managedSource) error {
+	m.mu.Lock()
+	running := ms.running
+	ms.running = false
+	m.mu.Unlock()
+	if !running {
+		return nil
+	}
+	err := ms.src.Stop()
+	m.mu.Lock()
+	ms.err = err
+	m.mu.Unlock()
+	return err
+}
+
+// StopAll stops all the running sources and returns their joined errors
+func (m *Manager) StopAll() error {
+	var errs []error
+	for _, name := range m.Names() {
+		if err := m.Stop(name); err != nil {
+			errs = append(errs, fmt.Errorf("stop source %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Health reports each source, and the manager is healthy only if all the sources are running without error
+func (m *Manager) Health() Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := Health{Healthy: true, Sources: make(map[string]SourceHealth, len(m.sources))}
+	for name, ms := range m.sources {
+		sh := SourceHealth{Running: ms.running, Err: ms.err}
+		if sh.Running {
+			sh.Err = ms.src.Error()
+		}
+		if !sh.Running || sh.Err != nil {
+			h.Healthy = false
+		}
+		h.Sources[name] = sh
+	}
+	return h
+}
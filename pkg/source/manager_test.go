@@ -0,0 +1,108 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/replicase/pgcapture/pkg/cursor"
+)
+
+func TestManager(t *testing.T) {
+	fail := errors.New("fail")
+	fails := make(chan struct{})
+
+	src1 := newManagedFakeSource(func(ctx context.Context) (Change, error) {
+		<-ctx.Done()
+		return Change{}, ctx.Err()
+	})
+	src2 := newManagedFakeSource(func(ctx context.Context) (Change, error) {
+		select {
+		case <-fails:
+			return Change{}, fail
+		case <-ctx.Done():
+			return Change{}, ctx.Err()
+		}
+	})
+
+	m := NewManager()
+	if err := m.Add("db1", src1, cursor.Checkpoint{LSN: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add("db2", src2, cursor.Checkpoint{LSN: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add("db2", src2, cursor.Checkpoint{}); !errors.Is(err, ErrSourceExists) {
+		t.Fatalf("unexpected %v", err)
+	}
+	if h := m.Health(); h.Healthy {
+		t.Fatalf("unexpected health before started %v", h)
+	}
+
+	all, err := m.StartAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || src1.cp.LSN != 1 || src2.cp.LSN != 2 {
+		t.Fatalf("unexpected started sources %v %v %v", all, src1.cp, src2.cp)
+	}
+	if h := m.Health(); !h.Healthy || !h.Sources["db1"].Running || !h.Sources["db2"].Running {
+		t.Fatalf("unexpected health %v", h)
+	}
+
+	if err = m.Commit("db1", cursor.Checkpoint{LSN: 10}); err != nil || len(src1.committed) != 1 || len(src2.committed) != 0 {
+		t.Fatalf("unexpected commit %v %v %v", err, src1.committed, src2.committed)
+	}
+
+	// the failure of db2 should not affect db1
+	close(fails)
+	for range all["db2"] {
+	}
+	h := m.Health()
+	if h.Healthy || !errors.Is(h.Sources["db2"].Err, fail) || h.Sources["db1"].Err != nil || !h.Sources["db1"].Running {
+		t.Fatalf("unexpected health %v", h)
+	}
+
+	if err = m.Stop("db1"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, more := <-all["db1"]:
+		if more {
+			t.Fatal("unexpected change")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("db1 should be stopped")
+	}
+	if h := m.Health(); h.Sources["db1"].Running || h.Sources["db1"].Err != nil {
+		t.Fatalf("unexpected health %v", h)
+	}
+
+	if err = m.StopAll(); !errors.Is(err, fail) {
+		t.Fatalf("unexpected %v", err)
+	}
+	if err = m.Stop("db3"); !errors.Is(err, ErrSourceNotFound) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func newManagedFakeSource(readFn ReadFn) *managedFakeSource {
+	return &managedFakeSource{BaseSource: BaseSource{ReadTimeout: 50 * time.Millisecond}, readFn: readFn}
+}
+
+type managedFakeSource struct {
+	BaseSource
+	readFn    ReadFn
+	cp        cursor.Checkpoint
+	committed []cursor.Checkpoint
+}
+
+func (s *managedFakeSource) Capture(cp cursor.Checkpoint) (chan Change, error) {
+	s.cp = cp
+	return s.BaseSource.capture(s.readFn, func() {})
+}
+
+func (s *managedFakeSource) Commit(cp cursor.Checkpoint) {
+	s.committed = append(s.committed, cp)
+}
@@ -0,0 +1,353 @@
+package source
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	MetricMessages             = "messages_total"
+	MetricMessageBytes         = "message_bytes"
+	MetricSuppressedDuplicates = "suppressed_duplicates_total"
+	MetricCommittedLSN         = "committed_lsn"
+	MetricAckFailures          = "ack_failures_total"
+	MetricDurableLSN           = "durable_lsn"
+	MetricDeliveryLatency      = "delivery_latency_seconds"
+	MetricReconnects           = "reconnects_total"
+	MetricOldestOpenTxAge      = "oldest_open_tx_age_seconds"
+)
+
+// MetricsSink receives the metrics emitted by the sources
+type MetricsSink interface {
+	Counter(name string, delta float64, labels map[string]string)
+	Gauge(name string, value float64, labels map[string]string)
+	Histogram(name string, value float64, labels map[string]string)
+}
+
+// MetricsBinder is implemented by the MetricsSinks binding the labels of a metric in advance, so that the metrics of
+// every message are recorded without looking up the collectors by the labels each time
+type MetricsBinder interface {
+	BindCounter(name string, labels map[string]string) func(delta float64)
+	BindHistogram(name string, labels map[string]string) func(value float64)
+}
+
+// bindCounter binds the counter by the MetricsBinder of the sink, or calls the sink with the same labels
+func bindCounter(sink MetricsSink, name string, labels map[string]string) func(delta float64) {
+	if b, ok := sink.(MetricsBinder); ok {
+		return b.BindCounter(name, labels)
+	}
+	return func(delta float64) { sink.Counter(name, delta, labels) }
+}
+
+// bindHistogram binds the histogram by the MetricsBinder of the sink, or calls the sink with the same labels
+func bindHistogram(sink MetricsSink, name string, labels map[string]string) func(value float64) {
+	if b, ok := sink.(MetricsBinder); ok {
+		return b.BindHistogram(name, labels)
+	}
+	return func(value float64) { sink.Histogram(name, value, labels) }
+}
+
+// messageMetrics are the metrics recorded for every message, which are bound to the slot and the message types once
+type messageMetrics struct {
+	messages     map[string]func(float64)
+	latency      map[string]func(float64)
+	messageBytes func(float64)
+}
+
+// messageTypes are the values of the "type" label of the messageMetrics
+var messageTypes = []string{"begin", "change", "commit", "digest"}
+
+func bindMessageMetrics(sink MetricsSink, slot string) *messageMetrics {
+	m := &messageMetrics{
+		messages:     make(map[string]func(float64), len(messageTypes)),
+		latency:      make(map[string]func(float64), len(messageTypes)),
+		messageBytes: bindHistogram(sink, MetricMessageBytes, map[string]string{"slot": slot}),
+	}
+	for _, t := range messageTypes {
+		labels := map[string]string{"slot": slot, "type": t}
+		m.messages[t] = bindCounter(sink, MetricMessages, labels)
+		m.latency[t] = bindHistogram(sink, MetricDeliveryLatency, labels)
+	}
+	return m
+}
+
+var (
+	defaultMetricsSink     MetricsSink
+	defaultMetricsSinkOnce sync.Once
+)
+
+// DefaultMetricsSink is the sink registering metrics to the prometheus.DefaultRegisterer
+func DefaultMetricsSink() MetricsSink {
+	defaultMetricsSinkOnce.Do(func() {
+		defaultMetricsSink = NewPrometheusMetricsSink(prometheus.DefaultRegisterer)
+	})
+	return defaultMetricsSink
+}
+
+var metricHelps = map[string]string{
+	MetricMessages:             "The number of messages delivered from the source",
+	MetricMessageBytes:         "The size of the messages received from the source",
+	MetricSuppressedDuplicates: "The number of messages dropped because they were already delivered before the resume checkpoint",
+	MetricCommittedLSN:         "The latest LSN committed back to the source",
+	MetricAckFailures:          "The number of failures of committing LSN back to the source, the transient ones are retried",
+	MetricDurableLSN:           "The latest LSN durably persisted by the sink, which the slot is advanced to",
+	MetricDeliveryLatency:      "The time between the commit of the transaction and the delivery of its changes",
+	MetricReconnects:           "The number of attempts to re-establish the failed replication",
+	MetricOldestOpenTxAge:      "The age of the oldest transaction open on the server, which holds the slot from advancing past its start",
+}
+
+// metricBuckets are the histogram buckets other than the default ones for the sizes in bytes
+var metricBuckets = map[string][]float64{
+	MetricDeliveryLatency: prometheus.ExponentialBuckets(0.001, 2, 16),
+}
+
+// PrometheusMetricsSink creates the prometheus collectors on their first use,
+// and the label names of a metric are fixed by its first use.
+type PrometheusMetricsSink struct {
+	registerer prometheus.Registerer
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+func NewPrometheusMetricsSink(registerer prometheus.Registerer) *PrometheusMetricsSink {
+	return &PrometheusMetricsSink{
+		registerer: registerer,
+		counters:   make(map[string]*prometheus.CounterVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+	}
+}
+
+func (s *PrometheusMetricsSink) Counter(name string, delta float64, labels map[string]string) {
+	if c, err := s.counterVec(name, labels).GetMetricWith(labels); err == nil {
+		c.Add(delta)
+	}
+}
+
+func (s *PrometheusMetricsSink) Gauge(name string, value float64, labels map[string]string) {
+	s.mu.Lock()
+	vec, ok := s.gauges[name]
+	if !ok {
+		vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pgcapture",
+			Subsystem: "source",
+			Name:      name,
+			Help:      metricHelps[name],
+		}, labelNames(labels))
+		vec = s.register(vec).(*prometheus.GaugeVec)
+		s.gauges[name] = vec
+	}
+	s.mu.Unlock()
+	if g, err := vec.GetMetricWith(labels); err == nil {
+		g.Set(value)
+	}
+}
+
+func (s *PrometheusMetricsSink) Histogram(name string, value float64, labels map[string]string) {
+	if h, err := s.histogramVec(name, labels).GetMetricWith(labels); err == nil {
+		h.Observe(value)
+	}
+}
+
+// BindCounter returns the counter of the labels, which drops the values if the labels mismatch the metric
+func (s *PrometheusMetricsSink) BindCounter(name string, labels map[string]string) func(delta float64) {
+	c, err := s.counterVec(name, labels).GetMetricWith(labels)
+	if err != nil {
+		return func(float64) {}
+	}
+	return c.Add
+}
+
+// BindHistogram returns the histogram of the labels, which drops the values if the labels mismatch the metric
+func (s *PrometheusMetricsSink) BindHistogram(name string, labels map[string]string) func(value float64) {
+	h, err := s.histogramVec(name, labels).GetMetricWith(labels)
+	if err != nil {
+		return func(float64) {}
+	}
+	return h.Observe
+}
+
+func (s *PrometheusMetricsSink) counterVec(name string, labels map[string]string) *prometheus.CounterVec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vec, ok := s.counters[name]
+	if !ok {
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pgcapture",
+			Subsystem: "source",
+			Name:      name,
+			Help:      metricHelps[name],
+		}, labelNames(labels))
+		vec = s.register(vec).(*prometheus.CounterVec)
+		s.counters[name] = vec
+	}
+	return vec
+}
+
+func (s *PrometheusMetricsSink) histogramVec(name string, labels map[string]string) *prometheus.HistogramVec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vec, ok := s.histograms[name]
+	if !ok {
+		vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pgcapture",
+			Subsystem: "source",
+			Name:      name,
+			Help:      metricHelps[name],
+			Buckets:   buckets(name),
+		}, labelNames(labels))
+		vec = s.register(vec).(*prometheus.HistogramVec)
+		s.histograms[name] = vec
+	}
+	return vec
+}
+
+func buckets(name string) []float64 {
+	if b, ok := metricBuckets[name]; ok {
+		return b
+	}
+	return prometheus.ExponentialBuckets(64, 4, 10)
+}
+
+// register returns the existing collector if it has been registered, for example by another sink on the same registerer
+func (s *PrometheusMetricsSink) register(c prometheus.Collector) prometheus.Collector {
+	if s.registerer == nil {
+		return c
+	}
+	if err := s.registerer.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+	}
+	return c
+}
+
+func labelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OTelMetricsSink emits the metrics with the OpenTelemetry metric.Meter.
+// The gauges are recorded with Float64UpDownCounter, by adding the difference from the previous value.
+type OTelMetricsSink struct {
+	meter      metric.Meter
+	mu         sync.Mutex
+	counters   map[string]metric.Float64Counter
+	gauges     map[string]metric.Float64UpDownCounter
+	histograms map[string]metric.Float64Histogram
+	values     map[gaugeKey]float64
+}
+
+type gaugeKey struct {
+	name  string
+	attrs attribute.Distinct
+}
+
+func NewOTelMetricsSink(meter metric.Meter) *OTelMetricsSink {
+	return &OTelMetricsSink{
+		meter:      meter,
+		counters:   make(map[string]metric.Float64Counter),
+		gauges:     make(map[string]metric.Float64UpDownCounter),
+		histograms: make(map[string]metric.Float64Histogram),
+		values:     make(map[gaugeKey]float64),
+	}
+}
+
+func (s *OTelMetricsSink) Counter(name string, delta float64, labels map[string]string) {
+	if c, ok := s.counter(name); ok {
+		c.Add(context.Background(), delta, metric.WithAttributes(attributes(labels)...))
+	}
+}
+
+func (s *OTelMetricsSink) Gauge(name string, value float64, labels map[string]string) {
+	s.mu.Lock()
+	g, ok := s.gauges[name]
+	if !ok {
+		var err error
+		if g, err = s.meter.Float64UpDownCounter("pgcapture.source."+name, metric.WithDescription(metricHelps[name])); err != nil {
+			s.mu.Unlock()
+			return
+		}
+		s.gauges[name] = g
+	}
+	attrs := attributes(labels)
+	set := attribute.NewSet(attrs...)
+	key := gaugeKey{name: name, attrs: set.Equivalent()}
+	delta := value - s.values[key]
+	s.values[key] = value
+	s.mu.Unlock()
+	g.Add(context.Background(), delta, metric.WithAttributes(attrs...))
+}
+
+func (s *OTelMetricsSink) Histogram(name string, value float64, labels map[string]string) {
+	if h, ok := s.histogram(name); ok {
+		h.Record(context.Background(), value, metric.WithAttributes(attributes(labels)...))
+	}
+}
+
+// BindCounter returns the counter with the attributes of the labels built once
+func (s *OTelMetricsSink) BindCounter(name string, labels map[string]string) func(delta float64) {
+	c, ok := s.counter(name)
+	if !ok {
+		return func(float64) {}
+	}
+	opt := metric.WithAttributeSet(attribute.NewSet(attributes(labels)...))
+	return func(delta float64) { c.Add(context.Background(), delta, opt) }
+}
+
+// BindHistogram returns the histogram with the attributes of the labels built once
+func (s *OTelMetricsSink) BindHistogram(name string, labels map[string]string) func(value float64) {
+	h, ok := s.histogram(name)
+	if !ok {
+		return func(float64) {}
+	}
+	opt := metric.WithAttributeSet(attribute.NewSet(attributes(labels)...))
+	return func(value float64) { h.Record(context.Background(), value, opt) }
+}
+
+func (s *OTelMetricsSink) counter(name string) (metric.Float64Counter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[name]
+	if !ok {
+		var err error
+		if c, err = s.meter.Float64Counter("pgcapture.source."+name, metric.WithDescription(metricHelps[name])); err != nil {
+			return nil, false
+		}
+		s.counters[name] = c
+	}
+	return c, true
+}
+
+func (s *OTelMetricsSink) histogram(name string) (metric.Float64Histogram, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.histograms[name]
+	if !ok {
+		var err error
+		if h, err = s.meter.Float64Histogram("pgcapture.source."+name, metric.WithDescription(metricHelps[name])); err != nil {
+			return nil, false
+		}
+		s.histograms[name] = h
+	}
+	return h, true
+}
+
+func attributes(labels map[string]string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(labels))
+	for _, k := range labelNames(labels) {
+		attrs = append(attrs, attribute.String(k, labels[k]))
+	}
+	return attrs
+}
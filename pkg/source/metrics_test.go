@@ -0,0 +1,110 @@
+package source
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusMetricsSink(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	sink := NewPrometheusMetricsSink(registry)
+	sink.Counter(MetricMessages, 1, map[string]string{"slot": "s1", "type": "begin"})
+	sink.Counter(MetricMessages, 2, map[string]string{"type": "begin", "slot": "s1"})
+	sink.Gauge(MetricCommittedLSN, 10, map[string]string{"slot": "s1"})
+	sink.Gauge(MetricCommittedLSN, 5, map[string]string{"slot": "s1"})
+	sink.Histogram(MetricMessageBytes, 100, map[string]string{"slot": "s1"})
+
+	if v := testutil.ToFloat64(sink.counters[MetricMessages].WithLabelValues("s1", "begin")); v != 3 {
+		t.Fatalf("unexpected counter %v", v)
+	}
+	if v := testutil.ToFloat64(sink.gauges[MetricCommittedLSN].WithLabelValues("s1")); v != 5 {
+		t.Fatalf("unexpected gauge %v", v)
+	}
+	if n, err := testutil.GatherAndCount(registry); err != nil || n != 3 {
+		t.Fatalf("unexpected registered metrics %v %v", n, err)
+	}
+
+	// another sink on the same registerer should reuse the registered collectors
+	NewPrometheusMetricsSink(registry).Counter(MetricMessages, 1, map[string]string{"slot": "s1", "type": "begin"})
+	if v := testutil.ToFloat64(sink.counters[MetricMessages].WithLabelValues("s1", "begin")); v != 4 {
+		t.Fatalf("unexpected counter %v", v)
+	}
+}
+
+func TestPrometheusMetricsSink_Bind(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	sink := NewPrometheusMetricsSink(registry)
+	metrics := bindMessageMetrics(sink, "s1")
+	metrics.messages["begin"](1)
+	sink.Counter(MetricMessages, 2, map[string]string{"slot": "s1", "type": "begin"})
+	metrics.messageBytes(100)
+	metrics.latency["change"](0.5)
+
+	if v := testutil.ToFloat64(sink.counters[MetricMessages].WithLabelValues("s1", "begin")); v != 3 {
+		t.Fatalf("unexpected counter %v", v)
+	}
+	if n := testutil.CollectAndCount(sink.histograms[MetricDeliveryLatency]); n != len(messageTypes) {
+		t.Fatalf("unexpected bound histograms %v", n)
+	}
+	// the bound metrics are recorded without the lookups by the labels
+	if n := testing.AllocsPerRun(100, func() {
+		metrics.messages["change"](1)
+		metrics.messageBytes(100)
+	}); n != 0 {
+		t.Fatalf("unexpected allocations %v", n)
+	}
+
+	// the sinks without the MetricsBinder are called with the same labels
+	memory := &memoryMetricsSink{}
+	bindMessageMetrics(memory, "s1").messages["commit"](1)
+	if total, n := memory.sum("counter", MetricMessages, map[string]string{"slot": "s1", "type": "commit"}); total != 1 || n != 1 {
+		t.Fatalf("unexpected %v %v", total, n)
+	}
+}
+
+type metricCall struct {
+	kind   string
+	name   string
+	value  float64
+	labels map[string]string
+}
+
+type memoryMetricsSink struct {
+	mu    sync.Mutex
+	calls []metricCall
+}
+
+func (s *memoryMetricsSink) Counter(name string, delta float64, labels map[string]string) {
+	s.record(metricCall{kind: "counter", name: name, value: delta, labels: labels})
+}
+
+func (s *memoryMetricsSink) Gauge(name string, value float64, labels map[string]string) {
+	s.record(metricCall{kind: "gauge", name: name, value: value, labels: labels})
+}
+
+func (s *memoryMetricsSink) Histogram(name string, value float64, labels map[string]string) {
+	s.record(metricCall{kind: "histogram", name: name, value: value, labels: labels})
+}
+
+func (s *memoryMetricsSink) record(call metricCall) {
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	s.mu.Unlock()
+}
+
+// sum returns the total value and the number of the matched calls
+func (s *memoryMetricsSink) sum(kind, name string, labels map[string]string) (total float64, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.calls {
+		if c.kind == kind && c.name == name && reflect.DeepEqual(c.labels, labels) {
+			total += c.value
+			n++
+		}
+	}
+	return
+}
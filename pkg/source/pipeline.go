@@ -0,0 +1,111 @@
+package source
+
+import (
+	"sync"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/replicase/pgcapture/pkg/decode"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+const DefaultDecodeBufferSize = 256
+
+type decodeItem struct {
+	xld  pglogrepl.XLogData
+	m    *pb.Message
+	err  error
+	done chan struct{}
+}
+
+func (i *decodeItem) decoded() bool {
+	select {
+	case <-i.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// decodePipeline decodes the WAL data by a pool of workers, and the results are taken in the order of submission.
+// The queue of the submitted items is bounded by the size, and it is not safe for concurrent submission.
+// The decoders not implementing the decode.StatefulDecoder are assumed to be safe for concurrent use.
+type decodePipeline struct {
+	decoder decode.Decoder
+	work    chan *decodeItem
+	queue   []*decodeItem
+	size    int
+	running sync.WaitGroup
+	workers sync.WaitGroup
+}
+
+func newDecodePipeline(decoder decode.Decoder, workers, size int) *decodePipeline {
+	d := &decodePipeline{decoder: decoder, work: make(chan *decodeItem, size), size: size}
+	d.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer d.workers.Done()
+			for item := range d.work {
+				item.m, item.err = d.decoder.Decode(item.xld.WALData)
+				close(item.done)
+				d.running.Done()
+			}
+		}()
+	}
+	return d
+}
+
+func (d *decodePipeline) full() bool {
+	return len(d.queue) >= d.size
+}
+
+// head returns the earliest submitted item, or nil if there is none
+func (d *decodePipeline) head() *decodeItem {
+	if len(d.queue) == 0 {
+		return nil
+	}
+	return d.queue[0]
+}
+
+func (d *decodePipeline) pop() {
+	d.queue[0] = nil
+	d.queue = d.queue[1:]
+}
+
+func (d *decodePipeline) submit(xld pglogrepl.XLogData) {
+	item := &decodeItem{xld: xld, done: make(chan struct{})}
+	d.queue = append(d.queue, item)
+	d.dispatch(item)
+}
+
+// dispatch hands the item to the workers, or decodes it after all the in-flight items are decoded
+// if it changes the state of the decoder
+func (d *decodePipeline) dispatch(item *decodeItem) {
+	if s, ok := d.decoder.(decode.StatefulDecoder); ok && s.Stateful(item.xld.WALData) {
+		d.running.Wait()
+		item.m, item.err = d.decoder.Decode(item.xld.WALData)
+		close(item.done)
+		return
+	}
+	d.running.Add(1)
+	d.work <- item
+}
+
+// redecode calls the fn after all the in-flight items are decoded, and then decodes the queued items again,
+// so that the fn can change the state read by the decoder, like refreshing the schema
+func (d *decodePipeline) redecode(fn func() error) error {
+	d.running.Wait()
+	if err := fn(); err != nil {
+		return err
+	}
+	for _, item := range d.queue {
+		item.m, item.err, item.done = nil, nil, make(chan struct{})
+		d.dispatch(item)
+	}
+	return nil
+}
+
+func (d *decodePipeline) close() {
+	close(d.work)
+	d.workers.Wait()
+	d.queue = nil
+}
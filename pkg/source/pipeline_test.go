@@ -0,0 +1,163 @@
+package source
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/replicase/pgcapture/pkg/pb"
+)
+
+// delayDecoder decodes like the fakeDecoder after the delay of each message
+type delayDecoder struct {
+	fakeDecoder
+	delay func(m *pb.Message) time.Duration
+}
+
+func (d *delayDecoder) Decode(in []byte) (*pb.Message, error) {
+	m, err := d.fakeDecoder.Decode(in)
+	if err == nil {
+		time.Sleep(d.delay(m))
+	}
+	return m, err
+}
+
+func TestPGXSource_DecodePipeline(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 30)}
+	for lsn := uint64(100); lsn <= 1000; lsn += 100 {
+		for _, m := range fakeTx(lsn) {
+			conn.messages <- xLogData(lsn, m)
+		}
+	}
+	src := newFakePGXSource(conn)
+	// the earlier transactions are decoded slower, so the later messages complete first
+	src.decoder = &delayDecoder{delay: func(m *pb.Message) time.Duration {
+		if b := m.GetBegin(); b != nil {
+			return time.Duration(1100-b.FinalLsn) * 50 * time.Microsecond
+		}
+		return 0
+	}}
+	src.DecodeWorkers = 4
+	src.DecodeBufferSize = 5
+	src.initDecodePipeline()
+
+	changes, err := src.BaseSource.capture(src.fetching, src.cleanup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gseq uint64
+	for lsn := uint64(100); lsn <= 1000; lsn += 100 {
+		tx := readTx(t, changes, 1)
+		if tx.Begin.Checkpoint.LSN != lsn {
+			t.Fatalf("unexpected %v", tx.Begin.Checkpoint)
+		}
+		for _, c := range []Change{tx.Begin, tx.Changes[0], tx.Commit} {
+			if gseq++; c.Checkpoint.GlobalSeq != gseq || c.WALStart != lsn {
+				t.Fatalf("unexpected %v", c)
+			}
+		}
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// versionDecoder tags the changes with the version of its state
+type versionDecoder struct {
+	fakeDecoder
+	version int
+}
+
+func (d *versionDecoder) Decode(in []byte) (*pb.Message, error) {
+	m, err := d.fakeDecoder.Decode(in)
+	if c := m.GetChange(); c != nil {
+		c.Table = "v" + strconv.Itoa(d.version)
+	}
+	return m, err
+}
+
+func (d *versionDecoder) Stateful(in []byte) bool {
+	m, _ := d.fakeDecoder.Decode(in)
+	return m.GetCommit() != nil
+}
+
+func TestDecodePipeline_Redecode(t *testing.T) {
+	decoder := &versionDecoder{}
+	pipeline := newDecodePipeline(decoder, 2, 10)
+	defer pipeline.close()
+
+	for _, m := range append(fakeTx(100), fakeTx(200)...) {
+		pipeline.submit(pglogrepl.XLogData{WALData: xLogData(0, m).Data[25:]})
+	}
+	// the stateful commit waits for all the previous messages
+	if head := pipeline.queue[2]; !head.decoded() || !pipeline.queue[1].decoded() {
+		t.Fatal("the messages before the stateful one should be decoded")
+	}
+	<-pipeline.head().done
+	pipeline.pop()
+	<-pipeline.head().done
+	if table := pipeline.head().m.GetChange().GetTable(); table != "v0" {
+		t.Fatalf("unexpected %v", table)
+	}
+	pipeline.pop()
+
+	var refreshed int32
+	if err := pipeline.redecode(func() error {
+		atomic.AddInt32(&refreshed, 1)
+		decoder.version++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&refreshed) != 1 || len(pipeline.queue) != 4 {
+		t.Fatalf("unexpected %v %v", refreshed, len(pipeline.queue))
+	}
+	for _, item := range pipeline.queue {
+		<-item.done
+		if c := item.m.GetChange(); c != nil && c.Table != "v1" {
+			t.Fatalf("the queued message should be decoded with the new state %v", c)
+		}
+	}
+}
+
+func BenchmarkPGXSource_DecodePipeline(b *testing.B) {
+	for _, workers := range []int{1, 8} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 100)}
+			src := newFakePGXSource(conn)
+			src.decoder = &delayDecoder{delay: func(m *pb.Message) time.Duration { return 100 * time.Microsecond }}
+			src.DecodeWorkers = workers
+			src.initDecodePipeline()
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				for lsn := uint64(1); ; lsn++ {
+					for _, m := range fakeTx(lsn) {
+						select {
+						case conn.messages <- xLogData(lsn, m):
+						case <-done:
+							return
+						}
+					}
+				}
+			}()
+			changes, err := src.BaseSource.capture(src.fetching, src.cleanup)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				<-changes
+			}
+			b.StopTimer()
+			go func() {
+				for range changes {
+				}
+			}()
+			src.Stop()
+		})
+	}
+}
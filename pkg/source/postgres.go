@@ -2,21 +2,94 @@ package source
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/jackc/pglogrepl"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/internal/fault"
 	"github.com/replicase/pgcapture/pkg/cursor"
 	"github.com/replicase/pgcapture/pkg/decode"
+	"github.com/replicase/pgcapture/pkg/pb"
 	"github.com/replicase/pgcapture/pkg/sql"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	DefaultLargeObjectSizeLimit = 1024 * 1024
+	DefaultMaxInFlightBytes     = 64 * 1024 * 1024
+)
+
+type DDLDelivery int
+
+const (
+	// DDLDeliveryEmit delivers the DDL changes and refreshes the schema, which is the default
+	DDLDeliveryEmit DDLDelivery = iota
+	// DDLDeliveryNone neither delivers the DDL changes nor refreshes the schema
+	DDLDeliveryNone
+	// DDLDeliveryRefreshOnly refreshes the schema without delivering the DDL changes
+	DDLDeliveryRefreshOnly
+	// DDLDeliveryEmitNoRefresh delivers the DDL changes without refreshing the schema
+	DDLDeliveryEmitNoRefresh
+)
+
+func (d DDLDelivery) emit() bool {
+	return d == DDLDeliveryEmit || d == DDLDeliveryEmitNoRefresh
+}
+
+func (d DDLDelivery) refresh() bool {
+	return d == DDLDeliveryEmit || d == DDLDeliveryRefreshOnly
+}
+
+type ReplicaIdentityCheck int
+
+const (
+	// ReplicaIdentityCheckNone skips checking the replica identity of tables at startup, which is the default
+	ReplicaIdentityCheckNone ReplicaIdentityCheck = iota
+	// ReplicaIdentityCheckWarn logs the tables with misconfigured replica identity at startup
+	ReplicaIdentityCheckWarn
+	// ReplicaIdentityCheckError fails the Capture with ErrReplicaIdentity if any table has misconfigured replica identity
+	ReplicaIdentityCheckError
+)
+
+// DeterministicSessionSettings fixes the text output of date, time and bytea values
+var DeterministicSessionSettings = map[string]string{
+	"DateStyle":    "ISO, MDY",
+	"bytea_output": "hex",
+}
+
+var ErrReceiveTimeout = errors.New("no message received from the server within the receive timeout")
+
+// ErrSlotInvalidated means the slot falls behind the max_slot_wal_keep_size and its WAL is removed,
+// which can not be recovered by reconnecting and requires a full resync with a new slot
+var ErrSlotInvalidated = errors.New("replication slot is invalidated")
+
+var (
+	ErrSnapshotNotExported = errors.New("no snapshot is exported by the slot creation")
+	ErrSnapshotExpired     = errors.New("the exported snapshot is expired since the replication started")
+)
+
+var ErrReplicaIdentity = errors.New("tables without usable replica identity for UPDATE and DELETE")
+
+// ErrSlotCreateTimeout means the slot creation can not establish the consistent point within the SlotCreateTimeout,
+// which waits for the transactions in progress to finish
+var ErrSlotCreateTimeout = errors.New("replication slot creation timed out waiting for the consistent point")
+
+// ErrSchemaCacheWorkers means the SchemaCacheTables is combined with the DecodeWorkers, whose concurrent loads of the
+// column types would share the setup connection with each other and with the large objects and the schema refreshes
+var ErrSchemaCacheWorkers = errors.New("SchemaCacheTables can not be used with more than one DecodeWorkers")
+
 type PGXSource struct {
 	BaseSource
 
@@ -28,17 +101,225 @@ type PGXSource struct {
 	StartLSN          string
 	DecodePlugin      string
 
+	// SlotCreateTimeout cancels the slot creation of the CreateSlot on the server and fails the Capture with the
+	// ErrSlotCreateTimeout, if the consistent point is not established within it, since the server waits for all the
+	// transactions in progress to finish, which can hang on a busy server. It is disabled if zero.
+	SlotCreateTimeout time.Duration
+
+	// PublicationTables creates the publication of the pgoutput only for the tables listed by "schema.table", instead of
+	// all tables, and the tables of an existing publication are replaced, so the tables not listed are not captured.
+	// PublicationRowFilters are the WHERE row filters of PG15+ keyed by the PublicationTables, so that the other rows are
+	// filtered by the server without being decoded, and the tables without filters are fully published. The Capture
+	// fails with the ErrPublicationTables for the filters of the tables not listed, and the ErrRowFilterUnsupported
+	// before PG15. A filter can only use the columns of the replica identity, or the server rejects the UPDATE and
+	// DELETE of the table, so the Capture fails with the ErrRowFilterColumn for such filter.
+	PublicationTables     []string
+	PublicationRowFilters map[string]string
+
+	// ExportSnapshot creates the slot by the replication connection to export the snapshot aligned with the slot,
+	// if the CreateSlot is set and the slot does not exist yet. The snapshot is only valid until the replication connection
+	// executes the next command, so the ExportSnapshot is called with its name before starting the replication, and should
+	// not return until the snapshot is imported by another session, like the first LoadDump of the dblog.PGXSourceDumper
+	// with its SnapshotName set, or the pg_dump --snapshot.
+	ExportSnapshot func(name string) error
+
+	// DerefLargeObject replaces the oid column values with the content of the referenced large objects.
+	// Large objects larger than LargeObjectSizeLimit, or already deleted, are passed through as the oid.
+	DerefLargeObject     bool
+	LargeObjectSizeLimit int
+
+	// StartupParamsFunc can rewrite the plugin arguments of the decoder before starting the replication
+	StartupParamsFunc func(defaults []string) []string
+
+	// SuppressDuplicates drops the messages re-sent by the server which are not after the resume checkpoint
+	SuppressDuplicates bool
+
+	// AlignToTransaction discards the messages received before the first BEGIN, so that the partial head transaction
+	// is not delivered when the capture starts at an LSN inside a transaction. The DDL changes discarded still refresh the schema.
+	AlignToTransaction bool
+
+	// TransactionalDelivery delivers the changes of a transaction only after its COMMIT is received.
+	// A transaction larger than MaxInFlightBytes is spilled into a temp file under the SpillDir and replayed at COMMIT,
+	// or fails the source with ErrTransactionTooLarge if the SpillDir is empty.
+	// Neither the pgoutput proto_version 1 nor the pglogical surfaces the subtransactions, because the server
+	// decodes a transaction only at its commit, and the changes of the rolled back subtransactions are already discarded.
+	TransactionalDelivery bool
+	MaxInFlightBytes      int
+	SpillDir              string
+
+	// DDLDelivery controls whether the DDL changes are delivered and whether they refresh the schema
+	DDLDelivery DDLDelivery
+
+	// ApplicationNameFunc tags the messages of each transaction with the application_name of its writer, resolved by the xid
+	// of the BEGIN, in the Change.ApplicationName. Neither plugin surfaces the application_name, and the writer session has
+	// moved on when its transaction is decoded after the commit, so it is best-effort only, for example by the writers
+	// recording their txid_current() and application_name, and the name is empty if not resolved.
+	// The row changes of the transactions tagged with the ExcludeApplicationNames are dropped, while their BEGIN, COMMIT
+	// and DDL changes are still delivered, and the transactions not resolved are never excluded.
+	ApplicationNameFunc     func(xid uint32) string
+	ExcludeApplicationNames []string
+
+	// DDLOnly drops the row changes other than the DDL changes, which are skipped by the decoder without decoding their tuples.
+	// The BEGIN and COMMIT are still delivered, so that the LSN keeps advancing through the transactions of only row changes.
+	DDLOnly bool
+
+	// ReplicaIdentityCheck checks the tables with REPLICA IDENTITY NOTHING, or DEFAULT without primary key,
+	// which produce no old keys on UPDATE and DELETE
+	ReplicaIdentityCheck ReplicaIdentityCheck
+
+	// ProjectColumns limits the decoded columns of the relations keyed by "schema.table", and the other relations are fully decoded
+	ProjectColumns map[string][]string
+
+	// CanonicalJSONB decodes the jsonb values with their object keys sorted and without whitespaces, so that the equal
+	// documents are always delivered in identical bytes, for the downstream serializing or digesting them
+	CanonicalJSONB bool
+
+	// SchemaCacheTables bounds the column types cached to the SchemaCacheTables recently captured relations, instead of
+	// all the relations of the database, and the evicted ones are loaded again on their next changes.
+	// The column types are loaded by the same connection of the setup, for the databases of many tables captured partly,
+	// so it is rejected by the ErrSchemaCacheWorkers with more than one DecodeWorkers.
+	SchemaCacheTables int
+
+	// SchemaFingerprint sets the Change.SchemaFingerprint on the first change of each relation and of each new layout.
+	// The decode.SchemaFingerprinter provides the fingerprints from the relation messages, and with the DecodeWorkers,
+	// the changes decoded ahead of a relation message of a new layout may be fingerprinted by the new layout.
+	SchemaFingerprint bool
+
+	// SystemColumns decodes the xmin, xmax, cmin and cmax of the tuples into the Change.SystemColumns if the plugin sends them,
+	// for the audit scenarios replicating them, and they are removed from the fields of the changes
+	SystemColumns bool
+
+	// AuditedColumns keeps the old values only of the columns of the relations keyed by "schema.table", for the relations
+	// with the REPLICA IDENTITY FULL sending the whole old tuples, and the other relations keep all their old values.
+	// The PGXSink matches the rows by the old values if any, so the columns should include the keys if applied by it.
+	AuditedColumns map[string][]string
+
+	// ReceiveTimeout fails the source with the ErrReceiveTimeout if no message, including the keepalive, is received within it.
+	// A healthy idle connection still receives keepalives periodically, so it should be larger than the wal_sender_timeout/2
+	// of the server. It is disabled if zero.
+	ReceiveTimeout time.Duration
+
+	// ApplicationName identifies both the setup and the replication connections in the pg_stat_activity and the pg_stat_replication,
+	// defaults to the application_name of the connection strings, or "pgcapture-<slot>" if they have none
+	ApplicationName string
+
+	// SessionSettings are SET on both the setup and the replication sessions, so that the values falling back to
+	// the text format are not affected by the server settings, see the DeterministicSessionSettings
+	SessionSettings map[string]string
+
+	// Metrics receives the metrics of the source, defaults to the DefaultMetricsSink
+	Metrics MetricsSink
+
+	// DecodeWorkers decodes the WAL data concurrently by the number of workers if it is larger than 1,
+	// for the decoding dominating the CPU, and the decoded messages are still delivered in the WAL order.
+	// At most DecodeBufferSize messages, defaults to the DefaultDecodeBufferSize, are buffered for reordering.
+	DecodeWorkers    int
+	DecodeBufferSize int
+
+	// MeasureLatency records the time from the commit of the transaction to the delivery of each message,
+	// in the MetricDeliveryLatency histogram and the Change.Latency
+	MeasureLatency bool
+
+	// DurableAck advances the slot only to the LSN reported by the CommitDurable after the sink persists it durably,
+	// while the Commit only reports the delivered LSN as the written position of the standby status updates
+	DurableAck bool
+
+	// AckEveryNTransactions advances the committed LSN reported to the server only by every AckEveryNTransactions committed
+	// transactions, to reduce the standby status updates of the high-throughput pipelines, and at most the last
+	// AckEveryNTransactions-1 committed transactions are replayed after restarted. The repeated Commit of the same
+	// transaction is not counted, and the position started by the Capture is always committed. Every Commit advances it
+	// if not positive.
+	AckEveryNTransactions int
+
+	// Clock drives the timers of the source, and defaults to the real clock if nil
+	Clock Clock
+
+	// MaxReconnects re-establishes the failed replication from the committed LSN, at most MaxReconnects times within
+	// the ReconnectWindow, and then fails the source with the ErrReconnectBudgetExhausted. Each consecutive attempt waits
+	// for the ReconnectBackoff doubled up to the MaxReconnectBackoff. It is disabled if zero.
+	MaxReconnects       int
+	ReconnectWindow     time.Duration
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+
+	// FaultInjector fails the receiving and the decoding of the messages by its rules, to test the reconnects and
+	// the duplicate suppression without a flapping server. It never fails if nil.
+	FaultInjector *fault.Injector
+
+	// LongTransactionThreshold calls the OnLongTransaction with the pid, the xid and the age of the oldest transaction
+	// open on the server, once it is older than the threshold, since the slot can not advance past its start until it
+	// ends and the server retains the WAL since then. The pg_stat_activity is checked with the standby status updates,
+	// which only shows the transactions of the other roles with the pg_read_all_stats, and the age is reported in the
+	// MetricOldestOpenTxAge regardless of the threshold. It is disabled if zero.
+	LongTransactionThreshold time.Duration
+	OnLongTransaction        func(pid int32, xid uint32, age time.Duration)
+
+	// DigestEveryChanges emits a consistency digest, right before the COMMIT of the transaction after every DigestEveryChanges
+	// row changes, or every DigestInterval. The digest is a change of the pgcapture.digests, told by the decode.IsDigest,
+	// whose DigestField holds the running insert, update and delete counts of each table delivered since the source started,
+	// so that the downstream can compare them with its own applied counts for the drift. It is disabled if both are zero.
+	DigestEveryChanges int
+	DigestInterval     time.Duration
+
+	// LogFinalReport logs the FinalReport when the source is cleaned up
+	LogFinalReport bool
+
 	setupConn      *pgx.Conn
-	replConn       *pgconn.PgConn
+	replConn       replicationConn
+	dialRepl       func(ctx context.Context) (replicationConn, error)
 	schema         *decode.PGXSchemaLoader
+	refreshType    func() error
+	queryOldestTx  func(ctx context.Context) (openTx, bool, error)
 	decoder        decode.Decoder
 	nextReportTime time.Time
 	ackLsn         uint64
+	pendingAckLsn  uint64
+	durableLsn     uint64
+	ackFrozen      int32
 	txCounter      uint64
+	ackTxs         uint64
+	countedLsn     uint64
 	log            *logrus.Entry
 	first          bool
 	currentLsn     uint64
 	currentSeq     uint32
+	commitTime     uint64
+	resumeFrom     cursor.Checkpoint
+	globalSeq      uint64
+	txBuffer       *txBuffer
+	pipeline       *decodePipeline
+	lastReceived   time.Time
+	paramsMu       sync.Mutex
+	params         map[string]string
+	serverWALEnd   uint64
+	changeCount    uint64
+	replStarted    bool
+	reconnects     uint64
+	budget         *reconnectBudget
+	delivered      cursor.Checkpoint
+	finalReport    atomic.Value
+	snapshotName   string
+	snapshotUsed   int32
+	alertedXid     uint32
+	digest         *digest
+	pendingCommit  *decodeItem
+	aligned        bool
+	appName        string
+	fingerprints   map[string]string
+	msgMetrics     *messageMetrics
+	preparedMu     sync.Mutex
+	prepared       cursor.Checkpoint
+}
+
+// Report is the snapshot of the source state taken when it is cleaned up, for the post-mortem
+type Report struct {
+	Slot         string
+	CommittedLSN uint64
+	ServerWALEnd uint64
+	Changes      uint64
+	Reconnects   uint64
+	Err          error
+	At           time.Time
 }
 
 func (p *PGXSource) TxCounter() uint64 {
@@ -52,43 +333,83 @@ func (p *PGXSource) Capture(cp cursor.Checkpoint) (changes chan Change, err erro
 		}
 	}()
 
+	if p.SchemaCacheTables > 0 && p.DecodeWorkers > 1 {
+		return nil, ErrSchemaCacheWorkers
+	}
+	p.msgMetrics = bindMessageMetrics(p.metrics(), p.ReplSlot)
+
 	ctx := context.Background()
-	p.setupConn, err = pgx.Connect(ctx, p.SetupConnStr)
+	setupConfig, err := pgx.ParseConfig(p.SetupConnStr)
+	if err != nil {
+		return nil, err
+	}
+	p.setApplicationName(setupConfig.RuntimeParams)
+	p.setupConn, err = pgx.ConnectConfig(ctx, setupConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(p.SessionSettings) != 0 {
+		if _, err = p.setupConn.Exec(ctx, sessionSettingsSQL(p.SessionSettings)); err != nil {
+			return nil, err
+		}
+	}
+
 	if _, err = p.setupConn.Exec(ctx, sql.InstallExtension); err != nil {
 		return nil, err
 	}
 
-	p.schema = decode.NewPGXSchemaLoader(p.setupConn)
-	if err = p.schema.RefreshType(); err != nil {
+	if err = p.checkReplicaIdentity(ctx); err != nil {
+		return nil, err
+	}
+
+	if p.SchemaCacheTables > 0 {
+		p.schema = decode.NewBoundedPGXSchemaLoader(p.setupConn, p.SchemaCacheTables)
+	} else {
+		p.schema = decode.NewPGXSchemaLoader(p.setupConn)
+	}
+	p.refreshType = p.schema.RefreshType
+	p.queryOldestTx = func(ctx context.Context) (openTx, bool, error) {
+		return queryOldestOpenTx(ctx, p.setupConn)
+	}
+	if err = p.refreshType(); err != nil {
 		return nil, err
 	}
 
 	switch p.DecodePlugin {
 	case decode.PGLogicalOutputPlugin:
-		p.decoder, err = decode.NewPGLogicalDecoder(p.schema)
+		decoder, err := decode.NewPGLogicalDecoder(p.schema)
 		if err != nil {
 			return nil, err
 		}
+		decoder.(*decode.PGLogicalDecoder).ProjectColumns = p.ProjectColumns
+		decoder.(*decode.PGLogicalDecoder).DDLOnly = p.DDLOnly
+		decoder.(*decode.PGLogicalDecoder).AuditedColumns = p.AuditedColumns
+		decoder.(*decode.PGLogicalDecoder).CanonicalJSONB = p.CanonicalJSONB
+		decoder.(*decode.PGLogicalDecoder).SystemColumns = p.SystemColumns
+		p.decoder = decoder
 	case decode.PGOutputPlugin:
-		p.decoder = decode.NewPGOutputDecoder(p.schema, p.ReplSlot)
+		decoder := decode.NewPGOutputDecoder(p.schema, p.ReplSlot)
+		decoder.ProjectColumns = p.ProjectColumns
+		decoder.DDLOnly = p.DDLOnly
+		decoder.AuditedColumns = p.AuditedColumns
+		decoder.CanonicalJSONB = p.CanonicalJSONB
+		decoder.SystemColumns = p.SystemColumns
+		p.decoder = decoder
 		if p.CreatePublication {
-			if _, err = p.setupConn.Exec(ctx, fmt.Sprintf(sql.CreatePublication, p.ReplSlot)); err != nil {
-				var pge *pgconn.PgError
-				if !errors.As(err, &pge) || pge.Code != "42710" {
-					return nil, err
-				}
+			if err = p.createPublication(ctx); err != nil {
+				return nil, err
 			}
 		}
 	default:
 		return nil, errors.New("unknown decode plugin")
 	}
 
-	if p.CreateSlot {
-		if _, err = p.setupConn.Exec(ctx, sql.CreateLogicalSlot, p.ReplSlot, p.DecodePlugin); err != nil {
+	if p.CreateSlot && p.ExportSnapshot == nil {
+		if err = p.withSlotCreateTimeout(p.setupConn.PgConn().CancelRequest, func() error {
+			_, err := p.setupConn.Exec(ctx, sql.CreateLogicalSlot, p.ReplSlot, p.DecodePlugin)
+			return err
+		}); err != nil {
 			var pge *pgconn.PgError
 			if !errors.As(err, &pge) || pge.Code != "42710" {
 				return nil, err
@@ -96,12 +417,16 @@ func (p *PGXSource) Capture(cp cursor.Checkpoint) (changes chan Change, err erro
 		}
 	}
 
-	p.replConn, err = pgconn.Connect(context.Background(), p.ReplConnStr)
-	if err != nil {
+	if err = p.checkSlotStatus(ctx); err != nil {
+		return nil, err
+	}
+
+	p.dialRepl = p.dialReplication
+	if p.replConn, err = p.dialRepl(ctx); err != nil {
 		return nil, err
 	}
 
-	ident, err := pglogrepl.IdentifySystem(context.Background(), p.replConn)
+	ident, err := p.replConn.IdentifySystem(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -115,9 +440,14 @@ func (p *PGXSource) Capture(cp cursor.Checkpoint) (changes chan Change, err erro
 		"Decoder":  p.DecodePlugin,
 	}).Info("retrieved current info of source database")
 
+	if p.CreateSlot && p.ExportSnapshot != nil {
+		if err = p.createSlotExportingSnapshot(ctx); err != nil {
+			return nil, err
+		}
+	}
+
 	if cp.LSN != 0 {
-		p.currentLsn = cp.LSN
-		p.currentSeq = cp.Seq
+		p.resume(cp)
 		p.log.WithFields(logrus.Fields{
 			"ReplSlot": p.ReplSlot,
 			"FromLSN":  p.currentLsn,
@@ -138,92 +468,725 @@ func (p *PGXSource) Capture(cp cursor.Checkpoint) (changes chan Change, err erro
 			"FromLSN":  p.currentLsn,
 		}).Info("start logical replication from the latest position")
 	}
-	p.Commit(cursor.Checkpoint{LSN: p.currentLsn})
-	if err = pglogrepl.StartReplication(
-		context.Background(),
-		p.replConn,
-		p.ReplSlot,
-		pglogrepl.LSN(p.currentLsn),
-		pglogrepl.StartReplicationOptions{PluginArgs: p.decoder.GetPluginArgs()},
-	); err != nil {
+	p.seedCommit(cursor.Checkpoint{LSN: p.currentLsn})
+	p.CommitDurable(cursor.Checkpoint{LSN: p.currentLsn})
+	if p.TransactionalDelivery {
+		if err = p.initTxBuffer(); err != nil {
+			return nil, err
+		}
+	}
+	p.initDecodePipeline()
+	if err = p.exportSnapshot(context.Background()); err != nil {
 		return nil, err
 	}
 
-	return p.BaseSource.capture(p.fetching, p.cleanup)
+	return p.BaseSource.capture(p.receiving, p.cleanup)
+}
+
+// withSlotCreateTimeout runs the slot creation, and cancels it by the cancel if it exceeds the SlotCreateTimeout,
+// so that the server aborts the slot creation, instead of leaving it running behind a broken connection
+func (p *PGXSource) withSlotCreateTimeout(cancel func(ctx context.Context) error, create func() error) error {
+	if p.SlotCreateTimeout <= 0 {
+		return create()
+	}
+	var timedOut int32
+	timer := p.clock().AfterFunc(p.SlotCreateTimeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		cancel(context.Background())
+	})
+	err := create()
+	if !timer.Stop() && atomic.LoadInt32(&timedOut) == 1 && err != nil {
+		return fmt.Errorf("%w: %v: %w", ErrSlotCreateTimeout, p.SlotCreateTimeout, err)
+	}
+	return err
+}
+
+// createSlotExportingSnapshot creates the slot and keeps the name of the exported snapshot, and the snapshot is not
+// exported if the slot exists already
+func (p *PGXSource) createSlotExportingSnapshot(ctx context.Context) error {
+	var result pglogrepl.CreateReplicationSlotResult
+	err := p.withSlotCreateTimeout(p.replConn.CancelRequest, func() (err error) {
+		result, err = p.replConn.CreateReplicationSlot(ctx, p.ReplSlot, p.DecodePlugin, pglogrepl.CreateReplicationSlotOptions{
+			Mode:           pglogrepl.LogicalReplication,
+			SnapshotAction: "EXPORT_SNAPSHOT",
+		})
+		return err
+	})
+	if err != nil {
+		var pge *pgconn.PgError
+		if errors.As(err, &pge) && pge.Code == "42710" {
+			p.log.WithField("ReplSlot", p.ReplSlot).Warn("replication slot exists already, no snapshot is exported")
+			return nil
+		}
+		return err
+	}
+	p.snapshotName = result.SnapshotName
+	p.log.WithFields(logrus.Fields{"ReplSlot": p.ReplSlot, "Snapshot": result.SnapshotName, "ConsistentPoint": result.ConsistentPoint}).Info("replication slot created with exported snapshot")
+	return nil
+}
+
+// exportSnapshot calls the ExportSnapshot with the snapshot exported by the slot creation, if any, and then starts
+// the replication, which expires the snapshot.
+func (p *PGXSource) exportSnapshot(ctx context.Context) error {
+	if p.snapshotName != "" {
+		if err := p.ExportSnapshot(p.snapshotName); err != nil {
+			return err
+		}
+	}
+	return p.startReplication(ctx)
+}
+
+// SnapshotName returns the name of the snapshot exported by the slot creation. It is only valid inside the
+// ExportSnapshot callback, because the Capture starts the replication before returning, and any later call
+// returns the ErrSnapshotExpired.
+func (p *PGXSource) SnapshotName() (string, error) {
+	if p.snapshotName == "" {
+		return "", ErrSnapshotNotExported
+	}
+	if atomic.LoadInt32(&p.snapshotUsed) != 0 {
+		return p.snapshotName, ErrSnapshotExpired
+	}
+	return p.snapshotName, nil
+}
+
+func (p *PGXSource) checkReplicaIdentity(ctx context.Context) error {
+	if p.ReplicaIdentityCheck == ReplicaIdentityCheckNone {
+		return nil
+	}
+	rows, err := p.setupConn.Query(ctx, sql.QueryMisconfiguredReplicaIdentity)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var tables []string
+	var nspname, relname, identity string
+	for rows.Next() {
+		if err = rows.Scan(&nspname, &relname, &identity); err != nil {
+			return err
+		}
+		tables = append(tables, nspname+"."+relname)
+		logrus.WithFields(logrus.Fields{
+			"From":            "PGXSource",
+			"Table":           nspname + "." + relname,
+			"ReplicaIdentity": identity,
+		}).Warn("table has no usable replica identity for UPDATE and DELETE")
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(tables) != 0 && p.ReplicaIdentityCheck == ReplicaIdentityCheckError {
+		return fmt.Errorf("%w: %s", ErrReplicaIdentity, strings.Join(tables, ", "))
+	}
+	return nil
+}
+
+func (p *PGXSource) checkSlotStatus(ctx context.Context) error {
+	version, err := p.schema.GetVersion()
+	if err != nil || version < 130000 {
+		return err
+	}
+	var status pgtype.Text
+	if err = p.setupConn.QueryRow(ctx, sql.QuerySlotWALStatus, p.ReplSlot).Scan(&status); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if status.String == "lost" {
+		return fmt.Errorf("%w: %s has wal_status lost", ErrSlotInvalidated, p.ReplSlot)
+	}
+	return nil
+}
+
+// slotError wraps the error of the server about the invalidated slot with the ErrSlotInvalidated
+func slotError(err error) error {
+	var pge *pgconn.PgError
+	if errors.As(err, &pge) && pge.Code == "55000" && strings.Contains(pge.Detail, "invalidated") {
+		return fmt.Errorf("%w: %w", ErrSlotInvalidated, err)
+	}
+	return err
+}
+
+func (p *PGXSource) initTxBuffer() (err error) {
+	limit := p.MaxInFlightBytes
+	if limit <= 0 {
+		limit = DefaultMaxInFlightBytes
+	}
+	p.txBuffer, err = newTxBuffer(p.SpillDir, p.ReplSlot, limit)
+	return err
+}
+
+func (p *PGXSource) initDecodePipeline() {
+	if p.DecodeWorkers <= 1 {
+		return
+	}
+	size := p.DecodeBufferSize
+	if size <= 0 {
+		size = DefaultDecodeBufferSize
+	}
+	p.pipeline = newDecodePipeline(p.decoder, p.DecodeWorkers, size)
+}
+
+// setApplicationName sets the ApplicationName, or the "pgcapture-<slot>" if neither the ApplicationName nor the connection string has one
+func (p *PGXSource) setApplicationName(params map[string]string) {
+	if p.ApplicationName != "" {
+		params["application_name"] = p.ApplicationName
+	} else if params["application_name"] == "" {
+		params["application_name"] = "pgcapture-" + p.ReplSlot
+	}
+}
+
+// sessionSettingsSQL generates the SET statements in the order of names, which are allowed in the simple protocol
+// of the replication connection
+func sessionSettingsSQL(settings map[string]string) string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "SET %s TO '%s';", pgx.Identifier{name}.Sanitize(), strings.ReplaceAll(settings[name], "'", "''"))
+	}
+	return sb.String()
+}
+
+func (p *PGXSource) resume(cp cursor.Checkpoint) {
+	p.currentLsn = cp.LSN
+	p.currentSeq = cp.Seq
+	p.globalSeq = cp.GlobalSeq
+	if p.SuppressDuplicates {
+		p.resumeFrom = cp
+	}
+}
+
+func (p *PGXSource) startReplication(ctx context.Context) error {
+	// any command on the replication connection expires the exported snapshot
+	atomic.StoreInt32(&p.snapshotUsed, 1)
+	if len(p.SessionSettings) != 0 {
+		if err := p.replConn.Exec(ctx, sessionSettingsSQL(p.SessionSettings)); err != nil {
+			return err
+		}
+	}
+	args := p.decoder.GetPluginArgs()
+	if p.StartupParamsFunc != nil {
+		args = p.StartupParamsFunc(append([]string(nil), args...))
+	}
+	p.lastReceived = p.clock().Now()
+	if p.replStarted {
+		atomic.AddUint64(&p.reconnects, 1)
+	}
+	p.replStarted = true
+	return slotError(p.replConn.StartReplication(ctx, p.ReplSlot, pglogrepl.LSN(p.currentLsn), pglogrepl.StartReplicationOptions{PluginArgs: args}))
+}
+
+func (p *PGXSource) reading(ctx context.Context) (change Change, err error) {
+	if change, err = p.buffering(ctx); err == nil && change.Message != nil {
+		p.measureLatency(&change)
+	}
+	return change, err
+}
+
+// measureLatency sets the Latency of the change being delivered, which covers the time it is buffered
+func (p *PGXSource) measureLatency(change *Change) {
+	if !p.MeasureLatency || change.commitTime == 0 {
+		return
+	}
+	msgType := "change"
+	switch m := change.Message; {
+	case m.GetBegin() != nil:
+		msgType = "begin"
+	case m.GetCommit() != nil:
+		msgType = "commit"
+	case m.GetChange() != nil && decode.IsDigest(m.GetChange()):
+		msgType = "digest"
+	}
+	change.Latency = p.clock().Now().Sub(pgTime(change.commitTime))
+	p.messageMetrics().latency[msgType](change.Latency.Seconds())
+}
+
+// buffering fetches the changes, and holds the changes of each transaction until its COMMIT with the TransactionalDelivery
+func (p *PGXSource) buffering(ctx context.Context) (change Change, err error) {
+	if p.txBuffer == nil {
+		return p.fetching(ctx)
+	}
+	if change, ok, err := p.txBuffer.next(); ok || err != nil {
+		return change, err
+	}
+	if change, err = p.fetching(ctx); err != nil || change.Message == nil {
+		return change, err
+	}
+	switch change.Message.Type.(type) {
+	case *pb.Message_Begin:
+		err = p.txBuffer.begin(change)
+	case *pb.Message_Change:
+		if !p.txBuffer.open {
+			return change, nil
+		}
+		err = p.txBuffer.append(change)
+	case *pb.Message_Commit:
+		if !p.txBuffer.open {
+			return change, nil
+		}
+		if err = p.txBuffer.commit(change); err == nil {
+			change, _, err = p.txBuffer.next()
+			return change, err
+		}
+	}
+	return Change{}, err
 }
 
 func (p *PGXSource) fetching(ctx context.Context) (change Change, err error) {
-	if time.Now().After(p.nextReportTime) {
+	if c := p.pendingCommit; c != nil {
+		p.pendingCommit = nil
+		return p.handleDecoded(c.xld, c.m)
+	}
+	if now := p.clock().Now(); now.After(p.nextReportTime) {
+		p.checkOpenTransactions(ctx)
 		if err = p.reportLSN(ctx); err != nil {
-			return change, err
+			transient := p.isTransientAckError(err)
+			p.metrics().Counter(MetricAckFailures, 1, map[string]string{"slot": p.ReplSlot, "transient": strconv.FormatBool(transient)})
+			if !transient {
+				return change, err
+			}
+			// retry on the next iteration since the connection is still alive
+			p.log.WithError(err).Warn("failed to send standby status update, will retry")
+		} else {
+			p.nextReportTime = now.Add(5 * time.Second)
+		}
+	}
+	rctx := ctx
+	if p.pipeline != nil {
+		if head := p.pipeline.head(); head != nil {
+			if head.decoded() || p.pipeline.full() {
+				select {
+				case <-head.done:
+				case <-ctx.Done():
+					return change, ctx.Err()
+				}
+				p.pipeline.pop()
+				if head.m == nil || head.err != nil {
+					return change, head.err
+				}
+				return p.handleDecoded(head.xld, head.m)
+			}
+			// stop receiving once the head is decoded, so that it is not delayed until the next message
+			var cancel context.CancelFunc
+			rctx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			go func(done chan struct{}) {
+				select {
+				case <-done:
+					cancel()
+				case <-rctx.Done():
+				}
+			}(head.done)
 		}
-		p.nextReportTime = time.Now().Add(5 * time.Second)
 	}
-	msg, err := p.replConn.ReceiveMessage(ctx)
+	if err = p.FaultInjector.Check(fault.Receive, p.currentLsn); err != nil {
+		return change, err
+	}
+	msg, err := p.replConn.ReceiveMessage(rctx)
 	if err != nil {
+		if rctx != ctx && ctx.Err() == nil && errors.Is(err, context.Canceled) {
+			return change, nil
+		}
+		if p.ReceiveTimeout > 0 && isTimeout(err) {
+			if p.lastReceived.IsZero() {
+				p.lastReceived = p.clock().Now()
+			} else if silent := p.clock().Now().Sub(p.lastReceived); silent > p.ReceiveTimeout {
+				return change, fmt.Errorf("%w: silent for %v", ErrReceiveTimeout, silent)
+			}
+		}
 		return change, err
 	}
+	p.lastReceived = p.clock().Now()
 	switch msg := msg.(type) {
 	case *pgproto3.CopyData:
 		switch msg.Data[0] {
 		case pglogrepl.PrimaryKeepaliveMessageByteID:
 			var pkm pglogrepl.PrimaryKeepaliveMessage
-			if pkm, err = pglogrepl.ParsePrimaryKeepaliveMessage(msg.Data[1:]); err == nil && pkm.ReplyRequested {
-				p.nextReportTime = time.Time{}
+			if pkm, err = pglogrepl.ParsePrimaryKeepaliveMessage(msg.Data[1:]); err == nil {
+				atomic.StoreUint64(&p.serverWALEnd, uint64(pkm.ServerWALEnd))
+				if pkm.ReplyRequested {
+					p.nextReportTime = time.Time{}
+				}
 			}
 		case pglogrepl.XLogDataByteID:
 			xld, err := pglogrepl.ParseXLogData(msg.Data[1:])
 			if err != nil {
 				return change, err
 			}
+			atomic.StoreUint64(&p.serverWALEnd, uint64(xld.ServerWALEnd))
 			// in the implementation of pgx v5, the xld.WALData will be reused
 			walData := make([]byte, len(xld.WALData))
 			copy(walData, xld.WALData)
+			p.messageMetrics().messageBytes(float64(len(walData)))
+			if err = p.FaultInjector.Check(fault.Decode, uint64(xld.WALStart)); err != nil {
+				return change, err
+			}
+			xld.WALData = walData
+			if p.pipeline != nil {
+				p.pipeline.submit(xld)
+				return change, nil
+			}
 			m, err := p.decoder.Decode(walData)
 			if m == nil || err != nil {
 				return change, err
 			}
-			if msg := m.GetChange(); msg != nil {
-				if decode.Ignore(msg) {
-					return change, nil
-				} else if decode.IsDDL(msg) {
-					if err = p.schema.RefreshType(); err != nil {
-						return change, err
-					}
-				}
-				p.currentSeq++
-			} else if b := m.GetBegin(); b != nil {
-				p.currentLsn = b.FinalLsn
-				p.currentSeq = 0
-			} else if c := m.GetCommit(); c != nil {
-				p.currentLsn = c.CommitLsn
-				p.currentSeq++
-			}
-			change = Change{
-				Checkpoint: cursor.Checkpoint{LSN: p.currentLsn, Seq: p.currentSeq},
-				Message:    m,
-			}
-			if !p.first {
-				p.log.WithFields(logrus.Fields{
-					"MessageLSN": change.Checkpoint.LSN,
-					"Message":    m.String(),
-				}).Info("retrieved the first message from postgres")
-				p.first = true
-			}
+			return p.handleDecoded(xld, m)
 		}
+	case *pgproto3.ParameterStatus:
+		p.setParameterStatus(msg.Name, msg.Value)
+	case *pgproto3.ErrorResponse:
+		err = slotError(pgconn.ErrorResponseToPgError(msg))
 	default:
 		err = errors.New("unexpected message")
 	}
 	return change, err
 }
 
+// handleDecoded tracks the positions of the decoded message, and converts it into the change to be delivered
+func (p *PGXSource) handleDecoded(xld pglogrepl.XLogData, m *pb.Message) (change Change, err error) {
+	msgType := "change"
+	var endLsn uint64
+	var systemColumns map[string]uint32
+	if p.AlignToTransaction && !p.aligned {
+		if m.GetBegin() == nil {
+			if c := m.GetChange(); c != nil && decode.IsDDL(c) && p.DDLDelivery.refresh() {
+				return change, p.refresh()
+			}
+			return change, nil
+		}
+		p.aligned = true
+	}
+	if msg := m.GetChange(); msg != nil {
+		if decode.Ignore(msg) {
+			return change, nil
+		} else if decode.IsDigest(msg) {
+			msgType = "digest"
+		} else if decode.IsDDL(msg) {
+			if p.DDLDelivery.refresh() {
+				if err = p.refresh(); err != nil {
+					return change, err
+				}
+			}
+			if !p.DDLDelivery.emit() {
+				return change, nil
+			}
+		} else if p.DDLOnly || p.excludedApplication() {
+			return change, nil
+		} else {
+			if p.DerefLargeObject {
+				if err = p.derefLargeObjects(msg); err != nil {
+					return change, err
+				}
+			}
+			if p.SystemColumns {
+				systemColumns = extractSystemColumns(msg)
+			}
+		}
+		p.currentSeq++
+	} else if b := m.GetBegin(); b != nil {
+		p.currentLsn = b.FinalLsn
+		p.currentSeq = 0
+		p.commitTime = b.CommitTime
+		msgType = "begin"
+		p.appName = ""
+		if p.ApplicationNameFunc != nil {
+			p.appName = p.ApplicationNameFunc(b.RemoteXid)
+		}
+	} else if c := m.GetCommit(); c != nil {
+		if now := p.clock().Now(); p.digestDue(now) {
+			// the COMMIT is delivered after the digest, which covers the changes up to the transaction
+			p.pendingCommit = &decodeItem{xld: xld, m: m}
+			return p.handleDecoded(xld, p.digestMessage(now))
+		}
+		p.currentLsn = c.CommitLsn
+		p.currentSeq++
+		p.commitTime = c.CommitTime
+		msgType = "commit"
+		endLsn = c.EndLsn
+	}
+	change = Change{
+		Checkpoint:   cursor.Checkpoint{LSN: p.currentLsn, Seq: p.currentSeq},
+		Message:      m,
+		WALStart:     uint64(xld.WALStart),
+		ServerWALEnd: uint64(xld.ServerWALEnd),
+		CommitLSN:    p.currentLsn,
+		EndLSN:       endLsn,
+
+		ApplicationName: p.appName,
+		SystemColumns:   systemColumns,
+	}
+	if p.resumeFrom.LSN != 0 {
+		if !change.Checkpoint.After(p.resumeFrom) {
+			p.metrics().Counter(MetricSuppressedDuplicates, 1, map[string]string{"slot": p.ReplSlot})
+			return Change{}, nil
+		}
+		p.resumeFrom = cursor.Checkpoint{}
+	}
+	p.globalSeq++
+	change.Checkpoint.GlobalSeq = p.globalSeq
+	if msgType == "change" {
+		atomic.AddUint64(&p.changeCount, 1)
+		if p.SchemaFingerprint {
+			change.SchemaFingerprint = p.fingerprint(m.GetChange())
+		}
+		p.countDigest(m.GetChange(), p.clock().Now())
+	}
+	p.messageMetrics().messages[msgType](1)
+	if p.MeasureLatency {
+		change.commitTime = p.commitTime
+	}
+	if !p.first {
+		p.log.WithFields(logrus.Fields{
+			"MessageLSN": change.Checkpoint.LSN,
+			"Message":    m.String(),
+		}).Info("retrieved the first message from postgres")
+		p.first = true
+	}
+	return change, nil
+}
+
+func (p *PGXSource) clock() Clock {
+	if p.Clock == nil {
+		return realClock{}
+	}
+	return p.Clock
+}
+
+// extractSystemColumns takes the system columns out of the new tuple, or the old one of the DELETE
+func extractSystemColumns(m *pb.Change) map[string]uint32 {
+	var values, old map[string]uint32
+	m.New, values = decode.ExtractSystemColumns(m.New)
+	if m.Old, old = decode.ExtractSystemColumns(m.Old); values == nil {
+		values = old
+	}
+	return values
+}
+
+// fingerprint returns the fingerprint of the relation of the row change if it is not delivered since changed
+func (p *PGXSource) fingerprint(m *pb.Change) string {
+	f, ok := p.decoder.(decode.SchemaFingerprinter)
+	if !ok || decode.IsDDL(m) {
+		return ""
+	}
+	fingerprint, ok := f.SchemaFingerprint(m.Schema, m.Table)
+	if !ok {
+		return ""
+	}
+	key := m.Schema + "." + m.Table
+	if p.fingerprints[key] == fingerprint {
+		return ""
+	}
+	if p.fingerprints == nil {
+		p.fingerprints = make(map[string]string)
+	}
+	p.fingerprints[key] = fingerprint
+	return fingerprint
+}
+
+func (p *PGXSource) excludedApplication() bool {
+	if p.appName == "" {
+		return false
+	}
+	for _, name := range p.ExcludeApplicationNames {
+		if p.appName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pgTime converts the microseconds since 2000-01-01 into the time like the sink.PGTime2Time does,
+// and the timestamps of commits are never infinite
+func pgTime(ts uint64) time.Time {
+	return time.UnixMicro(946684800*1000000 + int64(ts))
+}
+
+// refresh reloads the schema, and the messages in the pipeline are decoded again with the new schema
+func (p *PGXSource) refresh() error {
+	if p.pipeline != nil {
+		return p.pipeline.redecode(p.refreshType)
+	}
+	return p.refreshType()
+}
+
+// trackedParameters are the GUCs recorded from the replication connection on dial, for the ParameterStatus
+var trackedParameters = []string{"TimeZone", "DateStyle", "IntervalStyle"}
+
+// ParameterStatus returns the latest value of the server parameter reported on the replication connection.
+// The ParameterStatus messages in the middle of the stream are tolerated and recorded here, but are not used
+// by the decoding, which passes the values in text through as they are.
+func (p *PGXSource) ParameterStatus(name string) string {
+	p.paramsMu.Lock()
+	defer p.paramsMu.Unlock()
+	return p.params[name]
+}
+
+func (p *PGXSource) setParameterStatus(name, value string) {
+	p.paramsMu.Lock()
+	defer p.paramsMu.Unlock()
+	if p.params == nil {
+		p.params = make(map[string]string)
+	}
+	if prev, ok := p.params[name]; ok && prev != value && p.log != nil {
+		p.log.WithFields(logrus.Fields{"Name": name, "From": prev, "To": value}).Info("server parameter changed")
+	}
+	p.params[name] = value
+}
+
+func (p *PGXSource) metrics() MetricsSink {
+	if p.Metrics != nil {
+		return p.Metrics
+	}
+	return DefaultMetricsSink()
+}
+
+// messageMetrics returns the metrics of every message, which are bound at the Capture, or on the first use
+func (p *PGXSource) messageMetrics() *messageMetrics {
+	if p.msgMetrics == nil {
+		p.msgMetrics = bindMessageMetrics(p.metrics(), p.ReplSlot)
+	}
+	return p.msgMetrics
+}
+
+func (p *PGXSource) derefLargeObjects(m *pb.Change) error {
+	limit := p.LargeObjectSizeLimit
+	if limit <= 0 {
+		limit = DefaultLargeObjectSizeLimit
+	}
+	for _, fields := range [][]*pb.Field{m.New, m.Old} {
+		for _, f := range fields {
+			if f.Oid != pgtype.OIDOID || f.Value == nil {
+				continue
+			}
+			var loid uint32
+			switch v := f.Value.(type) {
+			case *pb.Field_Binary:
+				if len(v.Binary) != 4 {
+					continue
+				}
+				loid = binary.BigEndian.Uint32(v.Binary)
+			case *pb.Field_Text:
+				n, err := strconv.ParseUint(v.Text, 10, 32)
+				if err != nil {
+					continue
+				}
+				loid = uint32(n)
+			}
+			// read one more byte than the limit to know if the large object exceeds the limit
+			var data []byte
+			if err := p.setupConn.QueryRow(context.Background(), sql.QueryLargeObject, loid, limit+1).Scan(&data); err != nil {
+				var pge *pgconn.PgError
+				if errors.As(err, &pge) && pge.Code == "42704" {
+					p.log.WithFields(logrus.Fields{
+						"Schema": m.Schema,
+						"Table":  m.Table,
+						"Column": f.Name,
+						"LOID":   loid,
+					}).Warn("large object not found, pass through the oid")
+					continue
+				}
+				return err
+			}
+			if len(data) > limit {
+				continue
+			}
+			f.Oid = pgtype.ByteaOID
+			f.Value = &pb.Field_Binary{Binary: data}
+		}
+	}
+	return nil
+}
+
 func (p *PGXSource) Commit(cp cursor.Checkpoint) {
 	if cp.LSN != 0 {
-		atomic.StoreUint64(&p.ackLsn, cp.LSN)
 		atomic.AddUint64(&p.txCounter, 1)
+		if p.AckEveryNTransactions > 1 && !p.ackDue(cp.LSN) {
+			return
+		}
+		p.storeAck(cp.LSN)
+	}
+}
+
+// seedCommit commits the position started from by the Capture, which is not a transaction of the consumer
+// and thus not gated by the AckEveryNTransactions
+func (p *PGXSource) seedCommit(cp cursor.Checkpoint) {
+	if cp.LSN != 0 {
+		atomic.AddUint64(&p.txCounter, 1)
+		atomic.StoreUint64(&p.countedLsn, cp.LSN)
+		p.storeAck(cp.LSN)
+	}
+}
+
+// ackDue counts the committed transaction of the lsn if it is not counted yet, and reports whether it is the
+// AckEveryNTransactions-th one since the last acknowledged
+func (p *PGXSource) ackDue(lsn uint64) bool {
+	for {
+		counted := atomic.LoadUint64(&p.countedLsn)
+		if lsn <= counted {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&p.countedLsn, counted, lsn) {
+			break
+		}
+	}
+	return atomic.AddUint64(&p.ackTxs, 1)%uint64(p.AckEveryNTransactions) == 0
+}
+
+func (p *PGXSource) storeAck(lsn uint64) {
+	atomic.StoreUint64(&p.pendingAckLsn, lsn)
+	if atomic.LoadInt32(&p.ackFrozen) == 0 {
+		atomic.StoreUint64(&p.ackLsn, lsn)
 	}
 }
 
+// FreezeAck keeps delivering changes but holds the slot position, the LSNs of the following Commit calls
+// are kept and applied by the UnfreezeAck
+func (p *PGXSource) FreezeAck() {
+	atomic.StoreInt32(&p.ackFrozen, 1)
+}
+
+// UnfreezeAck resumes advancing the slot position to the latest LSN of the Commit calls
+func (p *PGXSource) UnfreezeAck() {
+	if atomic.CompareAndSwapInt32(&p.ackFrozen, 1, 0) {
+		if lsn := atomic.LoadUint64(&p.pendingAckLsn); lsn != 0 {
+			atomic.StoreUint64(&p.ackLsn, lsn)
+		}
+	}
+}
+
+// CommitDurable reports the checkpoint durably persisted by the sink, which is the LSN that the slot advances to
+// if the DurableAck is enabled
+func (p *PGXSource) CommitDurable(cp cursor.Checkpoint) {
+	if cp.LSN != 0 {
+		atomic.StoreUint64(&p.durableLsn, cp.LSN)
+	}
+}
+
+// PrepareCommit records the checkpoint to be confirmed by the ConfirmCommit without advancing the slot, so that the consumer
+// writing to an external system can persist the checkpoint within its external transaction first. If it crashes before
+// the ConfirmCommit, the slot stays at the last confirmed checkpoint, and the Capture should be resumed from the checkpoint
+// persisted externally with the SuppressDuplicates, which drops the changes re-sent by the server up to it.
+func (p *PGXSource) PrepareCommit(cp cursor.Checkpoint) {
+	p.preparedMu.Lock()
+	p.prepared = cp
+	p.preparedMu.Unlock()
+}
+
+// ConfirmCommit advances the slot to the checkpoint of the last PrepareCommit, after the external transaction is committed
+func (p *PGXSource) ConfirmCommit() {
+	p.preparedMu.Lock()
+	cp := p.prepared
+	p.prepared = cursor.Checkpoint{}
+	p.preparedMu.Unlock()
+	p.Commit(cp)
+	p.CommitDurable(cp)
+}
+
 func (p *PGXSource) Requeue(cp cursor.Checkpoint, reason string) {
 }
 
@@ -232,10 +1195,42 @@ func (p *PGXSource) committedLSN() (lsn pglogrepl.LSN) {
 }
 
 func (p *PGXSource) reportLSN(ctx context.Context) error {
-	if committed := p.committedLSN(); committed != 0 {
-		return pglogrepl.SendStandbyStatusUpdate(ctx, p.replConn, pglogrepl.StandbyStatusUpdate{WALWritePosition: committed})
+	committed := p.committedLSN()
+	if committed == 0 {
+		return nil
 	}
-	return nil
+	p.metrics().Gauge(MetricCommittedLSN, float64(committed), map[string]string{"slot": p.ReplSlot})
+	status := pglogrepl.StandbyStatusUpdate{WALWritePosition: committed}
+	if p.DurableAck {
+		durable := pglogrepl.LSN(atomic.LoadUint64(&p.durableLsn))
+		if durable == 0 {
+			// the flush position falls back to the written position if zero
+			return nil
+		}
+		if durable > committed {
+			durable = committed
+		}
+		p.metrics().Gauge(MetricDurableLSN, float64(durable), map[string]string{"slot": p.ReplSlot})
+		status.WALFlushPosition = durable
+		status.WALApplyPosition = durable
+	}
+	return p.replConn.SendStandbyStatusUpdate(ctx, status)
+}
+
+// isTransientAckError reports whether the failure of sending the standby status update can be retried,
+// which is a timeout or a temporary resource shortage on a connection not closed.
+func (p *PGXSource) isTransientAckError(err error) bool {
+	if p.replConn.IsClosed() {
+		return false
+	}
+	return isTimeout(err) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// FinalReport returns the Report taken when the source is cleaned up, after the capture exits or fails to start,
+// and it is zero before that
+func (p *PGXSource) FinalReport() Report {
+	r, _ := p.finalReport.Load().(Report)
+	return r
 }
 
 func (p *PGXSource) cleanup() {
@@ -247,4 +1242,72 @@ func (p *PGXSource) cleanup() {
 		p.reportLSN(ctx)
 		p.replConn.Close(ctx)
 	}
+	if p.txBuffer != nil {
+		p.txBuffer.reset()
+	}
+	if p.pipeline != nil {
+		p.pipeline.close()
+	}
+	r := Report{
+		Slot:         p.ReplSlot,
+		CommittedLSN: uint64(p.committedLSN()),
+		ServerWALEnd: atomic.LoadUint64(&p.serverWALEnd),
+		Changes:      atomic.LoadUint64(&p.changeCount),
+		Reconnects:   atomic.LoadUint64(&p.reconnects),
+		Err:          p.Error(),
+		At:           p.clock().Now(),
+	}
+	p.finalReport.Store(r)
+	if p.LogFinalReport {
+		entry := logrus.WithFields(logrus.Fields{
+			"From":         "PGXSource",
+			"ReplSlot":     r.Slot,
+			"CommittedLSN": pglogrepl.LSN(r.CommittedLSN).String(),
+			"ServerWALEnd": pglogrepl.LSN(r.ServerWALEnd).String(),
+			"Changes":      r.Changes,
+			"Reconnects":   r.Reconnects,
+		})
+		if r.Err != nil {
+			entry = entry.WithError(r.Err)
+		}
+		entry.Info("final report of source")
+	}
+}
+
+type replicationConn interface {
+	IdentifySystem(ctx context.Context) (pglogrepl.IdentifySystemResult, error)
+	CreateReplicationSlot(ctx context.Context, slot, plugin string, options pglogrepl.CreateReplicationSlotOptions) (pglogrepl.CreateReplicationSlotResult, error)
+	StartReplication(ctx context.Context, slot string, lsn pglogrepl.LSN, options pglogrepl.StartReplicationOptions) error
+	SendStandbyStatusUpdate(ctx context.Context, status pglogrepl.StandbyStatusUpdate) error
+	ReceiveMessage(ctx context.Context) (pgproto3.BackendMessage, error)
+	Exec(ctx context.Context, sql string) error
+	ParameterStatus(key string) string
+	IsClosed() bool
+	CancelRequest(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+type pgReplicationConn struct {
+	*pgconn.PgConn
+}
+
+func (c *pgReplicationConn) IdentifySystem(ctx context.Context) (pglogrepl.IdentifySystemResult, error) {
+	return pglogrepl.IdentifySystem(ctx, c.PgConn)
+}
+
+func (c *pgReplicationConn) CreateReplicationSlot(ctx context.Context, slot, plugin string, options pglogrepl.CreateReplicationSlotOptions) (pglogrepl.CreateReplicationSlotResult, error) {
+	return pglogrepl.CreateReplicationSlot(ctx, c.PgConn, slot, plugin, options)
+}
+
+func (c *pgReplicationConn) StartReplication(ctx context.Context, slot string, lsn pglogrepl.LSN, options pglogrepl.StartReplicationOptions) error {
+	return pglogrepl.StartReplication(ctx, c.PgConn, slot, lsn, options)
+}
+
+func (c *pgReplicationConn) Exec(ctx context.Context, sql string) error {
+	_, err := c.PgConn.Exec(ctx, sql).ReadAll()
+	return err
+}
+
+func (c *pgReplicationConn) SendStandbyStatusUpdate(ctx context.Context, status pglogrepl.StandbyStatusUpdate) error {
+	return pglogrepl.SendStandbyStatusUpdate(ctx, c.PgConn, status)
 }
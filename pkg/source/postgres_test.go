@@ -3,17 +3,29 @@ package source
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/jackc/pglogrepl"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/replicase/pgcapture/internal/fault"
 	"github.com/replicase/pgcapture/internal/test"
 	"github.com/replicase/pgcapture/pkg/cursor"
 	"github.com/replicase/pgcapture/pkg/decode"
 	"github.com/replicase/pgcapture/pkg/pb"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -242,6 +254,1611 @@ func TestPGXSource_DuplicatedCapture(t *testing.T) {
 	}
 }
 
+func TestPGXSource_SavepointRollback(t *testing.T) {
+	for _, te := range pgxSourceTests {
+		t.Run(te.decodePlugin, func(t *testing.T) {
+			te.shouldSkip(t)
+
+			ctx := context.Background()
+			conn, err := te.newPGConn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close(ctx)
+
+			src := te.newPGXSource()
+			src.TransactionalDelivery = true
+			changes, err := src.Capture(cursor.Checkpoint{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Stop()
+
+			if _, err = conn.Exec(ctx, "create table t8 (id int)"); err != nil {
+				t.Fatal(err)
+			}
+			readTx(t, changes, 1)
+
+			// only the changes of the rolled back subtransaction are discarded
+			if _, err = conn.Exec(ctx, `begin;
+insert into t8 values (1);
+savepoint s1;
+insert into t8 values (2);
+savepoint s2;
+insert into t8 values (3);
+rollback to savepoint s2;
+insert into t8 values (4);
+release savepoint s1;
+savepoint s3;
+insert into t8 values (5);
+rollback to savepoint s3;
+insert into t8 values (6);
+commit;`); err != nil {
+				t.Fatal(err)
+			}
+			tx := readTx(t, changes, 4)
+			for i, id := range []int32{1, 2, 4, 6} {
+				if v := tx.Changes[i].Message.GetChange().New[0].GetBinary(); int32(binary.BigEndian.Uint32(v)) != id {
+					t.Fatalf("unexpected %v of %v", v, id)
+				}
+			}
+		})
+	}
+}
+
+func TestPGXSource_ExportSnapshot(t *testing.T) {
+	ctx := context.Background()
+	conn, err := newPGConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	conn.Exec(ctx, fmt.Sprintf("select pg_drop_replication_slot('%s')", TestSlot))
+	conn.Exec(ctx, fmt.Sprintf("DROP PUBLICATION %s", TestSlot))
+	if _, err = conn.Exec(ctx, "create table t9 as select * from generate_series(1,10) as id"); err != nil {
+		t.Fatal(err)
+	}
+
+	src := newPGXSource(decode.PGOutputPlugin)
+	src.CreateSlot = true
+	src.CreatePublication = true
+	var count int
+	src.ExportSnapshot = func(name string) error {
+		if got, err := src.SnapshotName(); err != nil || got != name {
+			t.Fatalf("unexpected %v %v", got, err)
+		}
+		// the rows inserted after the slot creation are not visible in the exported snapshot
+		if _, err := conn.Exec(ctx, "insert into t9 values (11)"); err != nil {
+			return err
+		}
+		tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+		if _, err = tx.Exec(ctx, "SET TRANSACTION SNAPSHOT '"+name+"'"); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, "select count(*) from t9").Scan(&count)
+	}
+	changes, err := src.Capture(cursor.Checkpoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Stop()
+	if count != 10 {
+		t.Fatalf("unexpected %v", count)
+	}
+	if name, err := src.SnapshotName(); name == "" || !errors.Is(err, ErrSnapshotExpired) {
+		t.Fatalf("unexpected %v %v", name, err)
+	}
+	// the row inserted after the snapshot is captured
+	tx := readTx(t, changes, 1)
+	if change := tx.Changes[0].Message.GetChange(); change.Table != "t9" {
+		t.Fatalf("unexpected %v", change)
+	}
+}
+
+func TestPGXSource_CreateSlotExportingSnapshot(t *testing.T) {
+	conn := &fakeReplConn{snapshot: "00000003-00000002-1"}
+	src := newFakePGXSource(conn)
+	src.DecodePlugin = decode.PGOutputPlugin
+	if _, err := src.SnapshotName(); !errors.Is(err, ErrSnapshotNotExported) {
+		t.Fatalf("unexpected %v", err)
+	}
+	if err := src.createSlotExportingSnapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if conn.created.SlotName != TestSlot || conn.created.OutputPlugin != decode.PGOutputPlugin {
+		t.Fatalf("unexpected %v", conn.created)
+	}
+	var exported string
+	src.ExportSnapshot = func(name string) error {
+		got, err := src.SnapshotName()
+		if err != nil || got != name {
+			t.Fatalf("unexpected %v %v", got, err)
+		}
+		exported = name
+		return nil
+	}
+	if err := src.exportSnapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if exported != conn.snapshot {
+		t.Fatalf("unexpected %v", exported)
+	}
+	if name, err := src.SnapshotName(); name != conn.snapshot || !errors.Is(err, ErrSnapshotExpired) {
+		t.Fatalf("unexpected %v %v", name, err)
+	}
+
+	// the replication is not started if the snapshot is not imported
+	failing := &fakeReplConn{snapshot: conn.snapshot}
+	src = newFakePGXSource(failing)
+	src.DecodePlugin = decode.PGOutputPlugin
+	if err := src.createSlotExportingSnapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("import failed")
+	src.ExportSnapshot = func(name string) error { return failed }
+	if err := src.exportSnapshot(context.Background()); !errors.Is(err, failed) {
+		t.Fatalf("unexpected %v", err)
+	}
+	if _, err := src.SnapshotName(); err != nil || failing.slot != "" {
+		t.Fatalf("unexpected %v %v", err, failing.slot)
+	}
+
+	// no snapshot is exported for the existing slot
+	src = newFakePGXSource(conn)
+	if err := src.createSlotExportingSnapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.SnapshotName(); !errors.Is(err, ErrSnapshotNotExported) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func TestPGXSource_SlotCreateTimeout(t *testing.T) {
+	conn := &fakeReplConn{blocked: make(chan struct{})}
+	src := newFakePGXSource(conn)
+	src.DecodePlugin = decode.PGOutputPlugin
+	src.SlotCreateTimeout = 50 * time.Millisecond
+	err := src.createSlotExportingSnapshot(context.Background())
+	var pge *pgconn.PgError
+	if !errors.Is(err, ErrSlotCreateTimeout) || !errors.As(err, &pge) || pge.Code != "57014" {
+		t.Fatalf("unexpected %v", err)
+	}
+	if conn.created.SlotName != "" {
+		t.Fatalf("unexpected %v", conn.created)
+	}
+}
+
+func TestPGXSource_SchemaCacheWorkers(t *testing.T) {
+	src := &PGXSource{SetupConnStr: "postgres://127.0.0.1:1/none", SchemaCacheTables: 10, DecodeWorkers: 2}
+	if _, err := src.Capture(cursor.Checkpoint{}); !errors.Is(err, ErrSchemaCacheWorkers) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func TestPGXSource_SlotCreateTimeoutWithOpenTransaction(t *testing.T) {
+	ctx := context.Background()
+	conn, err := newPGConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	conn.Exec(ctx, fmt.Sprintf("select pg_drop_replication_slot('%s')", TestSlot))
+
+	// the transaction with an assigned xid holds the consistent point of the slot creation until it finishes
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err = tx.Exec(ctx, "select txid_current()"); err != nil {
+		t.Fatal(err)
+	}
+
+	src := newPGXSource(decode.PGOutputPlugin)
+	src.CreateSlot = true
+	src.SlotCreateTimeout = time.Second
+	if _, err = src.Capture(cursor.Checkpoint{}); !errors.Is(err, ErrSlotCreateTimeout) {
+		t.Fatalf("unexpected %v", err)
+	}
+	var count int
+	if err = conn.QueryRow(ctx, "select count(*) from pg_replication_slots where slot_name = $1", TestSlot).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("the slot should not be created %v %v", count, err)
+	}
+}
+
+func TestPGXSource_UnchangedToastBytea(t *testing.T) {
+	for _, te := range pgxSourceTests {
+		t.Run(te.decodePlugin, func(t *testing.T) {
+			te.shouldSkip(t)
+
+			ctx := context.Background()
+			conn, err := te.newPGConn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close(ctx)
+
+			for _, q := range []string{
+				"create table t10 (id int primary key, data bytea, v int)",
+				// the external storage is not compressed, so the large value is always stored out of line
+				"alter table t10 alter column data set storage external",
+				"insert into t10 values (1, decode(repeat('ab', 100000), 'hex'), 1)",
+			} {
+				if _, err = conn.Exec(ctx, q); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			src := te.newPGXSource()
+			changes, err := src.Capture(cursor.Checkpoint{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Stop()
+
+			if _, err = conn.Exec(ctx, "update t10 set v = 2 where id = 1"); err != nil {
+				t.Fatal(err)
+			}
+			tx := readTx(t, changes, 1)
+			change := tx.Changes[0].Message.GetChange()
+			if change.Op != pb.Change_UPDATE || len(change.New) != 2 {
+				t.Fatalf("unexpected %v", change.String())
+			}
+			for _, f := range change.New {
+				if f.Name == "data" {
+					t.Fatalf("the unchanged toast should be omitted %v", change.String())
+				}
+			}
+		})
+	}
+}
+
+func TestPGXSource_CompositeTypeEvolution(t *testing.T) {
+	for _, te := range pgxSourceTests {
+		t.Run(te.decodePlugin, func(t *testing.T) {
+			te.shouldSkip(t)
+
+			ctx := context.Background()
+			conn, err := te.newPGConn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close(ctx)
+
+			for _, q := range []string{
+				"create type pair as (a int, b text)",
+				"create table t11 (id int primary key, v pair)",
+			} {
+				if _, err = conn.Exec(ctx, q); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			src := te.newPGXSource()
+			changes, err := src.Capture(cursor.Checkpoint{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Stop()
+
+			for _, tc := range []struct {
+				alter  string
+				insert string
+				attrs  []uint32
+			}{
+				{insert: "insert into t11 values (1, row(1, 'x'))", attrs: []uint32{23, 25}},
+				{alter: "alter type pair add attribute c int", insert: "insert into t11 values (2, row(2, 'y', 3))", attrs: []uint32{23, 25, 23}},
+				{alter: "alter type pair drop attribute b", insert: "insert into t11 values (3, row(3, 4))", attrs: []uint32{23, 23}},
+			} {
+				if tc.alter != "" {
+					if _, err = conn.Exec(ctx, tc.alter); err != nil {
+						t.Fatal(err)
+					}
+					if tx := readTx(t, changes, 1); !decode.IsDDL(tx.Changes[0].Message.GetChange()) {
+						t.Fatalf("unexpected %v", tx.Changes[0].Message.String())
+					}
+				}
+				if _, err = conn.Exec(ctx, tc.insert); err != nil {
+					t.Fatal(err)
+				}
+				tx := readTx(t, changes, 1)
+				var v []byte
+				for _, f := range tx.Changes[0].Message.GetChange().New {
+					if f.Name == "v" {
+						v = f.GetBinary()
+					}
+				}
+				if attrs := compositeAttrs(v); !reflect.DeepEqual(attrs, tc.attrs) {
+					t.Fatalf("unexpected attributes %v of %v", attrs, tc.insert)
+				}
+			}
+		})
+	}
+}
+
+// compositeAttrs returns the type oids of the attributes of the binary composite
+func compositeAttrs(bs []byte) (oids []uint32) {
+	if len(bs) < 4 {
+		return nil
+	}
+	n := binary.BigEndian.Uint32(bs)
+	for i, off := uint32(0), 4; i < n && off+8 <= len(bs); i++ {
+		oids = append(oids, binary.BigEndian.Uint32(bs[off:]))
+		size := int32(binary.BigEndian.Uint32(bs[off+4:]))
+		off += 8
+		if size > 0 {
+			off += int(size)
+		}
+	}
+	return oids
+}
+
+func TestPGXSource_PublicationRowFilters(t *testing.T) {
+	test.ShouldSkipTestByPGVersion(t, 15)
+	te := pgxSourceTests[1]
+	ctx := context.Background()
+	conn, err := te.newPGConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	for _, q := range []string{
+		"create table t12 (id int primary key, v int)",
+		"create table t13 (id int primary key)",
+		"create table t14 (id int primary key)",
+	} {
+		if _, err = conn.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the filter of the table not listed is rejected
+	src := te.newPGXSource()
+	src.PublicationRowFilters = map[string]string{"public.t12": "id > 10"}
+	if _, err = src.Capture(cursor.Checkpoint{}); !errors.Is(err, ErrPublicationTables) {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	// the filter on the column not in the replica identity is rejected
+	src = te.newPGXSource()
+	src.PublicationTables = []string{"public.t12", "public.t13"}
+	src.PublicationRowFilters = map[string]string{"public.t12": "v > 1"}
+	if _, err = src.Capture(cursor.Checkpoint{}); !errors.Is(err, ErrRowFilterColumn) || err.Error() != ErrRowFilterColumn.Error()+": public.t12 uses v" {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	// the existing publication for all tables can not be replaced with the filtered tables
+	if _, err = conn.Exec(ctx, fmt.Sprintf("CREATE PUBLICATION %s FOR ALL TABLES", TestSlot)); err != nil {
+		t.Fatal(err)
+	}
+	src = te.newPGXSource()
+	src.PublicationTables = []string{"public.t12", "public.t13"}
+	src.PublicationRowFilters = map[string]string{"public.t12": "id > 10"}
+	if _, err = src.Capture(cursor.Checkpoint{}); !errors.Is(err, ErrPublicationAllTables) || err.Error() != ErrPublicationAllTables.Error()+": "+TestSlot {
+		t.Fatalf("unexpected %v", err)
+	}
+	if _, err = conn.Exec(ctx, fmt.Sprintf("DROP PUBLICATION %s", TestSlot)); err != nil {
+		t.Fatal(err)
+	}
+
+	src = te.newPGXSource()
+	src.PublicationTables = []string{"public.t12", "public.t13"}
+	src.PublicationRowFilters = map[string]string{"public.t12": "id > 10"}
+	changes, err := src.Capture(cursor.Checkpoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Stop()
+
+	for _, q := range []string{
+		"insert into t12 values (1, 1)",
+		"insert into t13 values (11)",
+		"insert into t14 values (11)",
+		"insert into t12 values (11, 1)",
+		"update t12 set v = 2 where id = 1",
+		"update t12 set v = 2 where id = 11",
+	} {
+		if _, err = conn.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the listed t13 is fully published, and the t14 not listed is not
+	for _, expect := range []struct {
+		table string
+		op    pb.Change_Operation
+	}{
+		{table: "t13", op: pb.Change_INSERT},
+		{table: "t12", op: pb.Change_INSERT},
+		{table: "t12", op: pb.Change_UPDATE},
+	} {
+		tx := readTx(t, changes, 1)
+		change := tx.Changes[0].Message.GetChange()
+		if change.Table != expect.table || change.Op != expect.op || !proto.Equal(change.New[0], &pb.Field{Name: "id", Oid: 23, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 11}}}) {
+			t.Fatalf("unexpected %v", change.String())
+		}
+	}
+}
+
+func TestPGXSource_ReplicaIdentityCheck(t *testing.T) {
+	ctx := context.Background()
+	conn, err := newPGConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	for _, q := range []string{
+		"create table t4 (id int primary key)",
+		"create table t5 (id int)",
+		"alter table t5 replica identity nothing",
+		"create table t6 (id int)",
+		"create table t7 (id int not null)",
+		"create unique index t7_id on t7 (id)",
+		"alter table t7 replica identity using index t7_id",
+	} {
+		if _, err = conn.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := newPGXSource(decode.PGOutputPlugin)
+	src.ReplicaIdentityCheck = ReplicaIdentityCheckError
+	if _, err = src.Capture(cursor.Checkpoint{}); !errors.Is(err, ErrReplicaIdentity) || err.Error() != ErrReplicaIdentity.Error()+": public.t5, public.t6" {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func TestPGXSource_DerefLargeObject(t *testing.T) {
+	for _, te := range pgxSourceTests {
+		t.Run(te.decodePlugin, func(t *testing.T) {
+			te.shouldSkip(t)
+
+			ctx := context.Background()
+			conn, err := te.newPGConn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close(ctx)
+
+			var missing uint32
+			if err = conn.QueryRow(ctx, "select lo_from_bytea(0, 'missing')").Scan(&missing); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = conn.Exec(ctx, "select lo_unlink($1)", missing); err != nil {
+				t.Fatal(err)
+			}
+
+			src := te.newPGXSource()
+			src.DerefLargeObject = true
+			src.LargeObjectSizeLimit = 5
+			changes, err := src.Capture(cursor.Checkpoint{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Stop()
+
+			if _, err = conn.Exec(ctx, "create table t3 (id int, data oid)"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = conn.Exec(ctx, "insert into t3 values (1, lo_from_bytea(0, 'hello')), (2, lo_from_bytea(0, 'too large')), (3, $1)", missing); err != nil {
+				t.Fatal(err)
+			}
+
+			readTx(t, changes, 1)
+			tx := readTx(t, changes, 3)
+
+			if data := tx.Changes[0].Message.GetChange().New[1]; data.Oid != 17 || !bytes.Equal(data.GetBinary(), []byte("hello")) {
+				t.Fatalf("unexpected %v", data.String())
+			}
+			if data := tx.Changes[1].Message.GetChange().New[1]; data.Oid != 26 {
+				t.Fatalf("large object exceeding the limit should be passed through: %v", data.String())
+			}
+			if data := tx.Changes[2].Message.GetChange().New[1]; data.Oid != 26 || binary.BigEndian.Uint32(data.GetBinary()) != missing {
+				t.Fatalf("missing large object should be passed through: %v", data.String())
+			}
+		})
+	}
+}
+
+func TestPGXSource_StartupParamsFunc(t *testing.T) {
+	conn := &fakeReplConn{}
+	src := &PGXSource{
+		ReplSlot:   TestSlot,
+		replConn:   conn,
+		decoder:    &fakeDecoder{pluginArgs: []string{"proto_version '1'"}},
+		currentLsn: 100,
+		StartupParamsFunc: func(defaults []string) []string {
+			return append(defaults, "custom_filter 'on'")
+		},
+	}
+	if err := src.startReplication(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if conn.slot != TestSlot || conn.lsn != 100 {
+		t.Fatalf("unexpected start position %v %v", conn.slot, conn.lsn)
+	}
+	if args := conn.options.PluginArgs; len(args) != 2 || args[0] != "proto_version '1'" || args[1] != "custom_filter 'on'" {
+		t.Fatalf("unexpected plugin args %v", args)
+	}
+	if args := src.decoder.GetPluginArgs(); len(args) != 1 {
+		t.Fatalf("the default plugin args should not be mutated %v", args)
+	}
+}
+
+func TestPGXSource_SessionSettings(t *testing.T) {
+	conn := &fakeReplConn{}
+	src := &PGXSource{
+		ReplSlot:        TestSlot,
+		replConn:        conn,
+		decoder:         &fakeDecoder{},
+		SessionSettings: map[string]string{"bytea_output": "hex", "DateStyle": "ISO, MDY", "search_path": "it's"},
+	}
+	if err := src.startReplication(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.execs) != 1 || conn.execs[0] != `SET "DateStyle" TO 'ISO, MDY';SET "bytea_output" TO 'hex';SET "search_path" TO 'it''s';` {
+		t.Fatalf("unexpected %v", conn.execs)
+	}
+}
+
+func TestPGXSource_SessionSettingsApplied(t *testing.T) {
+	ctx := context.Background()
+	conn, err := newPGConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	src := newPGXSource(decode.PGOutputPlugin)
+	src.CreateSlot = true
+	src.CreatePublication = true
+	src.SessionSettings = map[string]string{"DateStyle": "SQL, DMY", "bytea_output": "escape"}
+	if _, err = src.Capture(cursor.Checkpoint{}); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Stop()
+
+	for _, c := range []*pgconn.PgConn{src.setupConn.PgConn(), src.replConn.(*pgReplicationConn).PgConn} {
+		if v := c.ParameterStatus("DateStyle"); v != "SQL, DMY" {
+			t.Fatalf("unexpected DateStyle %v", v)
+		}
+	}
+	var output string
+	if err = src.setupConn.QueryRow(ctx, "SHOW bytea_output").Scan(&output); err != nil || output != "escape" {
+		t.Fatalf("unexpected %v %v", output, err)
+	}
+}
+
+func TestPGXSource_SetApplicationName(t *testing.T) {
+	for _, tc := range []struct {
+		option string
+		params map[string]string
+		expect string
+	}{
+		{params: map[string]string{}, expect: "pgcapture-" + TestSlot},
+		{params: map[string]string{"application_name": "from_dsn"}, expect: "from_dsn"},
+		{option: "custom", params: map[string]string{"application_name": "from_dsn"}, expect: "custom"},
+	} {
+		src := &PGXSource{ReplSlot: TestSlot, ApplicationName: tc.option}
+		src.setApplicationName(tc.params)
+		if v := tc.params["application_name"]; v != tc.expect {
+			t.Fatalf("unexpected %v", v)
+		}
+	}
+}
+
+func TestPGXSource_ApplicationName(t *testing.T) {
+	ctx := context.Background()
+	conn, err := newPGConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	src := newPGXSource(decode.PGOutputPlugin)
+	src.CreateSlot = true
+	src.CreatePublication = true
+	src.ApplicationName = "pgcapture-test-app"
+	if _, err = src.Capture(cursor.Checkpoint{}); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Stop()
+
+	var count int
+	for _, view := range []string{"pg_stat_replication", "pg_stat_activity"} {
+		if err = conn.QueryRow(ctx, "SELECT count(*) FROM "+view+" WHERE application_name = $1", src.ApplicationName).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count == 0 {
+			t.Fatalf("application_name not found in %s", view)
+		}
+	}
+}
+
+func TestPGXSource_SuppressDuplicates(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	src.SuppressDuplicates = true
+	src.resume(cursor.Checkpoint{LSN: 200, Seq: 2})
+	metrics := &memoryMetricsSink{}
+	src.Metrics = metrics
+
+	// the server re-sends the transactions which are already delivered before the resume checkpoint
+	for _, lsn := range []uint64{150, 200, 300} {
+		for _, m := range fakeTx(lsn) {
+			conn.messages <- xLogData(lsn, m)
+		}
+	}
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := readTx(t, changes, 1)
+	if tx.Begin.Checkpoint.LSN != 300 {
+		t.Fatalf("unexpected %v", tx.Begin.Checkpoint)
+	}
+	src.Stop()
+
+	if n, _ := metrics.sum("counter", MetricSuppressedDuplicates, map[string]string{"slot": TestSlot}); n != 6 {
+		t.Fatalf("unexpected suppressed count %v", n)
+	}
+}
+
+func TestPGXSource_AlignToTransaction(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		head []*pb.Message
+	}{
+		// resumed inside the transaction 100, whose changes and commit are received without its begin
+		{name: "mid-transaction", head: fakeTx(100)[1:]},
+		// resumed at the commit boundary of the transaction 100
+		{name: "commit boundary"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+			for _, m := range c.head {
+				conn.messages <- xLogData(100, m)
+			}
+			for _, lsn := range []uint64{200, 300} {
+				for _, m := range fakeTx(lsn) {
+					conn.messages <- xLogData(lsn, m)
+				}
+			}
+			src := newFakePGXSource(conn)
+			src.AlignToTransaction = true
+			src.resume(cursor.Checkpoint{LSN: 90})
+
+			changes, err := src.BaseSource.capture(src.fetching, func() {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, lsn := range []uint64{200, 300} {
+				if tx := readTx(t, changes, 1); tx.Begin.Checkpoint.LSN != lsn || tx.Commit.Checkpoint.LSN != lsn {
+					t.Fatalf("unexpected %v %v", tx.Begin.Checkpoint, tx.Commit.Checkpoint)
+				}
+			}
+			if err = src.Stop(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+type fakeFingerprintDecoder struct {
+	fakeDecoder
+	fingerprints map[string]string
+}
+
+func (d *fakeFingerprintDecoder) SchemaFingerprint(namespace, table string) (fingerprint string, ok bool) {
+	fingerprint, ok = d.fingerprints[namespace+"."+table]
+	return
+}
+
+func TestPGXSource_SchemaFingerprint(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 20)}
+	decoder := &fakeFingerprintDecoder{fingerprints: map[string]string{"public.t1": "a"}}
+	src := newFakePGXSource(conn)
+	src.decoder = decoder
+	src.SchemaFingerprint = true
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expect := range []struct {
+		layout      string
+		fingerprint string
+	}{
+		{layout: "a", fingerprint: "a"},
+		// only the first change of each layout is fingerprinted
+		{layout: "a", fingerprint: ""},
+		{layout: "b", fingerprint: "b"},
+		{layout: "b", fingerprint: ""},
+	} {
+		// the layout is changed by the relation message before the change
+		decoder.fingerprints["public.t1"] = expect.layout
+		lsn := uint64(100 * (i + 1))
+		for _, m := range fakeTx(lsn) {
+			conn.messages <- xLogData(lsn, m)
+		}
+		tx := readTx(t, changes, 1)
+		if tx.Changes[0].SchemaFingerprint != expect.fingerprint || tx.Begin.SchemaFingerprint != "" || tx.Commit.SchemaFingerprint != "" {
+			t.Fatalf("unexpected %q, expected %q", tx.Changes[0].SchemaFingerprint, expect.fingerprint)
+		}
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPGXSource_SystemColumns(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+		for _, m := range fakeTx(100) {
+			if c := m.GetChange(); c != nil {
+				c.New = []*pb.Field{
+					{Name: "id", Oid: 23, Value: &pb.Field_Text{Text: "1"}},
+					{Name: "xmin", Oid: pgtype.XIDOID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0x30, 0x39}}},
+					{Name: "cmin", Oid: pgtype.CIDOID, Value: &pb.Field_Binary{Binary: []byte{0, 0, 0, 1}}},
+				}
+			}
+			conn.messages <- xLogData(100, m)
+		}
+		src := newFakePGXSource(conn)
+		src.SystemColumns = enabled
+		changes, err := src.BaseSource.capture(src.fetching, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := readTx(t, changes, 1).Changes[0]
+		if !enabled {
+			if c.SystemColumns != nil || len(c.Message.GetChange().New) != 3 {
+				t.Fatalf("unexpected %v %v", c.SystemColumns, c.Message.String())
+			}
+		} else {
+			if !reflect.DeepEqual(c.SystemColumns, map[string]uint32{"xmin": 12345, "cmin": 1}) {
+				t.Fatalf("unexpected %v", c.SystemColumns)
+			}
+			if fields := c.Message.GetChange().New; len(fields) != 1 || fields[0].Name != "id" {
+				t.Fatalf("unexpected %v", fields)
+			}
+		}
+		if err = src.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPGXSource_ExcludeApplicationNames(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 20)}
+	for i, lsn := range []uint64{100, 200, 300} {
+		for _, m := range fakeTx(lsn) {
+			if b := m.GetBegin(); b != nil {
+				b.RemoteXid = uint32(i + 1)
+			}
+			conn.messages <- xLogData(lsn, m)
+		}
+	}
+	// the synthetic tagging, and the writer of the xid 3 is not resolved
+	apps := map[uint32]string{1: "web", 2: "batch"}
+	src := newFakePGXSource(conn)
+	src.ApplicationNameFunc = func(xid uint32) string { return apps[xid] }
+	src.ExcludeApplicationNames = []string{"batch"}
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []struct {
+		app     string
+		changes int
+	}{
+		{app: "web", changes: 1},
+		// the row changes of the excluded application are dropped, while the transaction boundaries are kept
+		{app: "batch", changes: 0},
+		{app: "", changes: 1},
+	} {
+		tx := readTx(t, changes, expect.changes)
+		for _, c := range append([]Change{tx.Begin, tx.Commit}, tx.Changes...) {
+			if c.ApplicationName != expect.app {
+				t.Fatalf("unexpected %v %v", c.ApplicationName, c.Message.String())
+			}
+		}
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPGXSource_ApplicationNameSpill(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	for _, m := range fakeTx(100) {
+		conn.messages <- xLogData(100, m)
+	}
+	src := newFakePGXSource(conn)
+	src.ApplicationNameFunc = func(xid uint32) string { return "web" }
+	src.TransactionalDelivery = true
+	src.MaxInFlightBytes = 1
+	src.SpillDir = t.TempDir()
+	if err := src.initTxBuffer(); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := src.BaseSource.capture(src.reading, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the tags of the spilled transaction are replayed too
+	tx := readTx(t, changes, 1)
+	for _, c := range append([]Change{tx.Begin, tx.Commit}, tx.Changes...) {
+		if c.ApplicationName != "web" {
+			t.Fatalf("unexpected %q %v", c.ApplicationName, c.Message.String())
+		}
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPGXSource_Metrics(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	metrics := &memoryMetricsSink{}
+	src.Metrics = metrics
+	src.Commit(cursor.Checkpoint{LSN: 50})
+	for _, m := range fakeTx(100) {
+		conn.messages <- xLogData(100, m)
+	}
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readTx(t, changes, 1)
+	src.Stop()
+
+	for _, typ := range []string{"begin", "change", "commit"} {
+		if n, _ := metrics.sum("counter", MetricMessages, map[string]string{"slot": TestSlot, "type": typ}); n != 1 {
+			t.Fatalf("unexpected %v count %v", typ, n)
+		}
+	}
+	if _, n := metrics.sum("histogram", MetricMessageBytes, map[string]string{"slot": TestSlot}); n != 3 {
+		t.Fatalf("unexpected message bytes observations %v", n)
+	}
+	if v, n := metrics.sum("gauge", MetricCommittedLSN, map[string]string{"slot": TestSlot}); n == 0 || v != float64(50*n) {
+		t.Fatalf("unexpected committed lsn %v", metrics.calls)
+	}
+}
+
+func TestPGXSource_WALPositions(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+
+	conn.messages <- xLogDataWithEnd(90, 500, &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{FinalLsn: 100}}})
+	conn.messages <- xLogDataWithEnd(92, 500, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"}}})
+	conn.messages <- xLogDataWithEnd(96, 510, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"}}})
+	conn.messages <- xLogDataWithEnd(100, 520, &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{CommitLsn: 100, EndLsn: 108}}})
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := readTx(t, changes, 2)
+	src.Stop()
+
+	for i, c := range []struct {
+		change       Change
+		walStart     uint64
+		serverWALEnd uint64
+		endLSN       uint64
+	}{
+		{change: tx.Begin, walStart: 90, serverWALEnd: 500},
+		{change: tx.Changes[0], walStart: 92, serverWALEnd: 500},
+		{change: tx.Changes[1], walStart: 96, serverWALEnd: 510},
+		{change: tx.Commit, walStart: 100, serverWALEnd: 520, endLSN: 108},
+	} {
+		if c.change.WALStart != c.walStart || c.change.ServerWALEnd != c.serverWALEnd || c.change.EndLSN != c.endLSN {
+			t.Fatalf("unexpected wal positions of %d: %v %v %v", i, c.change.WALStart, c.change.ServerWALEnd, c.change.EndLSN)
+		}
+		if c.change.CommitLSN != 100 || c.change.Checkpoint.LSN != 100 {
+			t.Fatalf("unexpected commit lsn of %d: %v %v", i, c.change.CommitLSN, c.change.Checkpoint)
+		}
+	}
+}
+
+func TestPGXSource_TransactionalDeliveryWALPositions(t *testing.T) {
+	// the end of the commit is set on all the changes of the transaction, either in memory or spilled
+	for _, limit := range []int{0, 1} {
+		conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+		src := newFakePGXSource(conn)
+		src.TransactionalDelivery = true
+		src.MaxInFlightBytes = limit
+		src.SpillDir = t.TempDir()
+		if err := src.initTxBuffer(); err != nil {
+			t.Fatal(err)
+		}
+
+		conn.messages <- xLogDataWithEnd(90, 500, &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{FinalLsn: 100}}})
+		conn.messages <- xLogDataWithEnd(92, 500, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"}}})
+		conn.messages <- xLogDataWithEnd(96, 510, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"}}})
+		conn.messages <- xLogDataWithEnd(100, 520, &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{CommitLsn: 100, EndLsn: 108}}})
+
+		changes, err := src.BaseSource.capture(src.reading, src.cleanup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := readTx(t, changes, 2)
+		src.Stop()
+
+		for i, c := range []struct {
+			change       Change
+			walStart     uint64
+			serverWALEnd uint64
+		}{
+			{change: tx.Begin, walStart: 90, serverWALEnd: 500},
+			{change: tx.Changes[0], walStart: 92, serverWALEnd: 500},
+			{change: tx.Changes[1], walStart: 96, serverWALEnd: 510},
+			{change: tx.Commit, walStart: 100, serverWALEnd: 520},
+		} {
+			if c.change.WALStart != c.walStart || c.change.ServerWALEnd != c.serverWALEnd || c.change.EndLSN != 108 || c.change.CommitLSN != 100 {
+				t.Fatalf("unexpected wal positions of %d with limit %d: %v %v %v %v", i, limit, c.change.WALStart, c.change.ServerWALEnd, c.change.CommitLSN, c.change.EndLSN)
+			}
+		}
+	}
+}
+
+func TestPGXSource_DDLDelivery(t *testing.T) {
+	for _, tc := range []struct {
+		mode    DDLDelivery
+		emit    bool
+		refresh bool
+	}{
+		{mode: DDLDeliveryEmit, emit: true, refresh: true},
+		{mode: DDLDeliveryNone, emit: false, refresh: false},
+		{mode: DDLDeliveryRefreshOnly, emit: false, refresh: true},
+		{mode: DDLDeliveryEmitNoRefresh, emit: true, refresh: false},
+	} {
+		conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+		src := newFakePGXSource(conn)
+		src.DDLDelivery = tc.mode
+		refreshed := 0
+		src.refreshType = func() error {
+			refreshed++
+			return nil
+		}
+
+		conn.messages <- xLogData(100, &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{FinalLsn: 100}}})
+		conn.messages <- xLogData(100, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: decode.ExtensionSchema, Table: decode.ExtensionDDLLogs}}})
+		conn.messages <- xLogData(100, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"}}})
+		conn.messages <- xLogData(100, &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{CommitLsn: 100, EndLsn: 101}}})
+
+		changes, err := src.BaseSource.capture(src.fetching, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var received []*pb.Change
+		for m := <-changes; m.Message.GetCommit() == nil; m = <-changes {
+			if c := m.Message.GetChange(); c != nil {
+				received = append(received, c)
+			}
+		}
+		src.Stop()
+
+		if tc.emit && (len(received) != 2 || !decode.IsDDL(received[0])) || !tc.emit && (len(received) != 1 || decode.IsDDL(received[0])) {
+			t.Fatalf("unexpected delivered changes of mode %v: %v", tc.mode, received)
+		}
+		if tc.refresh && refreshed != 1 || !tc.refresh && refreshed != 0 {
+			t.Fatalf("unexpected refresh count of mode %v: %v", tc.mode, refreshed)
+		}
+	}
+}
+
+func TestPGXSource_DDLOnly(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	src.DDLOnly = true
+	src.refreshType = func() error { return nil }
+
+	for _, m := range fakeTx(100) {
+		conn.messages <- xLogData(100, m)
+	}
+	conn.messages <- xLogData(200, &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{FinalLsn: 200}}})
+	conn.messages <- xLogData(200, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"}}})
+	conn.messages <- xLogData(200, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: decode.ExtensionSchema, Table: decode.ExtensionDDLLogs}}})
+	conn.messages <- xLogData(200, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_DELETE, Schema: "public", Table: "t1"}}})
+	conn.messages <- xLogData(200, &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{CommitLsn: 200, EndLsn: 201}}})
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the transaction of only row changes is still delivered without changes
+	if tx := readTx(t, changes, 0); tx.Commit.Checkpoint.LSN != 100 {
+		t.Fatalf("unexpected %v", tx.Commit)
+	}
+	tx := readTx(t, changes, 1)
+	if !decode.IsDDL(tx.Changes[0].Message.GetChange()) || tx.Commit.Checkpoint.LSN != 200 {
+		t.Fatalf("unexpected %v", tx)
+	}
+
+	src.Commit(tx.Commit.Checkpoint)
+	if err = src.reportLSN(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	src.Stop()
+	if u := conn.updates[len(conn.updates)-1]; u.WALWritePosition != 200 {
+		t.Fatalf("unexpected %v", u)
+	}
+}
+
+func TestPGXSource_GlobalSeq(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	for _, m := range fakeTx(100) {
+		conn.messages <- xLogData(100, m)
+	}
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := readTx(t, changes, 1)
+	src.Stop()
+
+	for i, m := range []Change{tx.Begin, tx.Changes[0], tx.Commit} {
+		if m.Checkpoint.GlobalSeq != uint64(i+1) {
+			t.Fatalf("unexpected global seq %v", m.Checkpoint)
+		}
+	}
+
+	// simulate a restart from the last checkpoint, the re-sent messages should not reuse the sequence
+	conn = &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src = newFakePGXSource(conn)
+	src.SuppressDuplicates = true
+	src.resume(tx.Commit.Checkpoint)
+	for _, lsn := range []uint64{100, 200} {
+		for _, m := range fakeTx(lsn) {
+			conn.messages <- xLogData(lsn, m)
+		}
+	}
+
+	changes, err = src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx = readTx(t, changes, 1)
+	src.Stop()
+
+	for i, m := range []Change{tx.Begin, tx.Changes[0], tx.Commit} {
+		if m.Checkpoint.LSN != 200 || m.Checkpoint.GlobalSeq != uint64(i+4) {
+			t.Fatalf("unexpected global seq after restart %v", m.Checkpoint)
+		}
+	}
+}
+
+func TestPGXSource_AckEveryNTransactions(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 40)}
+	src := newFakePGXSource(conn)
+	src.AckEveryNTransactions = 3
+	// the position started from by the Capture
+	src.seedCommit(cursor.Checkpoint{LSN: 50})
+	for i := uint64(1); i <= 10; i++ {
+		for _, m := range fakeTx(i * 100) {
+			conn.messages <- xLogData(i*100, m)
+		}
+	}
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reported LSN steps only at every 3 transactions, and the repeated commits are not counted
+	for _, expect := range []pglogrepl.LSN{50, 50, 300, 300, 300, 600, 600, 600, 900, 900} {
+		tx := readTx(t, changes, 1)
+		src.Commit(tx.Commit.Checkpoint)
+		src.Commit(tx.Commit.Checkpoint)
+		if lsn := src.committedLSN(); lsn != expect {
+			t.Fatalf("unexpected %v at %v, expected %v", lsn, tx.Commit.Checkpoint.LSN, expect)
+		}
+		if err = src.reportLSN(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Stop()
+
+	var reported []pglogrepl.LSN
+	for _, u := range conn.updates {
+		if len(reported) == 0 || reported[len(reported)-1] != u.WALWritePosition {
+			reported = append(reported, u.WALWritePosition)
+		}
+	}
+	if !reflect.DeepEqual(reported, []pglogrepl.LSN{50, 300, 600, 900}) {
+		t.Fatalf("unexpected %v", reported)
+	}
+	if c := src.TxCounter(); c != 21 {
+		t.Fatalf("unexpected %v", c)
+	}
+}
+
+func TestPGXSource_AckEveryNTransactionsCapture(t *testing.T) {
+	ctx := context.Background()
+	conn, err := newPGConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	conn.Exec(ctx, fmt.Sprintf("select pg_drop_replication_slot('%s')", TestSlot))
+	conn.Exec(ctx, fmt.Sprintf("DROP PUBLICATION %s", TestSlot))
+
+	src := newPGXSource(decode.PGOutputPlugin)
+	src.CreateSlot = true
+	src.CreatePublication = true
+	src.AckEveryNTransactions = 3
+	if _, err = src.Capture(cursor.Checkpoint{}); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Stop()
+
+	// the started position is committed without waiting for the transactions
+	if lsn := src.committedLSN(); lsn == 0 {
+		t.Fatalf("the started position should be committed, got %v", lsn)
+	}
+}
+
+func TestPGXSource_FreezeAck(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	for _, lsn := range []uint64{100, 200, 300} {
+		for _, m := range fakeTx(lsn) {
+			conn.messages <- xLogData(lsn, m)
+		}
+	}
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := readTx(t, changes, 1)
+	src.Commit(tx.Commit.Checkpoint)
+	if lsn := src.committedLSN(); lsn != 100 {
+		t.Fatalf("unexpected %v", lsn)
+	}
+
+	src.FreezeAck()
+	for _, lsn := range []uint64{200, 300} {
+		tx = readTx(t, changes, 1)
+		if tx.Commit.Checkpoint.LSN != lsn {
+			t.Fatalf("unexpected %v", tx.Commit.Checkpoint)
+		}
+		src.Commit(tx.Commit.Checkpoint)
+		if lsn := src.committedLSN(); lsn != 100 {
+			t.Fatalf("committed lsn should be held while frozen %v", lsn)
+		}
+	}
+	src.Stop()
+
+	if err = src.reportLSN(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if u := conn.updates[len(conn.updates)-1]; u.WALWritePosition != 100 {
+		t.Fatalf("unexpected %v", u)
+	}
+	if c := src.TxCounter(); c != 3 {
+		t.Fatalf("unexpected %v", c)
+	}
+
+	src.UnfreezeAck()
+	if lsn := src.committedLSN(); lsn != 300 {
+		t.Fatalf("committed lsn should jump to the latest %v", lsn)
+	}
+	src.UnfreezeAck()
+	if lsn := src.committedLSN(); lsn != 300 {
+		t.Fatalf("unexpected %v", lsn)
+	}
+}
+
+func TestPGXSource_FaultReconnect(t *testing.T) {
+	// every connection re-sends all the transactions, and the delivered ones should be suppressed
+	replay := func() *fakeReplConn {
+		conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+		for _, lsn := range []uint64{100, 200, 300} {
+			for _, m := range fakeTx(lsn) {
+				conn.messages <- xLogData(lsn, m)
+			}
+		}
+		return conn
+	}
+	src := newFakePGXSource(replay())
+	src.MaxReconnects = 3
+	src.ReconnectBackoff = time.Millisecond
+	// fails at decoding the second transaction on the first connection, and the third one on the second connection
+	src.FaultInjector = fault.NewInjector().FailAtLSN(fault.Decode, 200, nil).FailAtLSN(fault.Decode, 300, nil)
+	dials := 0
+	src.dialRepl = func(ctx context.Context) (replicationConn, error) {
+		dials++
+		return replay(), nil
+	}
+	if err := src.startReplication(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := src.BaseSource.capture(src.receiving, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lsn := range []uint64{100, 200, 300} {
+		tx := readTx(t, changes, 1)
+		if tx.Begin.Checkpoint.LSN != lsn || tx.Commit.Checkpoint.LSN != lsn {
+			t.Fatalf("unexpected %v %v", tx.Begin, tx.Commit)
+		}
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	for change := range changes {
+		t.Fatalf("unexpected %v", change)
+	}
+	if dials != 2 || src.reconnects != 2 {
+		t.Fatalf("unexpected %v %v", dials, src.reconnects)
+	}
+}
+
+func TestPGXSource_TransientAckFailure(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10), updateErrs: []error{syscall.ENOBUFS, os.ErrDeadlineExceeded}}
+	src := newFakePGXSource(conn)
+	metrics := &memoryMetricsSink{}
+	src.Metrics = metrics
+	src.Commit(cursor.Checkpoint{LSN: 50})
+	for _, lsn := range []uint64{100, 200} {
+		for _, m := range fakeTx(lsn) {
+			conn.messages <- xLogData(lsn, m)
+		}
+	}
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readTx(t, changes, 1)
+	readTx(t, changes, 1)
+	if err = src.Stop(); err != nil {
+		t.Fatalf("capture should continue after transient ack failures %v", err)
+	}
+	if len(conn.updates) != 1 || conn.updates[0].WALWritePosition != 50 {
+		t.Fatalf("unexpected %v", conn.updates)
+	}
+	if total, _ := metrics.sum("counter", MetricAckFailures, map[string]string{"slot": TestSlot, "transient": "true"}); total != 2 {
+		t.Fatalf("unexpected %v", total)
+	}
+}
+
+func TestPGXSource_DeadAckFailure(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		closed bool
+	}{
+		{err: syscall.ECONNRESET},
+		{err: syscall.ENOBUFS, closed: true},
+	} {
+		conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10), updateErrs: []error{tc.err}, closed: tc.closed}
+		src := newFakePGXSource(conn)
+		src.Commit(cursor.Checkpoint{LSN: 50})
+		changes, err := src.BaseSource.capture(src.fetching, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, more := <-changes; more {
+			t.Fatal("changes should be closed")
+		}
+		if err = src.Error(); !errors.Is(err, tc.err) {
+			t.Fatalf("unexpected %v", err)
+		}
+	}
+}
+
+func TestPGXSource_ParameterStatus(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	src.setParameterStatus("TimeZone", "UTC")
+	for _, m := range fakeTx(100) {
+		conn.messages <- xLogData(100, m)
+	}
+	conn.messages <- &pgproto3.ParameterStatus{Name: "TimeZone", Value: "Asia/Taipei"}
+	for _, m := range fakeTx(200) {
+		conn.messages <- xLogData(200, m)
+	}
+
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readTx(t, changes, 1)
+	if tx := readTx(t, changes, 1); tx.Commit.Checkpoint.LSN != 200 {
+		t.Fatalf("unexpected %v", tx.Commit.Checkpoint)
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatalf("unexpected %v", err)
+	}
+	if v := src.ParameterStatus("TimeZone"); v != "Asia/Taipei" {
+		t.Fatalf("unexpected %v", v)
+	}
+}
+
+func TestPGXSource_ReceiveTimeout(t *testing.T) {
+	// keepalives keep the idle connection alive
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage)}
+	src := newFakePGXSource(conn)
+	src.BaseSource.ReadTimeout = 20 * time.Millisecond
+	src.ReceiveTimeout = 150 * time.Millisecond
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		conn.messages <- keepalive(false)
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatalf("unexpected %v", err)
+	}
+	for range changes {
+	}
+
+	// the fully silent connection should be considered dead
+	conn = &fakeReplConn{messages: make(chan pgproto3.BackendMessage)}
+	src = newFakePGXSource(conn)
+	src.BaseSource.ReadTimeout = 20 * time.Millisecond
+	src.ReceiveTimeout = 150 * time.Millisecond
+	changes, err = src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, more := <-changes:
+		if more {
+			t.Fatal("unexpected change")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("receive timeout should fire")
+	}
+	if err = src.Error(); !errors.Is(err, ErrReceiveTimeout) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func TestPGXSource_TransactionalDeliverySpill(t *testing.T) {
+	dir := t.TempDir()
+	orphan := filepath.Join(dir, "pgcapture-"+TestSlot+"-orphan.spill")
+	if err := os.WriteFile(orphan, []byte("orphan"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	src.TransactionalDelivery = true
+	src.MaxInFlightBytes = 1
+	src.SpillDir = dir
+	if err := src.initTxBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("unexpected orphan %v", err)
+	}
+
+	changes, err := src.BaseSource.capture(src.reading, src.cleanup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Stop()
+
+	conn.messages <- xLogData(100, &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{FinalLsn: 100}}})
+	for i := 0; i < 5; i++ {
+		conn.messages <- xLogData(100, &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: strconv.Itoa(i)}}})
+	}
+
+	// nothing should be delivered before the commit, and the changes should be spilled
+	select {
+	case m := <-changes:
+		t.Fatalf("unexpected %v", m.Message.String())
+	case <-time.After(300 * time.Millisecond):
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.spill")); len(files) != 1 {
+		t.Fatalf("unexpected spill files %v", files)
+	}
+
+	conn.messages <- xLogData(100, &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{CommitLsn: 100, EndLsn: 101}}})
+	tx := readTx(t, changes, 5)
+	for i, m := range tx.Changes {
+		if c := m.Message.GetChange(); c.Table != strconv.Itoa(i) || m.Checkpoint.GlobalSeq != uint64(i+2) || m.WALStart != 100 || m.CommitLSN != 100 {
+			t.Fatalf("unexpected %v %v", m.Checkpoint, c)
+		}
+	}
+
+	// the spill file should be deleted after replayed
+	for _, m := range fakeTx(200) {
+		conn.messages <- xLogData(200, m)
+	}
+	readTx(t, changes, 1)
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Fatalf("unexpected files %v", files)
+	}
+}
+
+func TestPGXSource_TransactionalDeliveryTooLarge(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	src.TransactionalDelivery = true
+	src.MaxInFlightBytes = 1
+	if err := src.initTxBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := src.BaseSource.capture(src.reading, src.cleanup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range fakeTx(100) {
+		conn.messages <- xLogData(100, m)
+	}
+	for range changes {
+		t.Fatal("unexpected change")
+	}
+	if err = src.Error(); !errors.Is(err, ErrTransactionTooLarge) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func newFakePGXSource(conn *fakeReplConn) *PGXSource {
+	return &PGXSource{
+		BaseSource: BaseSource{ReadTimeout: 100 * time.Millisecond},
+		ReplSlot:   TestSlot,
+		replConn:   conn,
+		decoder:    &fakeDecoder{},
+		log:        logrus.WithFields(logrus.Fields{"From": "FakePGXSource"}),
+	}
+}
+
+func fakeTx(lsn uint64) []*pb.Message {
+	return []*pb.Message{
+		{Type: &pb.Message_Begin{Begin: &pb.Begin{FinalLsn: lsn}}},
+		{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"}}},
+		{Type: &pb.Message_Commit{Commit: &pb.Commit{CommitLsn: lsn, EndLsn: lsn + 1}}},
+	}
+}
+
+func keepalive(replyRequested bool) *pgproto3.CopyData {
+	data := make([]byte, 18)
+	data[0] = pglogrepl.PrimaryKeepaliveMessageByteID
+	if replyRequested {
+		data[17] = 1
+	}
+	return &pgproto3.CopyData{Data: data}
+}
+
+func xLogData(walStart uint64, m *pb.Message) *pgproto3.CopyData {
+	return xLogDataWithEnd(walStart, walStart, m)
+}
+
+func xLogDataWithEnd(walStart, serverWALEnd uint64, m *pb.Message) *pgproto3.CopyData {
+	bs, _ := proto.Marshal(m)
+	data := make([]byte, 25, 25+len(bs))
+	data[0] = pglogrepl.XLogDataByteID
+	binary.BigEndian.PutUint64(data[1:], walStart)
+	binary.BigEndian.PutUint64(data[9:], serverWALEnd)
+	return &pgproto3.CopyData{Data: append(data, bs...)}
+}
+
+type fakeReplConn struct {
+	messages chan pgproto3.BackendMessage
+	slot     string
+	lsn      pglogrepl.LSN
+	options  pglogrepl.StartReplicationOptions
+	updates  []pglogrepl.StandbyStatusUpdate
+	// updateErrs are returned by the following SendStandbyStatusUpdate calls in order
+	updateErrs []error
+	closed     bool
+	execs      []string
+	params     map[string]string
+	startErr   error
+	// created is the slot created by the CreateReplicationSlot, which exports the snapshot
+	created  pglogrepl.CreateReplicationSlotResult
+	snapshot string
+	// blocked blocks the CreateReplicationSlot until the CancelRequest, like waiting for the consistent point
+	blocked chan struct{}
+}
+
+func (c *fakeReplConn) IdentifySystem(ctx context.Context) (pglogrepl.IdentifySystemResult, error) {
+	return pglogrepl.IdentifySystemResult{}, nil
+}
+
+func (c *fakeReplConn) CreateReplicationSlot(ctx context.Context, slot, plugin string, options pglogrepl.CreateReplicationSlotOptions) (pglogrepl.CreateReplicationSlotResult, error) {
+	if c.created.SlotName != "" {
+		return pglogrepl.CreateReplicationSlotResult{}, &pgconn.PgError{Code: "42710"}
+	}
+	if c.blocked != nil {
+		<-c.blocked
+		return pglogrepl.CreateReplicationSlotResult{}, &pgconn.PgError{Code: "57014", Message: "canceling statement due to user request"}
+	}
+	c.created = pglogrepl.CreateReplicationSlotResult{SlotName: slot, OutputPlugin: plugin}
+	if options.SnapshotAction == "EXPORT_SNAPSHOT" {
+		c.created.SnapshotName = c.snapshot
+	}
+	return c.created, nil
+}
+
+func (c *fakeReplConn) StartReplication(ctx context.Context, slot string, lsn pglogrepl.LSN, options pglogrepl.StartReplicationOptions) error {
+	c.slot, c.lsn, c.options = slot, lsn, options
+	return c.startErr
+}
+
+func (c *fakeReplConn) SendStandbyStatusUpdate(ctx context.Context, status pglogrepl.StandbyStatusUpdate) error {
+	if len(c.updateErrs) != 0 {
+		err := c.updateErrs[0]
+		c.updateErrs = c.updateErrs[1:]
+		return err
+	}
+	c.updates = append(c.updates, status)
+	return nil
+}
+
+func (c *fakeReplConn) Exec(ctx context.Context, sql string) error {
+	if c.slot != "" {
+		return errors.New("exec after the replication started")
+	}
+	c.execs = append(c.execs, sql)
+	return nil
+}
+
+func (c *fakeReplConn) ParameterStatus(key string) string {
+	return c.params[key]
+}
+
+func (c *fakeReplConn) IsClosed() bool {
+	return c.closed
+}
+
+func (c *fakeReplConn) ReceiveMessage(ctx context.Context) (pgproto3.BackendMessage, error) {
+	select {
+	case msg := <-c.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *fakeReplConn) CancelRequest(ctx context.Context) error {
+	close(c.blocked)
+	return nil
+}
+
+func (c *fakeReplConn) Close(ctx context.Context) error {
+	return nil
+}
+
+// fakeDecoder treats the wal data as a marshaled pb.Message
+type fakeDecoder struct {
+	pluginArgs []string
+}
+
+func (d *fakeDecoder) Decode(in []byte) (*pb.Message, error) {
+	m := &pb.Message{}
+	if err := proto.Unmarshal(in, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (d *fakeDecoder) GetPluginArgs() []string {
+	return d.pluginArgs
+}
+
 type TxTest struct {
 	SQL   string
 	Check func(test *TxTest)
@@ -297,3 +1914,314 @@ func expectedDDL(change *pb.Change, sql string) bool {
 		change.New[1].Name == "query" &&
 		bytes.Equal(change.New[1].GetBinary(), []byte(sql))
 }
+
+func TestPGXSource_FinalReport(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	for _, m := range fakeTx(100) {
+		conn.messages <- xLogDataWithEnd(100, 150, m)
+	}
+	for _, m := range fakeTx(200) {
+		conn.messages <- xLogDataWithEnd(200, 250, m)
+	}
+	src := newFakePGXSource(conn)
+	src.LogFinalReport = true
+	src.FaultInjector = fault.NewInjector().FailAtLSN(fault.Decode, 200, nil)
+
+	if r := src.FinalReport(); r.At != (time.Time{}) {
+		t.Fatalf("unexpected report before cleanup %v", r)
+	}
+	if err := src.startReplication(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	src.Commit(cursor.Checkpoint{LSN: 50})
+
+	changes, err := src.BaseSource.capture(src.fetching, src.cleanup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range changes {
+	}
+	if err = src.Stop(); !errors.Is(err, fault.ErrInjected) {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	r := src.FinalReport()
+	if r.Slot != TestSlot || r.CommittedLSN != 50 || r.ServerWALEnd != 250 || r.Changes != 1 || r.Reconnects != 0 || !errors.Is(r.Err, fault.ErrInjected) || r.At.IsZero() {
+		t.Fatalf("unexpected %+v", r)
+	}
+}
+
+func TestPGXSource_DurableAck(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	src.DurableAck = true
+	ctx := context.Background()
+
+	src.Commit(cursor.Checkpoint{LSN: 100})
+	if err := src.reportLSN(ctx); err != nil || len(conn.updates) != 0 {
+		t.Fatalf("should not report before any durable lsn %v %v", err, conn.updates)
+	}
+
+	src.CommitDurable(cursor.Checkpoint{LSN: 100})
+	src.Commit(cursor.Checkpoint{LSN: 300})
+	if err := src.reportLSN(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if u := conn.updates[len(conn.updates)-1]; u.WALWritePosition != 300 || u.WALFlushPosition != 100 || u.WALApplyPosition != 100 {
+		t.Fatalf("the slot should only be flushed to the durable lsn %v", u)
+	}
+
+	// the durable lsn ahead of the delivered one is capped
+	src.CommitDurable(cursor.Checkpoint{LSN: 400})
+	if err := src.reportLSN(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if u := conn.updates[len(conn.updates)-1]; u.WALWritePosition != 300 || u.WALFlushPosition != 300 {
+		t.Fatalf("unexpected %v", u)
+	}
+
+	// without the DurableAck, the delivered lsn is flushed
+	src.DurableAck = false
+	if err := src.reportLSN(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if u := conn.updates[len(conn.updates)-1]; u.WALWritePosition != 300 || u.WALFlushPosition != 0 {
+		t.Fatalf("unexpected %v", u)
+	}
+}
+
+func TestPGXSource_TwoPhaseCommit(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	ctx := context.Background()
+
+	src.PrepareCommit(cursor.Checkpoint{LSN: 100})
+	if err := src.reportLSN(ctx); err != nil || len(conn.updates) != 0 {
+		t.Fatalf("should not advance before confirmed %v %v", err, conn.updates)
+	}
+	src.ConfirmCommit()
+	if err := src.reportLSN(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if u := conn.updates[len(conn.updates)-1]; u.WALWritePosition != 100 {
+		t.Fatalf("unexpected %v", u)
+	}
+
+	// the consumer persists the prepared checkpoint with its external transaction, and crashes before confirming it
+	prepared := cursor.Checkpoint{LSN: 200, Seq: 2}
+	src.PrepareCommit(prepared)
+	if err := src.reportLSN(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range conn.updates {
+		if u.WALWritePosition != 100 {
+			t.Fatalf("the slot should not advance to the prepared checkpoint %v", u)
+		}
+	}
+
+	// the server re-sends the transactions after the confirmed LSN of the slot, and the externally written one is dropped
+	next := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	for _, lsn := range []uint64{200, 300} {
+		for _, m := range fakeTx(lsn) {
+			next.messages <- xLogData(lsn, m)
+		}
+	}
+	src = newFakePGXSource(next)
+	src.SuppressDuplicates = true
+	src.resume(prepared)
+	if err := src.startReplication(ctx); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx := readTx(t, changes, 1); tx.Begin.Checkpoint.LSN != 300 {
+		t.Fatalf("unexpected %v", tx.Begin)
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if next.lsn != 200 {
+		t.Fatalf("unexpected %v", next.lsn)
+	}
+}
+
+func TestPGXSource_DurableAckCrash(t *testing.T) {
+	test.ShouldSkipTestByPGVersion(t, 14)
+	ctx := context.Background()
+	conn, err := newPGConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	conn.Exec(ctx, fmt.Sprintf("select pg_drop_replication_slot('%s')", TestSlot))
+	conn.Exec(ctx, fmt.Sprintf("DROP PUBLICATION %s", TestSlot))
+
+	capture := func() (*PGXSource, chan Change) {
+		src := newPGXSource(decode.PGOutputPlugin)
+		src.CreateSlot = true
+		src.CreatePublication = true
+		src.DurableAck = true
+		// start from the confirmed position of the slot
+		src.StartLSN = "0/0"
+		changes, err := src.Capture(cursor.Checkpoint{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return src, changes
+	}
+
+	src, changes := capture()
+	if _, err = conn.Exec(ctx, "create table t3 (id int primary key); insert into t3 values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	var delivered cursor.Checkpoint
+	for change := range changes {
+		if c := change.Message.GetChange(); c != nil && c.Table == "t3" {
+			delivered = change.Checkpoint
+			break
+		}
+	}
+	// the change is delivered, but the process crashes before the sink persists it
+	src.Commit(delivered)
+	src.Stop()
+
+	src, changes = capture()
+	defer src.Stop()
+	for change := range changes {
+		if c := change.Message.GetChange(); c != nil && c.Table == "t3" {
+			if change.Checkpoint.LSN != delivered.LSN {
+				t.Fatalf("unexpected %v", change.Checkpoint)
+			}
+			return
+		}
+	}
+	t.Fatal("the change not durably persisted should be redelivered")
+}
+
+func TestPGXSource_SlotInvalidated(t *testing.T) {
+	invalidated := &pgconn.PgError{
+		Severity: "ERROR",
+		Code:     "55000",
+		Message:  `can no longer get changes from replication slot "test_slot"`,
+		Detail:   "This slot has been invalidated because it exceeded the maximum reserved size.",
+	}
+
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10), startErr: invalidated}
+	src := newFakePGXSource(conn)
+	err := src.startReplication(context.Background())
+	var pge *pgconn.PgError
+	if !errors.Is(err, ErrSlotInvalidated) || !errors.As(err, &pge) || pge.Code != "55000" {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	// other errors are not taken as the invalidated slot
+	for _, startErr := range []error{
+		context.DeadlineExceeded,
+		&pgconn.PgError{Severity: "ERROR", Code: "55000", Message: "replication slot is active"},
+		&pgconn.PgError{Severity: "ERROR", Code: "42704", Message: `replication slot "test_slot" does not exist`},
+	} {
+		conn.startErr = startErr
+		if err = src.startReplication(context.Background()); errors.Is(err, ErrSlotInvalidated) || !errors.Is(err, startErr) {
+			t.Fatalf("unexpected %v", err)
+		}
+	}
+
+	// the slot invalidated in the middle of streaming
+	conn.startErr = nil
+	conn.messages <- &pgproto3.ErrorResponse{Severity: invalidated.Severity, Code: invalidated.Code, Message: invalidated.Message, Detail: invalidated.Detail}
+	changes, err := src.BaseSource.capture(src.fetching, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range changes {
+	}
+	if err = src.Stop(); !errors.Is(err, ErrSlotInvalidated) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func TestPGXSource_MeasureLatency(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	// the transaction was committed 2 seconds ago
+	commitTime := uint64(time.Now().Add(-2*time.Second).UnixMicro() - 946684800*1000000)
+	for _, m := range fakeTx(100) {
+		if b := m.GetBegin(); b != nil {
+			b.CommitTime = commitTime
+		} else if c := m.GetCommit(); c != nil {
+			c.CommitTime = commitTime
+		}
+		conn.messages <- xLogData(100, m)
+	}
+	src := newFakePGXSource(conn)
+	src.MeasureLatency = true
+	metrics := &memoryMetricsSink{}
+	src.Metrics = metrics
+
+	changes, err := src.BaseSource.capture(src.reading, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := readTx(t, changes, 1)
+	src.Stop()
+
+	for _, c := range []Change{tx.Begin, tx.Changes[0], tx.Commit} {
+		if c.Latency < 2*time.Second || c.Latency > time.Minute {
+			t.Fatalf("unexpected latency %v of %v", c.Latency, c.Message)
+		}
+	}
+	total, n := metrics.sum("histogram", MetricDeliveryLatency, map[string]string{"slot": TestSlot, "type": "change"})
+	if n != 1 || total < 2 || total > 60 {
+		t.Fatalf("unexpected latency histogram %v %v", total, n)
+	}
+}
+
+func TestPGXSource_MeasureLatencyBuffered(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	commitTime := uint64(clock.now.UnixMicro() - 946684800*1000000)
+	tx := fakeTx(100)
+	for _, m := range tx {
+		if b := m.GetBegin(); b != nil {
+			b.CommitTime = commitTime
+		} else if c := m.GetCommit(); c != nil {
+			c.CommitTime = commitTime
+		}
+	}
+	src := newFakePGXSource(conn)
+	src.Clock = clock
+	src.MeasureLatency = true
+	src.TransactionalDelivery = true
+	src.MaxInFlightBytes = 1
+	src.SpillDir = t.TempDir()
+	if err := src.initTxBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	metrics := &memoryMetricsSink{}
+	src.Metrics = metrics
+
+	ctx := context.Background()
+	for _, m := range tx[:2] {
+		conn.messages <- xLogData(100, m)
+		if c, err := src.reading(ctx); err != nil || c.Message != nil {
+			t.Fatalf("unexpected %v %v", c.Message, err)
+		}
+	}
+	// the spilled changes are delivered 3 seconds after received
+	clock.Advance(3 * time.Second)
+	conn.messages <- xLogData(100, tx[2])
+	for range tx {
+		c, err := src.reading(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Latency != 3*time.Second {
+			t.Fatalf("unexpected latency %v of %v", c.Latency, c.Message)
+		}
+	}
+	total, n := metrics.sum("histogram", MetricDeliveryLatency, map[string]string{"slot": TestSlot, "type": "change"})
+	if n != 1 || total != 3 {
+		t.Fatalf("unexpected latency histogram %v %v", total, n)
+	}
+}
@@ -0,0 +1,203 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/replicase/pgcapture/pkg/decode"
+	"github.com/replicase/pgcapture/pkg/sql"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrRowFilterColumn means the row filter uses the columns not in the replica identity, which makes the server
+// reject the UPDATE and DELETE of the table while it is published
+var ErrRowFilterColumn = errors.New("row filter uses columns not in the replica identity")
+
+// ErrPublicationAllTables means the existing publication is created FOR ALL TABLES, which can not be replaced with the
+// PublicationTables, and it should be dropped or recreated for the tables
+var ErrPublicationAllTables = errors.New("publication is created for all tables and can not be limited to tables")
+
+// ErrPublicationTables means the PublicationRowFilters are not keyed by the PublicationTables, which should list all
+// the captured tables explicitly, since the tables not listed are not published
+var ErrPublicationTables = errors.New("row filters should be keyed by the publication tables")
+
+// ErrRowFilterUnsupported means the server is older than PG15, which has no row filters of the publications
+var ErrRowFilterUnsupported = errors.New("row filters of publications require PG15+")
+
+// createPublication creates the publication for all tables, or only for the PublicationTables with the
+// PublicationRowFilters, and the tables of an existing publication are replaced, unless it is for all tables
+func (p *PGXSource) createPublication(ctx context.Context) error {
+	if len(p.PublicationTables) == 0 && len(p.PublicationRowFilters) == 0 {
+		_, err := p.setupConn.Exec(ctx, fmt.Sprintf(sql.CreatePublication, p.ReplSlot))
+		return ignoreDuplicateObject(err)
+	}
+	if err := checkPublicationTables(p.PublicationTables, p.PublicationRowFilters); err != nil {
+		return err
+	}
+	if len(p.PublicationRowFilters) != 0 {
+		version, err := p.schema.GetVersion()
+		if err != nil {
+			return err
+		}
+		if version < 150000 {
+			return fmt.Errorf("%w: server version %d", ErrRowFilterUnsupported, version)
+		}
+	}
+	for table, filter := range p.PublicationRowFilters {
+		if err := p.checkRowFilter(ctx, table, filter); err != nil {
+			return err
+		}
+	}
+	tables := publicationTables(p.PublicationTables, p.PublicationRowFilters)
+	_, err := p.setupConn.Exec(ctx, fmt.Sprintf(sql.CreatePublicationForTables, p.ReplSlot)+tables)
+	if err == nil {
+		p.log.WithFields(logrus.Fields{"Publication": p.ReplSlot, "Tables": tables}).Info("created the publication only for the tables, the other tables are not captured")
+		return nil
+	}
+	if ignoreDuplicateObject(err) != nil {
+		return err
+	}
+	var allTables bool
+	if err = p.setupConn.QueryRow(ctx, sql.QueryPublicationAllTables, p.ReplSlot).Scan(&allTables); err != nil {
+		return err
+	}
+	if allTables {
+		return fmt.Errorf("%w: %s", ErrPublicationAllTables, p.ReplSlot)
+	}
+	if _, err = p.setupConn.Exec(ctx, fmt.Sprintf(sql.AlterPublicationTables, p.ReplSlot)+tables); err != nil {
+		return err
+	}
+	p.log.WithFields(logrus.Fields{"Publication": p.ReplSlot, "Tables": tables}).Warn("replaced the tables of the existing publication, the other tables are no longer captured")
+	return nil
+}
+
+// checkPublicationTables fails the row filters of the tables not listed
+func checkPublicationTables(tables []string, filters map[string]string) error {
+	listed := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		listed[table] = true
+	}
+	var missing []string
+	for table := range filters {
+		if !listed[table] {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%w: %s", ErrPublicationTables, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func ignoreDuplicateObject(err error) error {
+	var pge *pgconn.PgError
+	if errors.As(err, &pge) && pge.Code == "42710" {
+		return nil
+	}
+	return err
+}
+
+// publicationTables lists the tables with their row filters in the order of names, followed by the tables of the
+// extension without filters, so that the DDL changes are still published
+func publicationTables(tables []string, filters map[string]string) string {
+	names := append([]string(nil), tables...)
+	sort.Strings(names)
+	list := make([]string, 0, len(names)+2)
+	for i, name := range names {
+		if i != 0 && name == names[i-1] {
+			continue
+		}
+		table := pgx.Identifier(strings.SplitN(name, ".", 2)).Sanitize()
+		if filter := filters[name]; filter != "" {
+			table += " WHERE (" + filter + ")"
+		}
+		list = append(list, table)
+	}
+	for _, name := range []string{decode.ExtensionDDLLogs, decode.ExtensionSources} {
+		list = append(list, pgx.Identifier{decode.ExtensionSchema, name}.Sanitize())
+	}
+	return strings.Join(list, ", ")
+}
+
+// checkRowFilter fails the filter referencing the columns not in the replica identity of the table
+func (p *PGXSource) checkRowFilter(ctx context.Context, table, filter string) error {
+	if filter == "" {
+		return nil
+	}
+	var identity, columns []string
+	if err := p.setupConn.QueryRow(ctx, sql.QueryReplicaIdentityColumns, pgx.Identifier(strings.SplitN(table, ".", 2)).Sanitize()).Scan(&identity, &columns); err != nil {
+		return err
+	}
+	if outside := rowFilterColumns(filter, columns, identity); len(outside) != 0 {
+		return fmt.Errorf("%w: %s uses %s", ErrRowFilterColumn, table, strings.Join(outside, ", "))
+	}
+	return nil
+}
+
+// rowFilterColumns returns the columns referenced by the filter but not in the identity. The identifiers of the
+// filter are scanned with the string literals skipped, and the unquoted ones are folded to lower case like the server does.
+func rowFilterColumns(filter string, columns, identity []string) (outside []string) {
+	referenced := make(map[string]bool)
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; {
+		case c == '\'':
+			for i++; i < len(filter); i++ {
+				if filter[i] == '\'' {
+					if i+1 < len(filter) && filter[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i++
+		case c == '"':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(filter); j++ {
+				if filter[j] == '"' {
+					if j+1 < len(filter) && filter[j+1] == '"' {
+						sb.WriteByte('"')
+						j++
+						continue
+					}
+					break
+				}
+				sb.WriteByte(filter[j])
+			}
+			referenced[sb.String()] = true
+			i = j + 1
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			j := i + 1
+			for j < len(filter) && (filter[j] == '_' || filter[j] == '$' || 'a' <= filter[j] && filter[j] <= 'z' || 'A' <= filter[j] && filter[j] <= 'Z' || '0' <= filter[j] && filter[j] <= '9') {
+				j++
+			}
+			referenced[strings.ToLower(filter[i:j])] = true
+			i = j
+		default:
+			i++
+		}
+	}
+	for _, col := range columns {
+		if !referenced[col] {
+			continue
+		}
+		found := false
+		for _, id := range identity {
+			if id == col {
+				found = true
+				break
+			}
+		}
+		if !found {
+			outside = append(outside, col)
+		}
+	}
+	return outside
+}
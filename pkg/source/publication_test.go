@@ -0,0 +1,43 @@
+package source
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPublicationTables(t *testing.T) {
+	tables := publicationTables([]string{"public.t3", "public.t1", "public.t2", "public.t3"}, map[string]string{"public.t2": "", "public.t1": "id > 10 AND tenant = 'a'"})
+	if expect := `"public"."t1" WHERE (id > 10 AND tenant = 'a'), "public"."t2", "public"."t3", "pgcapture"."ddl_logs", "pgcapture"."sources"`; tables != expect {
+		t.Fatalf("unexpected %v", tables)
+	}
+
+	if err := checkPublicationTables([]string{"public.t1"}, map[string]string{"public.t1": "id > 10"}); err != nil {
+		t.Fatal(err)
+	}
+	// the filtered tables are never published implicitly
+	err := checkPublicationTables([]string{"public.t1"}, map[string]string{"public.t1": "id > 10", "public.t3": "id > 1", "public.t2": ""})
+	if !errors.Is(err, ErrPublicationTables) || err.Error() != ErrPublicationTables.Error()+": public.t2, public.t3" {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func TestRowFilterColumns(t *testing.T) {
+	columns := []string{"id", "tenant", "v", "Mixed"}
+	identity := []string{"id", "tenant"}
+	for _, tc := range []struct {
+		filter  string
+		outside []string
+	}{
+		{filter: "id > 10 AND tenant = 'a'"},
+		// the identifiers in the string literals are not columns
+		{filter: "tenant = 'v' OR tenant = 'it''s v'"},
+		{filter: "ID > 10 AND V < 3", outside: []string{"v"}},
+		{filter: `"Mixed" IS NOT NULL AND mixed IS NULL`, outside: []string{"Mixed"}},
+		{filter: "lower(tenant) = 'a' AND v IS NOT NULL", outside: []string{"v"}},
+	} {
+		if outside := rowFilterColumns(tc.filter, columns, identity); !reflect.DeepEqual(outside, tc.outside) {
+			t.Fatalf("unexpected %v of %v", outside, tc.filter)
+		}
+	}
+}
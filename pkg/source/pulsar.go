@@ -95,6 +95,10 @@ func (p *PulsarReaderSource) Capture(cp cursor.Checkpoint) (changes chan Change,
 		if err != nil {
 			return
 		}
+		if len(msg.Payload()) == 0 {
+			// the tombstone of the PulsarSink.DeleteAsTombstone carries no message
+			return
+		}
 
 		m := &pb.Message{}
 		if err = proto.Unmarshal(msg.Payload(), m); err != nil {
@@ -208,6 +212,11 @@ func (p *PulsarConsumerSource) Capture(cp cursor.Checkpoint) (changes chan Chang
 		if err != nil {
 			return
 		}
+		if len(msg.Payload()) == 0 {
+			// the tombstone of the PulsarSink.DeleteAsTombstone carries no message
+			p.consumer.Ack(msg)
+			return
+		}
 
 		m := &pb.Message{}
 		if err = proto.Unmarshal(msg.Payload(), m); err != nil {
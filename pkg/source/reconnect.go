@@ -0,0 +1,185 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultReconnectWindow     = time.Minute
+	DefaultReconnectBackoff    = time.Second
+	DefaultMaxReconnectBackoff = 30 * time.Second
+)
+
+// ErrReconnectBudgetExhausted means the replication connection keeps failing more than the MaxReconnects within
+// the ReconnectWindow, and the source gives up so that the orchestration can back off at a higher level
+var ErrReconnectBudgetExhausted = errors.New("replication reconnects exceed the failure budget")
+
+// reconnectBudget allows at most max reconnects within the rolling window, and the backoff of each attempt
+// is doubled since the last successful read
+type reconnectBudget struct {
+	max        int
+	window     time.Duration
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	attempts []time.Time
+	streak   int
+}
+
+func newReconnectBudget(max int, window, backoff, maxBackoff time.Duration) *reconnectBudget {
+	if window <= 0 {
+		window = DefaultReconnectWindow
+	}
+	if backoff <= 0 {
+		backoff = DefaultReconnectBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxReconnectBackoff
+	}
+	return &reconnectBudget{max: max, window: window, backoff: backoff, maxBackoff: maxBackoff}
+}
+
+// take reserves an attempt at now, and reports false if the attempts within the window already reach the max
+func (b *reconnectBudget) take(now time.Time) bool {
+	i := 0
+	for i < len(b.attempts) && now.Sub(b.attempts[i]) >= b.window {
+		i++
+	}
+	b.attempts = b.attempts[i:]
+	if len(b.attempts) >= b.max {
+		return false
+	}
+	b.attempts = append(b.attempts, now)
+	return true
+}
+
+// next returns the backoff of the next consecutive attempt
+func (b *reconnectBudget) next() time.Duration {
+	wait := b.backoff
+	for i := 0; i < b.streak && wait < b.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > b.maxBackoff {
+		wait = b.maxBackoff
+	}
+	b.streak++
+	return wait
+}
+
+func (b *reconnectBudget) succeed() {
+	b.streak = 0
+}
+
+// receiving reads like the reading, and re-establishes the replication from the committed LSN when it fails
+// if the MaxReconnects is set
+func (p *PGXSource) receiving(ctx context.Context) (change Change, err error) {
+	if change, err = p.reading(ctx); err == nil {
+		if p.budget != nil {
+			p.budget.succeed()
+		}
+		if change.Message != nil {
+			p.delivered = change.Checkpoint
+		}
+		return change, nil
+	}
+	if p.MaxReconnects <= 0 || isTimeout(err) || !reconnectable(err) {
+		return change, err
+	}
+	if p.budget == nil {
+		p.budget = newReconnectBudget(p.MaxReconnects, p.ReconnectWindow, p.ReconnectBackoff, p.MaxReconnectBackoff)
+	}
+	return change, p.reconnect(err)
+}
+
+// reconnectable excludes the errors which can not be recovered by reconnecting
+func reconnectable(err error) bool {
+	return !errors.Is(err, ErrSlotInvalidated) && !errors.Is(err, ErrTransactionTooLarge)
+}
+
+func (p *PGXSource) reconnect(cause error) error {
+	for {
+		if !p.budget.take(p.clock().Now()) {
+			return fmt.Errorf("%w: %d reconnects within %v: %w", ErrReconnectBudgetExhausted, p.budget.max, p.budget.window, cause)
+		}
+		wait := p.budget.next()
+		p.log.WithError(cause).WithFields(logrus.Fields{"ReplSlot": p.ReplSlot, "Backoff": wait}).Warn("replication failed, will reconnect")
+		if !p.sleep(wait) {
+			return cause
+		}
+		p.metrics().Counter(MetricReconnects, 1, map[string]string{"slot": p.ReplSlot})
+		if cause = p.restartReplication(context.Background()); cause == nil || !reconnectable(cause) {
+			return cause
+		}
+	}
+}
+
+// sleep waits for the duration, and reports false if the source is stopped in the meantime
+func (p *PGXSource) sleep(d time.Duration) bool {
+	clock := p.clock()
+	deadline := clock.Now().Add(d)
+	for atomic.LoadInt64(&p.state) == 2 {
+		left := deadline.Sub(clock.Now())
+		if left <= 0 {
+			return true
+		}
+		if left > 100*time.Millisecond {
+			left = 100 * time.Millisecond
+		}
+		sleepClock(clock, left)
+	}
+	return false
+}
+
+// restartReplication starts the replication on a new connection from the committed LSN, and the messages
+// re-sent by the server up to the last delivered checkpoint are suppressed
+func (p *PGXSource) restartReplication(ctx context.Context) (err error) {
+	if p.replConn != nil {
+		p.replConn.Close(ctx)
+	}
+	if p.replConn, err = p.dialRepl(ctx); err != nil {
+		return err
+	}
+	if p.txBuffer != nil {
+		if err = p.txBuffer.reset(); err != nil {
+			return err
+		}
+	}
+	if p.pipeline != nil {
+		p.pipeline.close()
+		p.initDecodePipeline()
+	}
+	if p.delivered.LSN != 0 {
+		p.resumeFrom = p.delivered
+	}
+	if committed := p.committedLSN(); committed != 0 {
+		p.currentLsn = uint64(committed)
+	}
+	p.currentSeq = 0
+	p.pendingCommit = nil
+	p.nextReportTime = time.Time{}
+	return p.startReplication(ctx)
+}
+
+func (p *PGXSource) dialReplication(ctx context.Context) (replicationConn, error) {
+	replConfig, err := pgconn.ParseConfig(p.ReplConnStr)
+	if err != nil {
+		return nil, err
+	}
+	p.setApplicationName(replConfig.RuntimeParams)
+	replConn, err := pgconn.ConnectConfig(ctx, replConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn := &pgReplicationConn{PgConn: replConn}
+	for _, name := range trackedParameters {
+		p.setParameterStatus(name, conn.ParameterStatus(name))
+	}
+	return conn, nil
+}
@@ -0,0 +1,115 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/replicase/pgcapture/internal/fault"
+	"github.com/replicase/pgcapture/pkg/cursor"
+)
+
+func TestPGXSource_Reconnect(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	for _, lsn := range []uint64{100, 200} {
+		for _, m := range fakeTx(lsn) {
+			conn.messages <- xLogData(lsn, m)
+		}
+	}
+	// the new connection re-sends the transactions after the committed LSN
+	next := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	for _, lsn := range []uint64{200, 300} {
+		for _, m := range fakeTx(lsn) {
+			next.messages <- xLogData(lsn, m)
+		}
+	}
+	src := newFakePGXSource(conn)
+	src.MaxReconnects = 3
+	src.ReconnectBackoff = time.Millisecond
+	src.Commit(cursor.Checkpoint{LSN: 100})
+	// fails at receiving the change of the second transaction
+	src.FaultInjector = fault.NewInjector().FailAtCount(fault.Receive, 5, nil)
+	dials := 0
+	src.dialRepl = func(ctx context.Context) (replicationConn, error) {
+		dials++
+		return next, nil
+	}
+	if err := src.startReplication(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := src.BaseSource.capture(src.receiving, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gseq uint64
+	for _, lsn := range []uint64{100, 200, 300} {
+		tx := readTx(t, changes, 1)
+		if tx.Begin.Checkpoint.LSN != lsn {
+			t.Fatalf("unexpected %v", tx.Begin)
+		}
+		for _, c := range []Change{tx.Begin, tx.Changes[0], tx.Commit} {
+			if gseq++; c.Checkpoint.GlobalSeq != gseq {
+				t.Fatalf("unexpected %v", c)
+			}
+		}
+	}
+	if err = src.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 1 || next.lsn != 100 || src.reconnects != 1 {
+		t.Fatalf("unexpected %v %v %v", dials, next.lsn, src.reconnects)
+	}
+}
+
+func TestPGXSource_ReconnectBudgetExhausted(t *testing.T) {
+	conn := &fakeReplConn{messages: make(chan pgproto3.BackendMessage, 10)}
+	src := newFakePGXSource(conn)
+	src.MaxReconnects = 3
+	src.ReconnectBackoff = time.Millisecond
+	src.FaultInjector = fault.NewInjector().FailAtCount(fault.Receive, 1, nil)
+	// the upstream keeps flapping, and every new connection fails to start the replication
+	dials := 0
+	src.dialRepl = func(ctx context.Context) (replicationConn, error) {
+		dials++
+		return &fakeReplConn{messages: make(chan pgproto3.BackendMessage), startErr: fault.ErrInjected}, nil
+	}
+
+	changes, err := src.BaseSource.capture(src.receiving, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range changes {
+		t.Fatal("unexpected change")
+	}
+	if err = src.Stop(); !errors.Is(err, ErrReconnectBudgetExhausted) || !errors.Is(err, fault.ErrInjected) {
+		t.Fatalf("unexpected %v", err)
+	}
+	if dials != 3 {
+		t.Fatalf("unexpected %v", dials)
+	}
+}
+
+func TestReconnectBudget(t *testing.T) {
+	b := newReconnectBudget(2, time.Minute, time.Second, 5*time.Second)
+	now := time.Now()
+	if !b.take(now) || !b.take(now.Add(time.Second)) || b.take(now.Add(2*time.Second)) {
+		t.Fatal("only 2 reconnects are allowed within the window")
+	}
+	// the first attempt falls out of the rolling window
+	if !b.take(now.Add(time.Minute)) || b.take(now.Add(time.Minute)) {
+		t.Fatal("the expired attempt should be released")
+	}
+
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if wait := b.next(); wait != expected {
+			t.Fatalf("unexpected backoff %v of attempt %v", wait, i)
+		}
+	}
+	b.succeed()
+	if wait := b.next(); wait != time.Second {
+		t.Fatalf("unexpected %v", wait)
+	}
+}
@@ -0,0 +1,225 @@
+package source
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/replicase/pgcapture/pkg/cursor"
+	"github.com/replicase/pgcapture/pkg/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrTransactionTooLarge = errors.New("transaction exceeds the max in-flight bytes")
+
+// txBuffer holds the changes of the current transaction until its commit,
+// and spills them into a temp file when they exceed the limit in memory.
+type txBuffer struct {
+	dir     string
+	pattern string
+	limit   int
+
+	open bool
+	size int
+	mem  []Change
+	// endLsn is the end of the commit record, which is set on all the replaying changes
+	endLsn uint64
+
+	file *os.File
+	w    *bufio.Writer
+	r    *bufio.Reader
+}
+
+func newTxBuffer(dir, slot string, limit int) (*txBuffer, error) {
+	b := &txBuffer{dir: dir, pattern: "pgcapture-" + slot + "-*.spill", limit: limit}
+	if dir != "" {
+		// remove the orphan files left by a crashed process
+		orphans, err := filepath.Glob(filepath.Join(dir, b.pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range orphans {
+			if err = os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func (b *txBuffer) begin(c Change) error {
+	if err := b.reset(); err != nil {
+		return err
+	}
+	b.open = true
+	return b.append(c)
+}
+
+func (b *txBuffer) append(c Change) error {
+	if b.file != nil {
+		return b.write(c)
+	}
+	b.size += proto.Size(c.Message)
+	b.mem = append(b.mem, c)
+	if b.size <= b.limit {
+		return nil
+	}
+	if b.dir == "" {
+		return ErrTransactionTooLarge
+	}
+	return b.spill()
+}
+
+// commit appends the commit change and starts replaying the whole transaction
+func (b *txBuffer) commit(c Change) error {
+	if err := b.append(c); err != nil {
+		return err
+	}
+	b.open = false
+	b.endLsn = c.EndLSN
+	if b.file != nil {
+		if err := b.w.Flush(); err != nil {
+			return err
+		}
+		if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		b.r = bufio.NewReader(b.file)
+	}
+	return nil
+}
+
+// next returns the next replaying change if there is any
+func (b *txBuffer) next() (c Change, ok bool, err error) {
+	if b.open {
+		return
+	}
+	if b.r != nil {
+		if c, err = b.read(); err == io.EOF {
+			return c, false, b.reset()
+		}
+		c.EndLSN = b.endLsn
+		return c, err == nil, err
+	}
+	if len(b.mem) != 0 {
+		c = b.mem[0]
+		c.EndLSN = b.endLsn
+		b.mem[0] = Change{}
+		b.mem = b.mem[1:]
+		return c, true, nil
+	}
+	return
+}
+
+func (b *txBuffer) spill() (err error) {
+	if b.file, err = os.CreateTemp(b.dir, b.pattern); err != nil {
+		return err
+	}
+	b.w = bufio.NewWriter(b.file)
+	for _, c := range b.mem {
+		if err = b.write(c); err != nil {
+			return err
+		}
+	}
+	b.mem = nil
+	b.size = 0
+	return nil
+}
+
+// spillMeta is the metadata of the Change other than the positions, which is written as the json after the message,
+// and is empty if none of them is set
+type spillMeta struct {
+	Latency           time.Duration     `json:",omitempty"`
+	ApplicationName   string            `json:",omitempty"`
+	SchemaFingerprint string            `json:",omitempty"`
+	SystemColumns     map[string]uint32 `json:",omitempty"`
+	CommitTime        uint64            `json:",omitempty"`
+}
+
+func (b *txBuffer) write(c Change) error {
+	bs, err := proto.Marshal(c.Message)
+	if err != nil {
+		return err
+	}
+	var meta []byte
+	if c.Latency != 0 || c.ApplicationName != "" || c.SchemaFingerprint != "" || len(c.SystemColumns) != 0 || c.commitTime != 0 {
+		m := spillMeta{Latency: c.Latency, ApplicationName: c.ApplicationName, SchemaFingerprint: c.SchemaFingerprint, SystemColumns: c.SystemColumns, CommitTime: c.commitTime}
+		if meta, err = json.Marshal(m); err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, 0, binary.MaxVarintLen64*9)
+	for _, v := range []uint64{c.Checkpoint.LSN, uint64(c.Checkpoint.Seq), c.Checkpoint.GlobalSeq, c.WALStart, c.ServerWALEnd, c.CommitLSN, c.EndLSN, uint64(len(bs)), uint64(len(meta))} {
+		buf = binary.AppendUvarint(buf, v)
+	}
+	if _, err = b.w.Write(buf); err != nil {
+		return err
+	}
+	if _, err = b.w.Write(bs); err != nil {
+		return err
+	}
+	_, err = b.w.Write(meta)
+	return err
+}
+
+func (b *txBuffer) read() (c Change, err error) {
+	var v [9]uint64
+	for i := range v {
+		if v[i], err = binary.ReadUvarint(b.r); err != nil {
+			if i != 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+	}
+	bs := make([]byte, v[7]+v[8])
+	if _, err = io.ReadFull(b.r, bs); err != nil {
+		return
+	}
+	m := &pb.Message{}
+	if err = proto.Unmarshal(bs[:v[7]], m); err != nil {
+		return
+	}
+	var meta spillMeta
+	if v[8] != 0 {
+		if err = json.Unmarshal(bs[v[7]:], &meta); err != nil {
+			return
+		}
+	}
+	return Change{
+		Checkpoint:        cursor.Checkpoint{LSN: v[0], Seq: uint32(v[1]), GlobalSeq: v[2]},
+		Message:           m,
+		WALStart:          v[3],
+		ServerWALEnd:      v[4],
+		CommitLSN:         v[5],
+		EndLSN:            v[6],
+		Latency:           meta.Latency,
+		ApplicationName:   meta.ApplicationName,
+		SchemaFingerprint: meta.SchemaFingerprint,
+		SystemColumns:     meta.SystemColumns,
+		commitTime:        meta.CommitTime,
+	}, nil
+}
+
+// reset drops the buffered changes and deletes the spilled file
+func (b *txBuffer) reset() (err error) {
+	b.open = false
+	b.size = 0
+	b.mem = nil
+	b.endLsn = 0
+	b.w, b.r = nil, nil
+	if b.file != nil {
+		name := b.file.Name()
+		b.file.Close()
+		b.file = nil
+		if err = os.Remove(name); os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package source
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/replicase/pgcapture/pkg/cursor"
+	"github.com/replicase/pgcapture/pkg/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTxBuffer_SpillRoundTrip(t *testing.T) {
+	full := Change{
+		Checkpoint:        cursor.Checkpoint{LSN: 100, Seq: 2, GlobalSeq: 3},
+		Message:           &pb.Message{Type: &pb.Message_Change{Change: &pb.Change{Op: pb.Change_INSERT, Schema: "public", Table: "t1"}}},
+		WALStart:          90,
+		ServerWALEnd:      200,
+		CommitLSN:         100,
+		EndLSN:            101,
+		Latency:           time.Second,
+		ApplicationName:   "app",
+		SchemaFingerprint: "0123456789abcdef",
+		SystemColumns:     map[string]uint32{"xmin": 7},
+		commitTime:        1,
+	}
+	// every field should be set, so that the fields added later are covered
+	v := reflect.ValueOf(full)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Fatalf("%s should be set", v.Type().Field(i).Name)
+		}
+	}
+	begin := Change{Checkpoint: cursor.Checkpoint{LSN: 100}, Message: &pb.Message{Type: &pb.Message_Begin{Begin: &pb.Begin{}}}}
+	commit := Change{Checkpoint: cursor.Checkpoint{LSN: 100}, Message: &pb.Message{Type: &pb.Message_Commit{Commit: &pb.Commit{}}}, EndLSN: 101}
+
+	b, err := newTxBuffer(t.TempDir(), TestSlot, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.reset()
+	for _, err := range []error{b.begin(begin), b.append(full), b.commit(commit)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.file == nil {
+		t.Fatal("the transaction should be spilled")
+	}
+	for _, expect := range []Change{{Checkpoint: begin.Checkpoint, Message: begin.Message, EndLSN: 101}, full, commit} {
+		c, ok, err := b.next()
+		if err != nil || !ok {
+			t.Fatalf("unexpected %v %v", ok, err)
+		}
+		if !proto.Equal(c.Message, expect.Message) {
+			t.Fatalf("unexpected %v", c.Message.String())
+		}
+		c.Message, expect.Message = nil, nil
+		if !reflect.DeepEqual(c, expect) {
+			t.Fatalf("unexpected %+v, expected %+v", c, expect)
+		}
+	}
+	if _, ok, err := b.next(); ok || err != nil {
+		t.Fatalf("unexpected %v %v", ok, err)
+	}
+}
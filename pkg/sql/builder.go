@@ -26,6 +26,12 @@ func DeleteQuery(namespace, table string, fields []*pb.Field) string {
 }
 
 func UpdateQuery(namespace, table string, sets, keys []*pb.Field) string {
+	return PatchUpdateQuery(namespace, table, sets, nil, keys)
+}
+
+// PatchUpdateQuery is the UpdateQuery whose sets with non-zero patches are set by the nested jsonb_set of the column,
+// and each of the patches takes two parameters, the path and the value, instead of the whole value
+func PatchUpdateQuery(namespace, table string, sets []*pb.Field, patches []int, keys []*pb.Field) string {
 	var query strings.Builder
 	query.WriteString("update \"")
 	query.WriteString(namespace)
@@ -33,11 +39,21 @@ func UpdateQuery(namespace, table string, sets, keys []*pb.Field) string {
 	query.WriteString(table)
 	query.WriteString("\" set \"")
 
-	var j int
-	for ; j < len(sets); j++ {
-		field := sets[j]
+	n := 1
+	for j, field := range sets {
 		query.WriteString(field.Name)
-		query.WriteString("\"=$" + strconv.Itoa(j+1))
+		query.WriteString("\"=")
+		if j < len(patches) && patches[j] > 0 {
+			query.WriteString(strings.Repeat("jsonb_set(", patches[j]))
+			query.WriteString("\"" + field.Name + "\"")
+			for k := 0; k < patches[j]; k++ {
+				query.WriteString(",$" + strconv.Itoa(n) + "::text[],$" + strconv.Itoa(n+1) + "::jsonb)")
+				n += 2
+			}
+		} else {
+			query.WriteString("$" + strconv.Itoa(n))
+			n++
+		}
 		if j != len(sets)-1 {
 			query.WriteString(",\"")
 		}
@@ -45,12 +61,9 @@ func UpdateQuery(namespace, table string, sets, keys []*pb.Field) string {
 
 	query.WriteString(" where \"")
 
-	for i := 0; i < len(keys); i++ {
-		k := i + j
-		field := keys[i]
-
+	for i, field := range keys {
 		query.WriteString(field.Name)
-		query.WriteString("\"=$" + strconv.Itoa(k+1))
+		query.WriteString("\"=$" + strconv.Itoa(n+i))
 		if i != len(keys)-1 {
 			query.WriteString(" and \"")
 		}
@@ -64,3 +64,10 @@ func TestUpdateQuery(t *testing.T) {
 		t.Fatalf("not expected %q", q)
 	}
 }
+
+func TestPatchUpdateQuery(t *testing.T) {
+	q := PatchUpdateQuery("public", "my_table", []*pb.Field{{Name: "f1"}, {Name: "f2"}, {Name: "f3"}}, []int{0, 2, 1}, []*pb.Field{{Name: "f4"}})
+	if q != `update "public"."my_table" set "f1"=$1,"f2"=jsonb_set(jsonb_set("f2",$2::text[],$3::jsonb),$4::text[],$5::jsonb),"f3"=jsonb_set("f3",$6::text[],$7::jsonb) where "f4"=$8` {
+		t.Fatalf("not expected %q", q)
+	}
+}
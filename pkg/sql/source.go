@@ -1,26 +1,130 @@
 package sql
 
+import "strings"
+
 var QueryAttrTypeOID = `SELECT nspname, relname, attname, atttypid
 FROM pg_catalog.pg_namespace n
 JOIN pg_catalog.pg_class c ON c.relnamespace = n.oid AND c.relkind = 'r'
 JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 and a.attisdropped = false
 WHERE n.nspname NOT IN ('pg_catalog', 'information_schema', 'pglogical') AND n.nspname !~ '^pg_toast';`
 
+// QueryColumnMeta lists the formatted types, like numeric(10,2), the nullability and the default expressions of the
+// columns of tables in their order, and whether they are filled by the server if omitted, by a default, an identity or
+// a generation expression. The defaults are in their source text, like nextval('t_id_seq'::regclass), while the
+// generated columns have no default. It reads the catalogs, which list all the columns regardless of the privileges.
+// The attgenerated requires PG12+, and the QueryColumnMetaBeforePG12 is for the older servers without generated columns.
+var QueryColumnMeta = `SELECT n.nspname::text, c.relname::text, a.attname::text, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+	CASE WHEN a.attgenerated = '' THEN pg_get_expr(d.adbin, d.adrelid) END, a.atthasdef OR a.attidentity <> ''
+FROM pg_catalog.pg_attribute a
+JOIN pg_catalog.pg_class c ON c.oid = a.attrelid AND c.relkind IN ('r', 'p')
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+WHERE a.attnum > 0 AND NOT a.attisdropped AND n.nspname NOT IN ('pg_catalog', 'information_schema', 'pglogical') AND n.nspname !~ '^pg_toast'
+ORDER BY n.nspname, c.relname, a.attnum;`
+
+var QueryColumnMetaBeforePG12 = strings.Replace(QueryColumnMeta, "a.attgenerated", "''", 1)
+
+var QueryTSConfig = `SELECT oid, oid::regconfig::text FROM pg_catalog.pg_ts_config;`
+
+var QueryPseudoTypes = `SELECT oid, typname FROM pg_catalog.pg_type WHERE typtype = 'p';`
+
+// QueryArrayBaseTypes lists the array types of enums and domains, with the base element type and its array type.
+// The labels of enums are the same as text in both the text and binary formats, and domains are resolved to their base types.
+var QueryArrayBaseTypes = `WITH RECURSIVE bases AS (
+	SELECT oid AS elem, oid AS base, typtype FROM pg_catalog.pg_type WHERE typtype IN ('e', 'd')
+	UNION ALL
+	SELECT b.elem, t.typbasetype, b.typtype FROM bases b JOIN pg_catalog.pg_type t ON t.oid = b.base AND t.typtype = 'd'
+)
+SELECT e.typarray,
+	CASE WHEN b.typtype = 'e' THEN 'pg_catalog.text'::regtype::oid ELSE b.base END,
+	CASE WHEN b.typtype = 'e' THEN 'pg_catalog._text'::regtype::oid ELSE bt.typarray END
+FROM bases b
+JOIN pg_catalog.pg_type e ON e.oid = b.elem AND e.typarray <> 0
+JOIN pg_catalog.pg_type bt ON bt.oid = b.base AND bt.typtype <> 'd' AND (b.typtype = 'e' OR bt.typarray <> 0);`
+
+// QueryRangeBaseTypes lists the range types not built in, with the base type of their subtypes resolving the domains,
+// and the built-in range type of the base type, or 0 if there is none. The bounds of a range are in the formats of its
+// subtype, which are the same as its base type, so the range is also the same as the built-in range of the base type.
+var QueryRangeBaseTypes = `WITH RECURSIVE bases AS (
+	SELECT r.rngtypid AS rng, r.rngsubtype AS base FROM pg_catalog.pg_range r JOIN pg_catalog.pg_type t ON t.oid = r.rngtypid
+	WHERE t.typnamespace <> 'pg_catalog'::regnamespace
+	UNION ALL
+	SELECT b.rng, t.typbasetype FROM bases b JOIN pg_catalog.pg_type t ON t.oid = b.base AND t.typtype = 'd'
+)
+SELECT b.rng, b.base, COALESCE((
+	SELECT r.rngtypid FROM pg_catalog.pg_range r JOIN pg_catalog.pg_type t ON t.oid = r.rngtypid
+	WHERE r.rngsubtype = b.base AND t.typnamespace = 'pg_catalog'::regnamespace LIMIT 1
+), 0)
+FROM bases b JOIN pg_catalog.pg_type t ON t.oid = b.base AND t.typtype <> 'd';`
+
+var QueryMisconfiguredReplicaIdentity = `SELECT nspname, relname, relreplident::text
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind = 'r' AND n.nspname NOT IN ('pg_catalog', 'information_schema', 'pglogical', 'pgcapture') AND n.nspname !~ '^pg_toast'
+AND (c.relreplident = 'n' OR (c.relreplident = 'd' AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_index i WHERE i.indrelid = c.oid AND i.indisprimary)))
+ORDER BY nspname, relname;`
+
 var QueryIdentityKeys = `SELECT
 	nspname,
 	relname,
 	array(select attname from pg_catalog.pg_attribute where attrelid = i.indrelid AND attnum > 0 AND attnum = ANY(i.indkey)) as keys,
 	array(select column_name::text from information_schema.columns where table_schema = n.nspname AND table_name = c.relname AND identity_generation IS NOT NULL) as identity_generation_columns,
-	array(select column_name::text from information_schema.columns where table_schema = n.nspname AND table_name = c.relname AND is_generated = 'ALWAYS') as generated_columns
+	array(select column_name::text from information_schema.columns where table_schema = n.nspname AND table_name = c.relname AND is_generated = 'ALWAYS') as generated_columns,
+	array(select attname::text from pg_catalog.pg_attribute where attrelid = c.oid AND attnum > 0 AND NOT attisdropped AND pg_catalog.pg_get_serial_sequence(format('%I.%I', n.nspname, c.relname), attname) IS NOT NULL) as sequence_columns
 FROM pg_catalog.pg_index i
 JOIN pg_catalog.pg_class c ON c.oid = i.indrelid AND c.relkind = 'r'
 JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace AND n.nspname NOT IN ('pg_catalog', 'information_schema', 'pglogical') AND n.nspname !~ '^pg_toast'
 WHERE (i.indisprimary OR i.indisunique) AND i.indisvalid AND i.indpred IS NULL ORDER BY indisprimary;`
 
+// AdvanceSequence advances the ascending sequence owned by the column $2 of the table $1 to the value $3 if its next
+// value is not beyond $3, which is the seqstart if it is never called, and skips the values out of its bounds.
+// The pg_sequence requires PG10+.
+var AdvanceSequence = `SELECT pg_catalog.setval(s, $3::bigint) FROM pg_catalog.pg_get_serial_sequence($1, $2) AS s
+JOIN pg_catalog.pg_sequence q ON q.seqrelid = s::regclass
+WHERE q.seqincrement > 0 AND $3::bigint BETWEEN q.seqstart AND q.seqmax
+AND $3::bigint > COALESCE(pg_catalog.pg_sequence_last_value(s::regclass), q.seqstart - 1);`
+
+// QuerySlotWALStatus requires PG13+, and the "lost" status means the slot is invalidated by the max_slot_wal_keep_size
+var QuerySlotWALStatus = `SELECT wal_status FROM pg_catalog.pg_replication_slots WHERE slot_name = $1;`
+
+// QueryOldestOpenTransaction returns the pid, the xid and the age in seconds of the oldest transaction having an xid
+// among the ones visible to the current role
+var QueryOldestOpenTransaction = `SELECT pid, backend_xid::text::bigint, extract(epoch FROM clock_timestamp() - xact_start)::float8
+FROM pg_catalog.pg_stat_activity WHERE backend_xid IS NOT NULL AND xact_start IS NOT NULL ORDER BY xact_start LIMIT 1;`
+
 var CreateLogicalSlot = `SELECT pg_create_logical_replication_slot($1, $2);`
 
 var CreatePublication = `CREATE PUBLICATION %s FOR ALL TABLES;`
 
+// CreatePublicationForTables and AlterPublicationTables are followed by the list of tables, optionally with the
+// WHERE row filters of PG15+
+var CreatePublicationForTables = `CREATE PUBLICATION %s FOR TABLE `
+
+var AlterPublicationTables = `ALTER PUBLICATION %s SET TABLE `
+
+// QueryPublicationAllTables returns whether the publication $1 is created FOR ALL TABLES, whose tables can not be altered
+var QueryPublicationAllTables = `SELECT puballtables FROM pg_catalog.pg_publication WHERE pubname = $1;`
+
+// QueryReplicaIdentityColumns returns the columns of the replica identity and all the columns of the table $1,
+// and the replica identity of FULL includes all the columns
+var QueryReplicaIdentityColumns = `SELECT
+	array(SELECT a.attname::text FROM pg_catalog.pg_attribute a WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped AND (c.relreplident = 'f' OR a.attnum = ANY(
+		SELECT unnest(i.indkey) FROM pg_catalog.pg_index i WHERE i.indrelid = c.oid AND ((c.relreplident = 'd' AND i.indisprimary) OR (c.relreplident = 'i' AND i.indisreplident))
+	))) AS identity_columns,
+	array(SELECT a.attname::text FROM pg_catalog.pg_attribute a WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped) AS columns
+FROM pg_catalog.pg_class c WHERE c.oid = $1::regclass;`
+
 var InstallExtension = `CREATE EXTENSION IF NOT EXISTS pgcapture;`
 
 var ServerVersionNum = `SHOW server_version_num;`
+
+var QueryLargeObject = `SELECT pg_catalog.lo_get($1::oid, 0, $2::int);`
+
+var QueryRoles = `SELECT rolname::text FROM pg_catalog.pg_roles;`
+
+// QueryTableAttrTypeOID is the QueryAttrTypeOID of the single table $1.$2, for loading the column types on demand
+var QueryTableAttrTypeOID = `SELECT attname, atttypid
+FROM pg_catalog.pg_namespace n
+JOIN pg_catalog.pg_class c ON c.relnamespace = n.oid AND c.relkind = 'r'
+JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 and a.attisdropped = false
+WHERE n.nspname = $1 AND c.relname = $2;`